@@ -0,0 +1,408 @@
+package magstripe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrBlankTrack is returned when a bit stream holds no data
+var ErrBlankTrack = errors.New("blank track")
+
+// Bits is a track bit stream, one bit (0 or 1) per element, in the order the
+// bits pass the read head
+type Bits []byte
+
+// BitsFromRaw unpacks raw track bytes into a bit stream. Each byte carries
+// bitsPerByte bits (the BPC set on the device), least significant bit first.
+func BitsFromRaw(raw string, bitsPerByte int) Bits {
+	if bitsPerByte <= 0 || bitsPerByte > 8 {
+		bitsPerByte = 8
+	}
+	bits := make(Bits, 0, len(raw)*bitsPerByte)
+	for i := 0; i < len(raw); i++ {
+		for b := 0; b < bitsPerByte; b++ {
+			bits = append(bits, (raw[i]>>b)&1)
+		}
+	}
+	return bits
+}
+
+// Reverse returns the bit stream in the opposite direction, as read from a
+// card swiped backwards
+func (b Bits) Reverse() Bits {
+	r := make(Bits, len(b))
+	for i, bit := range b {
+		r[len(b)-1-i] = bit
+	}
+	return r
+}
+
+// String renders the bits as a string of '0' and '1'
+func (b Bits) String() string {
+	var sb strings.Builder
+	for _, bit := range b {
+		sb.WriteByte('0' + bit)
+	}
+	return sb.String()
+}
+
+// Parity is the parity sense of a character encoding
+type Parity int
+
+const (
+	NoParity Parity = iota
+	OddParity
+	EvenParity
+)
+
+func (p Parity) String() string {
+	switch p {
+	case OddParity:
+		return "odd"
+	case EvenParity:
+		return "even"
+	}
+	return "none"
+}
+
+// MarshalText encodes the parity as "none", "odd" or "even"
+func (p Parity) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes "none", "odd" or "even"
+func (p *Parity) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "none":
+		*p = NoParity
+	case "odd":
+		*p = OddParity
+	case "even":
+		*p = EvenParity
+	default:
+		return fmt.Errorf("invalid parity %q", text)
+	}
+	return nil
+}
+
+// DecodeOptions restricts the encodings tried by DecodeBits. Zero values try
+// everything.
+type DecodeOptions struct {
+	// Widths lists the data bits per character to try (default 4 and 6, as
+	// used by the ISO 5 and 7 bit encodings, unless Charsets are given)
+	Widths []int
+	// Parities lists the parity senses to try (default odd, even and none)
+	Parities []Parity
+	// Charsets lists charsets to try in addition to the widths
+	Charsets []*Charset
+	// ForwardOnly disables trying the reversed bit stream
+	ForwardOnly bool
+}
+
+// DecodedChar is one character of a decoded bit stream
+type DecodedChar struct {
+	// Code is the value of the data bits
+	Code byte
+	// Char is the character of the code in the charset, or 0
+	Char byte
+	// Offset is the position of the first bit in the (possibly reversed) stream
+	Offset int
+	// ParityError is set when the parity bit does not match
+	ParityError bool
+	// Confidence is an estimate between 0 and 1 that the character was read correctly
+	Confidence float64
+}
+
+// BitDecoding is the result of decoding a bit stream
+type BitDecoding struct {
+	// Charset is the name of the charset used, empty when detected by width
+	Charset  string
+	Width    int
+	Parity   Parity
+	Reversed bool
+	// Start is the offset of the first character after leading zeros
+	Start int
+	Chars []DecodedChar
+	// EndSentinel is set when an ISO end sentinel was found
+	EndSentinel bool
+	// LRC is the longitudinal redundancy check character following the end sentinel
+	LRC *DecodedChar
+	// LRCError is set when the LRC does not match the data
+	LRCError bool
+	// Padding is the number of zero characters after the data
+	Padding int
+	// Confidence is an estimate between 0 and 1 that the encoding was detected correctly
+	Confidence float64
+}
+
+// Text returns the decoded characters, using '?' for codes without a character
+// and dropping the LRC
+func (d *BitDecoding) Text() string {
+	var sb strings.Builder
+	for _, c := range d.Chars {
+		if c.Char != 0 {
+			sb.WriteByte(c.Char)
+		} else {
+			fmt.Fprintf(&sb, "<%02x>", c.Code)
+		}
+	}
+	return sb.String()
+}
+
+// RawData converts the decoding to the RawData returned by UnpackRaw: one byte
+// per character ('~' for codes without a character), with '^' marking parity
+// errors
+func (d *BitDecoding) RawData() RawData {
+	data := make([]byte, len(d.Chars))
+	parity := make([]byte, len(d.Chars))
+	for i, c := range d.Chars {
+		data[i] = c.Char
+		if data[i] == 0 {
+			data[i] = '~'
+		}
+		parity[i] = ' '
+		if c.ParityError {
+			parity[i] = '^'
+		}
+	}
+	return RawData{
+		Data:         string(data),
+		TotalLength:  len(d.Chars) + d.Padding,
+		ParityErrors: string(parity),
+		LRCError:     d.LRCError,
+	}
+}
+
+// ParityErrors returns the number of characters with a parity error
+func (d *BitDecoding) ParityErrors() int {
+	n := 0
+	for _, c := range d.Chars {
+		if c.ParityError {
+			n++
+		}
+	}
+	return n
+}
+
+// DecodeBits decodes a bit stream whose encoding is unknown. Every charset in
+// opts and every combination of width and parity, in both directions, is tried
+// and the decoding that best explains the data is returned: parity agreement,
+// known start and end sentinels and a matching LRC all raise the score. Widths
+// 4 and 6 use the ISO symbols and sentinels.
+func DecodeBits(bits Bits, opts *DecodeOptions) (*BitDecoding, error) {
+	if opts == nil {
+		opts = &DecodeOptions{}
+	}
+	widths := opts.Widths
+	if len(widths) == 0 && len(opts.Charsets) == 0 {
+		widths = []int{4, 6}
+	}
+	parities := opts.Parities
+	if len(parities) == 0 {
+		parities = []Parity{OddParity, EvenParity, NoParity}
+	}
+
+	candidates := append([]*Charset(nil), opts.Charsets...)
+	for _, width := range widths {
+		if width < 1 || width > 8 {
+			return nil, fmt.Errorf("invalid character width %d", width)
+		}
+		for _, parity := range parities {
+			candidates = append(candidates, widthCharset(width, parity))
+		}
+	}
+
+	var best *BitDecoding
+	for _, reversed := range []bool{false, true} {
+		if reversed && opts.ForwardOnly {
+			break
+		}
+		stream := bits
+		if reversed {
+			stream = bits.Reverse()
+		}
+		for _, cs := range candidates {
+			d := decodeWith(stream, cs, false)
+			if d == nil {
+				continue
+			}
+			d.Reversed = reversed
+			if best == nil || d.Confidence > best.Confidence {
+				best = d
+			}
+		}
+	}
+	if best == nil {
+		return nil, ErrBlankTrack
+	}
+	return best, nil
+}
+
+// DecodeBitsWith decodes a bit stream with a known width and parity
+func DecodeBitsWith(bits Bits, width int, parity Parity, reversed bool) (*BitDecoding, error) {
+	if width < 1 || width > 8 {
+		return nil, fmt.Errorf("invalid character width %d", width)
+	}
+	if reversed {
+		bits = bits.Reverse()
+	}
+	d := decodeWith(bits, widthCharset(width, parity), false)
+	if d == nil {
+		return nil, ErrBlankTrack
+	}
+	d.Reversed = reversed
+	return d, nil
+}
+
+// widthCharset returns an unnamed charset for a width and parity, with the ISO
+// symbols and sentinels for widths 4 and 6
+func widthCharset(width int, parity Parity) *Charset {
+	cs := &Charset{Bits: width, Parity: parity}
+	var iso *Charset
+	switch width {
+	case CharsetABA.Bits:
+		iso = CharsetABA
+	case CharsetIATA.Bits:
+		iso = CharsetIATA
+	}
+	if iso != nil {
+		cs.Symbols, cs.Start, cs.End, cs.LRC = iso.Symbols, iso.Start, iso.End, iso.LRC
+	}
+	return cs
+}
+
+// decodeWith decodes the characters of cs, least significant bit first. The
+// first character holds the first 1 bit, which may be any of its bits, so each
+// alignment is tried and the best scoring one kept.
+func decodeWith(bits Bits, cs *Charset, lastIsLRC bool) *BitDecoding {
+	first := 0
+	for first < len(bits) && bits[first] == 0 {
+		first++
+	}
+	if first == len(bits) {
+		return nil
+	}
+
+	var best *BitDecoding
+	for start := first; start >= 0 && start > first-cs.CharBits(); start-- {
+		d := decodeAt(bits, cs, lastIsLRC, start)
+		if d != nil && (best == nil || d.Confidence > best.Confidence) {
+			best = d
+		}
+	}
+	return best
+}
+
+// decodeAt decodes the characters of cs starting at bit start. The character
+// after the end sentinel is the LRC; if lastIsLRC is set and there is no end
+// sentinel, the last character is.
+func decodeAt(bits Bits, cs *Charset, lastIsLRC bool, start int) *BitDecoding {
+	width, parity, size := cs.Bits, cs.Parity, cs.CharBits()
+	d := &BitDecoding{Charset: cs.Name, Width: width, Parity: parity, Start: start}
+
+	startCode, endCode := cs.sentinelCode(cs.Start), cs.sentinelCode(cs.End)
+	pos := start
+	for ; pos+size <= len(bits); pos += size {
+		chunk := bits[pos : pos+size]
+		if isZero(chunk) {
+			break
+		}
+
+		var code byte
+		ones := 0
+		for i := 0; i < width; i++ {
+			code |= chunk[i] << i
+			ones += int(chunk[i])
+		}
+		c := DecodedChar{Code: code, Offset: pos, Char: cs.symbol(code)}
+		if parity != NoParity {
+			ones += int(chunk[width])
+			c.ParityError = (parity == OddParity) != (ones%2 == 1)
+		}
+		c.Confidence = charConfidence(c, parity)
+
+		if d.EndSentinel {
+			d.LRC = &c
+			pos += size
+			break
+		}
+		d.Chars = append(d.Chars, c)
+		if endCode >= 0 && int(code) == endCode {
+			d.EndSentinel = true
+		}
+	}
+	if lastIsLRC && d.LRC == nil && !d.EndSentinel && len(d.Chars) > 1 {
+		d.LRC = &d.Chars[len(d.Chars)-1]
+		d.Chars = d.Chars[:len(d.Chars)-1]
+	}
+	if len(d.Chars) == 0 {
+		return nil
+	}
+
+	// Count the zero characters padding the rest of the track
+	for ; pos+size <= len(bits) && isZero(bits[pos:pos+size]); pos += size {
+		d.Padding++
+	}
+
+	if d.LRC != nil {
+		var lrc byte
+		for _, c := range d.Chars {
+			lrc ^= c.Code
+		}
+		d.LRCError = d.LRC.Code != lrc || d.LRC.ParityError
+	} else if d.EndSentinel && cs.LRC == LRCXor {
+		d.LRCError = true
+	}
+
+	d.Confidence = decodingConfidence(d, parity, startCode)
+	return d
+}
+
+func isZero(bits Bits) bool {
+	for _, b := range bits {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// charConfidence estimates how likely a single character was read correctly
+func charConfidence(c DecodedChar, parity Parity) float64 {
+	switch {
+	case parity == NoParity:
+		return 0.5
+	case c.ParityError:
+		return 0.1
+	}
+	return 1
+}
+
+// decodingConfidence scores how well an encoding explains the stream
+func decodingConfidence(d *BitDecoding, parity Parity, startCode int) float64 {
+	n := float64(len(d.Chars))
+	good := n - float64(d.ParityErrors())
+
+	// Parity agreement is the main signal; without a parity bit there is no
+	// evidence either way
+	score := 0.5
+	if parity != NoParity {
+		score = good / n
+	}
+
+	// Short decodings agree with any parity by chance
+	if n < 4 {
+		score *= n / 4
+	}
+	if startCode >= 0 && d.Chars[0].Code == byte(startCode) {
+		score += 0.2
+	}
+	if d.EndSentinel {
+		score += 0.1
+		if !d.LRCError {
+			score += 0.2
+		}
+	}
+	return score / 1.5
+}
@@ -0,0 +1,153 @@
+package magstripe
+
+import (
+	"testing"
+)
+
+// encodeBits builds a track bit stream: leading zeros, characters of width data
+// bits plus parity (LSB first), an LRC character and trailing zeros
+func encodeBits(codes []byte, width int, parity Parity, withLRC bool) Bits {
+	bits := make(Bits, 20)
+	var lrc byte
+	emit := func(code byte) {
+		ones := 0
+		for i := 0; i < width; i++ {
+			bit := (code >> i) & 1
+			bits = append(bits, bit)
+			ones += int(bit)
+		}
+		switch parity {
+		case OddParity:
+			bits = append(bits, byte(1-ones%2))
+		case EvenParity:
+			bits = append(bits, byte(ones%2))
+		}
+	}
+	for _, c := range codes {
+		emit(c)
+		lrc ^= c
+	}
+	if withLRC {
+		emit(lrc)
+	}
+	return append(bits, make(Bits, 20)...)
+}
+
+// abaCodes converts a track 2 string to 4 bit codes
+func abaCodes(s string) []byte {
+	codes := make([]byte, len(s))
+	for i := range s {
+		codes[i] = s[i] - '0'
+	}
+	return codes
+}
+
+// iataCodes converts a track 1 string to 6 bit codes
+func iataCodes(s string) []byte {
+	codes := make([]byte, len(s))
+	for i := range s {
+		codes[i] = s[i] - ' '
+	}
+	return codes
+}
+
+func TestBitsFromRaw(t *testing.T) {
+	bits := BitsFromRaw("\x05\x80", 8)
+	if got := bits.String(); got != "1010000000000001" {
+		t.Errorf("unexpected bits %s", got)
+	}
+	bits = BitsFromRaw("\x1f", 5)
+	if got := bits.String(); got != "11111" {
+		t.Errorf("unexpected 5 bit unpack %s", got)
+	}
+	if got := bits.Reverse().String(); got != "11111" {
+		t.Errorf("unexpected reverse %s", got)
+	}
+}
+
+func TestDecodeBitsISO(t *testing.T) {
+	tests := []struct {
+		name  string
+		bits  Bits
+		width int
+		text  string
+	}{
+		{"track 2", encodeBits(abaCodes(";4111111111111111=2512101?"), 4, OddParity, true), 4, ";4111111111111111=2512101?"},
+		{"track 1", encodeBits(iataCodes("%B4111111111111111^DOE/JOHN^2512101?"), 6, OddParity, true), 6, "%B4111111111111111^DOE/JOHN^2512101?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, reversed := range []bool{false, true} {
+				bits := tt.bits
+				if reversed {
+					bits = bits.Reverse()
+				}
+				d, err := DecodeBits(bits, nil)
+				if err != nil {
+					t.Fatalf("DecodeBits: %v", err)
+				}
+				if d.Width != tt.width || d.Parity != OddParity || d.Reversed != reversed {
+					t.Errorf("detected width %d parity %v reversed %v", d.Width, d.Parity, d.Reversed)
+				}
+				if d.Text() != tt.text {
+					t.Errorf("expected %q, got %q", tt.text, d.Text())
+				}
+				if !d.EndSentinel || d.LRCError || d.LRC == nil {
+					t.Errorf("expected valid end sentinel and LRC: %+v", d)
+				}
+				if d.Confidence < 0.99 {
+					t.Errorf("expected high confidence, got %f", d.Confidence)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeBitsCustomWidth(t *testing.T) {
+	codes := []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x7f}
+	bits := encodeBits(codes, 7, EvenParity, false)
+
+	d, err := DecodeBits(bits, &DecodeOptions{Widths: []int{4, 6, 7}})
+	if err != nil {
+		t.Fatalf("DecodeBits: %v", err)
+	}
+	if d.Width != 7 || d.Parity != EvenParity {
+		t.Fatalf("detected width %d parity %v", d.Width, d.Parity)
+	}
+	for i, c := range d.Chars {
+		if c.Code != codes[i] || c.ParityError || c.Char != 0 {
+			t.Errorf("char %d: unexpected %+v", i, c)
+		}
+	}
+	if d.Text() != "<11><22><33><44><55><66><77><7f>" {
+		t.Errorf("unexpected text %q", d.Text())
+	}
+}
+
+func TestDecodeBitsErrors(t *testing.T) {
+	bits := encodeBits(abaCodes(";1234567890?"), 4, OddParity, true)
+	// Flip a data bit of the third character
+	bits[20+2*5] ^= 1
+
+	d, err := DecodeBitsWith(bits, 4, OddParity, false)
+	if err != nil {
+		t.Fatalf("DecodeBitsWith: %v", err)
+	}
+	if d.ParityErrors() != 1 || !d.Chars[2].ParityError {
+		t.Errorf("expected a parity error on char 2: %+v", d.Chars)
+	}
+	if d.Chars[2].Confidence >= d.Chars[1].Confidence {
+		t.Error("character with parity error should have lower confidence")
+	}
+	if !d.LRCError {
+		t.Error("expected LRC error")
+	}
+
+	if _, err := DecodeBits(make(Bits, 100), nil); err != ErrBlankTrack {
+		t.Errorf("expected ErrBlankTrack, got %v", err)
+	}
+	if _, err := DecodeBits(bits, &DecodeOptions{Widths: []int{9}}); err == nil {
+		t.Error("expected error for invalid width")
+	}
+}
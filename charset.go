@@ -0,0 +1,274 @@
+package magstripe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LRCRule selects how a charset computes its longitudinal redundancy check
+type LRCRule int
+
+const (
+	// LRCNone means no LRC character follows the data
+	LRCNone LRCRule = iota
+	// LRCXor is the ISO 7811 rule: the XOR of all data codes, with its own parity bit
+	LRCXor
+)
+
+func (r LRCRule) String() string {
+	if r == LRCXor {
+		return "xor"
+	}
+	return "none"
+}
+
+// MarshalText encodes the rule as "none" or "xor"
+func (r LRCRule) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText decodes "none" or "xor"
+func (r *LRCRule) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "none":
+		*r = LRCNone
+	case "xor":
+		*r = LRCXor
+	default:
+		return fmt.Errorf("invalid LRC rule %q", text)
+	}
+	return nil
+}
+
+// Charset describes how characters are encoded on a track
+type Charset struct {
+	// Name identifies the charset for LookupCharset and the CLI
+	Name string `json:"name"`
+	// Symbols maps each code to its character: Symbols[code]
+	Symbols string `json:"symbols"`
+	// Bits is the number of data bits per character, excluding parity
+	Bits int `json:"bits"`
+	// Parity is the parity sense of the bit following the data bits
+	Parity Parity `json:"parity"`
+	// Start and End are the sentinel characters, or 0 if the charset has none
+	Start byte `json:"start,omitempty"`
+	End   byte `json:"end,omitempty"`
+	// LRC is the check character rule
+	LRC LRCRule `json:"lrc"`
+}
+
+// Built-in charsets
+var (
+	// CharsetIATA is the ISO 7811 alphanumeric encoding used on track 1
+	CharsetIATA = &Charset{
+		Name:    "iata",
+		Symbols: " !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_",
+		Bits:    6,
+		Parity:  OddParity,
+		Start:   '%',
+		End:     '?',
+		LRC:     LRCXor,
+	}
+
+	// CharsetABA is the ISO 7811 numeric encoding used on tracks 2 and 3
+	CharsetABA = &Charset{
+		Name:    "aba",
+		Symbols: "0123456789:;<=>?",
+		Bits:    4,
+		Parity:  OddParity,
+		Start:   ';',
+		End:     '?',
+		LRC:     LRCXor,
+	}
+)
+
+var (
+	charsetsMu sync.RWMutex
+	charsets   = map[string]*Charset{
+		CharsetIATA.Name: CharsetIATA,
+		CharsetABA.Name:  CharsetABA,
+	}
+)
+
+// RegisterCharset makes a charset available to LookupCharset. Built-in
+// charsets cannot be replaced.
+func RegisterCharset(c *Charset) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	charsetsMu.Lock()
+	defer charsetsMu.Unlock()
+	if existing, ok := charsets[c.Name]; ok && (existing == CharsetIATA || existing == CharsetABA) {
+		return fmt.Errorf("charset %q is built in", c.Name)
+	}
+	charsets[c.Name] = c
+	return nil
+}
+
+// LookupCharset returns the registered charset with the given name
+func LookupCharset(name string) (*Charset, bool) {
+	charsetsMu.RLock()
+	defer charsetsMu.RUnlock()
+	c, ok := charsets[name]
+	return c, ok
+}
+
+// CharsetNames returns the names of the registered charsets in sorted order
+func CharsetNames() []string {
+	charsetsMu.RLock()
+	defer charsetsMu.RUnlock()
+	names := make([]string, 0, len(charsets))
+	for name := range charsets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadCharset reads a charset definition in JSON, e.g.
+// {"name":"hotel","symbols":"0123456789ABCDEF","bits":4,"parity":"odd","lrc":"none"}
+func LoadCharset(r io.Reader) (*Charset, error) {
+	var c Charset
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("invalid charset definition: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate checks that the charset is usable
+func (c *Charset) Validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("charset has no name")
+	case c.Bits < 1 || c.Bits > 8:
+		return fmt.Errorf("charset %s: invalid bits per character %d", c.Name, c.Bits)
+	case len(c.Symbols) > 1<<c.Bits:
+		return fmt.Errorf("charset %s: %d symbols do not fit in %d bits", c.Name, len(c.Symbols), c.Bits)
+	case c.Parity < NoParity || c.Parity > EvenParity:
+		return fmt.Errorf("charset %s: invalid parity %d", c.Name, c.Parity)
+	case c.LRC < LRCNone || c.LRC > LRCXor:
+		return fmt.Errorf("charset %s: invalid LRC rule %d", c.Name, c.LRC)
+	}
+	for _, s := range []byte{c.Start, c.End} {
+		if s != 0 && strings.IndexByte(c.Symbols, s) == -1 {
+			return fmt.Errorf("charset %s: sentinel %q is not a symbol", c.Name, s)
+		}
+	}
+	return nil
+}
+
+// CharBits returns the number of bits per character including parity
+func (c *Charset) CharBits() int {
+	if c.Parity == NoParity {
+		return c.Bits
+	}
+	return c.Bits + 1
+}
+
+// code returns the code of a symbol, or -1
+func (c *Charset) code(ch byte) int {
+	return strings.IndexByte(c.Symbols, ch)
+}
+
+// symbol returns the character of a code, or 0 if it has none
+func (c *Charset) symbol(code byte) byte {
+	if int(code) < len(c.Symbols) {
+		return c.Symbols[code]
+	}
+	return 0
+}
+
+// sentinelCode returns the code of a sentinel character, or -1 if there is none
+func (c *Charset) sentinelCode(s byte) int {
+	if s == 0 {
+		return -1
+	}
+	return c.code(s)
+}
+
+// Encode converts text into a bit stream of characters (LSB first, then
+// parity) followed by the LRC. Sentinels are not added; include them in text.
+func (c *Charset) Encode(text string) (Bits, error) {
+	bits := make(Bits, 0, (len(text)+1)*c.CharBits())
+	var lrc byte
+	for i := 0; i < len(text); i++ {
+		code := c.code(text[i])
+		if code == -1 {
+			return nil, fmt.Errorf("character %q at position %d is not in charset %s", text[i], i, c.Name)
+		}
+		bits = c.appendChar(bits, byte(code))
+		lrc ^= byte(code)
+	}
+	if c.LRC == LRCXor {
+		bits = c.appendChar(bits, lrc)
+	}
+	return bits, nil
+}
+
+// appendChar appends the data and parity bits of code
+func (c *Charset) appendChar(bits Bits, code byte) Bits {
+	ones := 0
+	for i := 0; i < c.Bits; i++ {
+		bit := (code >> i) & 1
+		bits = append(bits, bit)
+		ones += int(bit)
+	}
+	switch c.Parity {
+	case OddParity:
+		bits = append(bits, byte(1-ones%2))
+	case EvenParity:
+		bits = append(bits, byte(ones%2))
+	}
+	return bits
+}
+
+// Decode decodes a forward bit stream with this charset. When the LRC rule is
+// set, the character after the end sentinel (or the last character if there is
+// no end sentinel) is checked as the LRC.
+func (c *Charset) Decode(bits Bits) (*BitDecoding, error) {
+	d := decodeWith(bits, c, c.LRC == LRCXor)
+	if d == nil {
+		return nil, ErrBlankTrack
+	}
+	return d, nil
+}
+
+// Pack encodes text into raw bytes of bitsPerByte bits for WriteRawTracks,
+// with leadingZeros zero bits before the data
+func (c *Charset) Pack(text string, bitsPerByte, leadingZeros int) (string, error) {
+	bits, err := c.Encode(text)
+	if err != nil {
+		return "", err
+	}
+	return PackBits(append(make(Bits, leadingZeros), bits...), bitsPerByte), nil
+}
+
+// Unpack decodes a track returned by ReadRawTracks holding bitsPerByte bits per byte
+func (c *Charset) Unpack(raw string, bitsPerByte int) (*BitDecoding, error) {
+	return c.Decode(BitsFromRaw(raw, bitsPerByte))
+}
+
+// PackBits packs a bit stream into bytes of bitsPerByte bits, least significant
+// bit first, padding the last byte with zeros. It is the inverse of BitsFromRaw.
+func PackBits(bits Bits, bitsPerByte int) string {
+	if bitsPerByte <= 0 || bitsPerByte > 8 {
+		bitsPerByte = 8
+	}
+	out := make([]byte, 0, (len(bits)+bitsPerByte-1)/bitsPerByte)
+	for i := 0; i < len(bits); i += bitsPerByte {
+		var b byte
+		for j := 0; j < bitsPerByte && i+j < len(bits); j++ {
+			b |= bits[i+j] << j
+		}
+		out = append(out, b)
+	}
+	return string(out)
+}
@@ -0,0 +1,144 @@
+package magstripe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuiltinCharsets(t *testing.T) {
+	for _, name := range []string{"iata", "aba"} {
+		c, ok := LookupCharset(name)
+		if !ok {
+			t.Fatalf("charset %s not registered", name)
+		}
+		if err := c.Validate(); err != nil {
+			t.Errorf("charset %s invalid: %v", name, err)
+		}
+	}
+	if len(CharsetIATA.Symbols) != 64 || CharsetIATA.Symbols[12] != ',' || CharsetIATA.Symbols[13] != '-' {
+		t.Error("IATA symbols do not follow ISO 7811")
+	}
+	if CharsetIATA.CharBits() != 7 || CharsetABA.CharBits() != 5 {
+		t.Error("ISO charsets should use 7 and 5 bits per character")
+	}
+}
+
+func TestCharsetEncodeDecode(t *testing.T) {
+	tests := []struct {
+		charset *Charset
+		text    string
+	}{
+		{CharsetIATA, "%B4111111111111111^DOE/JOHN^2512101?"},
+		{CharsetABA, ";4111111111111111=2512101?"},
+		{CharsetABA, "0123"},
+	}
+
+	for _, tt := range tests {
+		bits, err := tt.charset.Encode(tt.text)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", tt.text, err)
+		}
+		if len(bits) != (len(tt.text)+1)*tt.charset.CharBits() {
+			t.Errorf("%q: unexpected bit length %d", tt.text, len(bits))
+		}
+
+		padded := append(append(make(Bits, 15), bits...), make(Bits, 30)...)
+		d, err := tt.charset.Decode(padded)
+		if err != nil {
+			t.Fatalf("Decode(%q): %v", tt.text, err)
+		}
+		if d.Text() != tt.text || d.LRC == nil || d.LRCError || d.ParityErrors() != 0 {
+			t.Errorf("%q: unexpected decoding %q (LRC error %v)", tt.text, d.Text(), d.LRCError)
+		}
+		if d.Charset != tt.charset.Name {
+			t.Errorf("%q: decoded with charset %q", tt.text, d.Charset)
+		}
+	}
+
+	if _, err := CharsetABA.Encode(";12A?"); err == nil {
+		t.Error("expected error for character outside charset")
+	}
+}
+
+func TestCharsetPackUnpack(t *testing.T) {
+	for _, bpc := range []int{5, 7, 8} {
+		raw, err := CharsetABA.Pack(";123=456?", bpc, 10)
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		d, err := CharsetABA.Unpack(raw, bpc)
+		if err != nil {
+			t.Fatalf("Unpack: %v", err)
+		}
+		if d.Text() != ";123=456?" || d.LRCError {
+			t.Errorf("bpc %d: unexpected decoding %q", bpc, d.Text())
+		}
+	}
+
+	bits := Bits{1, 0, 1, 1, 0, 0, 1, 0, 1}
+	if got := BitsFromRaw(PackBits(bits, 7), 7); got.String()[:len(bits)] != bits.String() {
+		t.Errorf("PackBits is not the inverse of BitsFromRaw: %s", got)
+	}
+}
+
+func TestCustomCharset(t *testing.T) {
+	// Sentinels are single bytes, given as numbers in JSON ('B' and 'F')
+	def := `{"name":"hotel","symbols":"0123456789ABCDEF","bits":4,"parity":"even","start":66,"end":70,"lrc":"none"}`
+
+	c, err := LoadCharset(strings.NewReader(def))
+	if err != nil {
+		t.Fatalf("LoadCharset: %v", err)
+	}
+	if c.Parity != EvenParity || c.LRC != LRCNone || c.Start != 'B' {
+		t.Errorf("unexpected charset: %+v", c)
+	}
+	if err := RegisterCharset(c); err != nil {
+		t.Fatalf("RegisterCharset: %v", err)
+	}
+	if got, ok := LookupCharset("hotel"); !ok || got != c {
+		t.Error("registered charset not found")
+	}
+
+	bits, err := c.Encode("B12A9F")
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	d, err := DecodeBits(append(make(Bits, 8), bits...), &DecodeOptions{Charsets: []*Charset{c}})
+	if err != nil {
+		t.Fatalf("DecodeBits: %v", err)
+	}
+	if d.Charset != "hotel" || d.Text() != "B12A9F" || !d.EndSentinel {
+		t.Errorf("unexpected decoding %q with %q", d.Text(), d.Charset)
+	}
+}
+
+func TestCharsetValidation(t *testing.T) {
+	invalid := []*Charset{
+		{Symbols: "01", Bits: 1},
+		{Name: "wide", Symbols: "0", Bits: 9},
+		{Name: "crowded", Symbols: "0123", Bits: 1},
+		{Name: "sentinel", Symbols: "01", Bits: 1, Start: 'X'},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected validation error for %+v", c)
+		}
+	}
+	if err := RegisterCharset(&Charset{Name: "iata", Symbols: "0", Bits: 1}); err == nil {
+		t.Error("built-in charsets should not be replaceable")
+	}
+}
+
+func TestUnpackRawErrors(t *testing.T) {
+	raw := PackRaw("12345", Track23Map, 4, 8)
+	bits := BitsFromRaw(raw, 8)
+	bits[1] ^= 1
+	res := UnpackRaw(PackBits(bits, 8), Track23Map, 4, 8)
+
+	if res.ParityErrors[0] != '^' || !res.LRCError {
+		t.Errorf("expected parity and LRC errors: %+v", res)
+	}
+	if res.TotalLength < len(res.Data) {
+		t.Errorf("total length %d shorter than data", res.TotalLength)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/abrahan/magstripe-go"
+	"golang.org/x/term"
+)
+
+// passphraseEnv names the environment variable read before prompting
+const passphraseEnv = "MSR_PASSPHRASE"
+
+// keys gathers the passphrase and keys used to encrypt and decrypt files,
+// asking for the passphrase only when it is needed
+type keys struct {
+	encrypt    bool
+	recipient  string
+	identity   string
+	passphrase *string
+}
+
+// recipientFor returns who to encrypt written files for, or nil to write them
+// in clear
+func (k *keys) recipientFor() (magstripe.Recipient, error) {
+	switch {
+	case k.recipient != "":
+		return magstripe.ParseX25519Recipient(k.recipient)
+	case k.encrypt:
+		pass, err := k.readPassphrase(true)
+		if err != nil {
+			return nil, err
+		}
+		return magstripe.Passphrase(pass), nil
+	}
+	return nil, nil
+}
+
+// identities returns the keys to decrypt files with: the -identity key file
+// (as written by keygen), or else the passphrase
+func (k *keys) identities() ([]magstripe.Identity, error) {
+	if k.identity != "" {
+		data, err := os.ReadFile(k.identity)
+		if err != nil {
+			return nil, err
+		}
+		// key files hold the key after # comment lines
+		var key string
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				key = line
+				break
+			}
+		}
+		id, err := magstripe.ParseX25519Identity(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k.identity, err)
+		}
+		return []magstripe.Identity{id}, nil
+	}
+	pass, err := k.readPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	return []magstripe.Identity{magstripe.Passphrase(pass)}, nil
+}
+
+// readPassphrase takes the passphrase from the environment or prompts for it
+// on the terminal, twice when it is new
+func (k *keys) readPassphrase(confirm bool) (string, error) {
+	if k.passphrase != nil {
+		return *k.passphrase, nil
+	}
+	pass, ok := os.LookupEnv(passphraseEnv)
+	if !ok {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return "", fmt.Errorf("passphrase required: set %s", passphraseEnv)
+		}
+		var err error
+		if pass, err = prompt(fd, "Passphrase: "); err != nil {
+			return "", err
+		}
+		if confirm {
+			again, err := prompt(fd, "Confirm passphrase: ")
+			if err != nil {
+				return "", err
+			}
+			if again != pass {
+				return "", errors.New("passphrases do not match")
+			}
+		}
+	}
+	if pass == "" {
+		return "", errors.New("empty passphrase")
+	}
+	k.passphrase = &pass
+	return pass, nil
+}
+
+// prompt reads a line from the terminal without echo
+func prompt(fd int, text string) (string, error) {
+	fmt.Fprint(os.Stderr, text)
+	line, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return strings.TrimRight(string(line), "\r\n"), err
+}
+
+// keygen writes a new secret key to file and prints its public key
+func keygen(file string) error {
+	id, err := magstripe.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "# public key: %s\n%s\n", id.Recipient(), id); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Println(id.Recipient())
+	return nil
+}
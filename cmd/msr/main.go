@@ -1,33 +1,66 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/abrahan/magstripe-go"
 )
 
 func main() {
 	var (
-		read   = flag.Bool("r", false, "read magnetic tracks")
-		write  = flag.Bool("w", false, "write magnetic tracks")
-		erase  = flag.Bool("e", false, "erase magnetic tracks")
-		hico   = flag.Bool("C", false, "select high coercivity mode")
-		loco   = flag.Bool("c", false, "select low coercivity mode")
-		bpi    = flag.String("b", "", "bit per inch for each track (h or l)")
-		device = flag.String("d", "", "path to serial communication device")
-		raw    = flag.Bool("0", false, "do not use ISO encoding/decoding")
-		tracks = flag.String("t", "123", "select tracks (1, 2, 3, 12, 23, 13, 123)")
-		bpc    = flag.String("B", "", "bit per character for each track (5 to 8)")
-		help   = flag.Bool("help", false, "show help")
+		read    = flag.Bool("r", false, "read magnetic tracks")
+		write   = flag.Bool("w", false, "write magnetic tracks")
+		erase   = flag.Bool("e", false, "erase magnetic tracks")
+		hico    = flag.Bool("C", false, "select high coercivity mode")
+		loco    = flag.Bool("c", false, "select low coercivity mode")
+		bpi     = flag.String("b", "", "bit per inch for each track (h or l)")
+		device  = flag.String("d", "", "path to serial communication device")
+		raw     = flag.Bool("0", false, "do not use ISO encoding/decoding")
+		charset = flag.String("charset", "", "with -0, charset for all selected tracks: a registered name ("+strings.Join(magstripe.CharsetNames(), ", ")+") or a JSON definition file")
+		auto    = flag.Bool("auto", false, "with -0 -r, detect the encoding of each track (width, parity, direction)")
+		decode  = flag.Bool("decode", false, "with -r, detect the card format ("+strings.Join(magstripe.FormatNames(), ", ")+") and print its fields")
+		tracks  = flag.String("t", "123", "select tracks (1, 2, 3, 12, 23, 13, 123)")
+		bpc     = flag.String("B", "", "bit per character for each track (5 to 8)")
+		baud    = flag.Int("baud", magstripe.DefaultBaudRate, "serial line speed")
+		timeout = flag.Duration("timeout", magstripe.DefaultCommandTimeout, "time to wait for a command response or card swipe")
+		noReset = flag.Bool("no-reset", false, "do not reset the device when connecting")
+		retries = flag.Int("retry", 0, "retry failed reads, writes and timed out swipes this many times")
+		rReset  = flag.Bool("retry-reset", false, "with -retry, reset the device before each retry")
+		verbose = flag.Bool("v", false, "log connection diagnostics to stderr")
+		trace   = flag.Bool("trace", false, "log every command and response exchanged with the device to stderr")
+		record  = flag.String("record", "", "record the session with the device to a file")
+		replay  = flag.String("replay", "", "replay a recorded session file instead of using a device")
+		sim     = flag.Bool("simulate", false, "use a simulated MSR605 holding a blank card instead of a device")
+		reveal  = flag.Bool("reveal", false, "print card numbers and discretionary data in clear, in output, traces and errors")
+		jsonOut = flag.Bool("json", false, "print diff results as JSON")
+		format  = flag.String("format", "json", "card dump file format (json, binary)")
+		encrypt = flag.Bool("encrypt", false, "with dump, encrypt the file with a passphrase (from $"+passphraseEnv+" or prompted)")
+		recip   = flag.String("recipient", "", "with dump, encrypt the file for a public key printed by keygen")
+		ident   = flag.String("identity", "", "secret key file written by keygen, to decrypt dumps and sessions encrypted for its public key")
+		model   = flag.String("model", "msr605", "device model ("+strings.Join(magstripe.ProfileNames(), ", ")+")")
+		help    = flag.Bool("help", false, "show help")
 	)
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] [data...]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Driver for the magnetic strip card reader/writer MSR605\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [data...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] dump|restore FILE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] plot [FILE.svg|FILE.png|FILE]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] diff DUMP [DUMP]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] tui\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] erase [-secure [-patterns LIST]] [-verify]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s keygen KEYFILE\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Driver for the magnetic strip card reader/writer MSR605 and compatible devices\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -35,9 +68,30 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s -d COM1 -r -t 12                     # read tracks 1&2 (Windows)\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -w -t 123 \"t1\" \"t2\" \"t3\"  # write tracks\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -e -t 123             # erase all tracks\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 erase -secure -verify  # overwrite, erase and check a badge\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -r -decode            # read and decode a bank card or license\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -r -reveal            # read without masking card numbers\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -0 -auto -r           # read a non-ISO card\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -0 -charset hotel.json -t 2 -r  # read with a custom charset\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -C                    # set high coercivity\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -c                    # set low coercivity\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -b hhl                # set BPI: high, high, low\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -baud 19200 -r        # read from a device running at 19200 baud\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -retry 3 -r           # allow up to 3 more swipes for a read\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/hidraw0 -model msr605x -r     # read with an MSR605X\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 --trace -r            # read and dump the wire traffic\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -record s.jsonl -r    # read and record the session\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -replay s.jsonl -r                    # replay a recorded session\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -C -b hhh dump card.json  # save a full card image\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 restore card.json     # write the image to a blank card\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -encrypt dump card.enc  # save a card image encrypted with a passphrase\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s keygen msr.key                        # create a key pair for -recipient and -identity\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -t 2 plot             # show the bits of track 2\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 plot card.svg         # draw all tracks to an SVG file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 diff card.json        # compare the card with a dump\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -json diff a.json b.json               # compare two dumps as JSON\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 tui                   # open the full-screen interface\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -simulate tui                         # try the interface without a device\n", os.Args[0])
 	}
 
 	flag.Parse()
@@ -47,6 +101,71 @@ func main() {
 		return
 	}
 
+	data := flag.Args()
+
+	// dump and restore take a file, plot an optional one and diff one or
+	// two dumps (comparing with the card when given one); dump applies -C, -c
+	// and -b before reading
+	k := &keys{encrypt: *encrypt, recipient: *recip, identity: *ident}
+
+	if len(data) > 0 && data[0] == "keygen" {
+		if len(data) != 2 {
+			fmt.Fprintf(os.Stderr, "Error: keygen requires a key file name\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		exitOnError(keygen(data[1]))
+		return
+	}
+
+	command := ""
+	var eraseOpts magstripe.EraseOptions
+	if len(data) > 0 && (data[0] == "dump" || data[0] == "restore" || data[0] == "plot" || data[0] == "diff" || data[0] == "tui" || data[0] == "erase") {
+		command, data = data[0], data[1:]
+		if command == "erase" {
+			eraseOpts, data = parseEraseFlags(data)
+		}
+		if command == "plot" && len(data) == 0 {
+			data = []string{"-"}
+		}
+		if (command == "tui" || command == "erase") && len(data) != 0 {
+			fmt.Fprintf(os.Stderr, "Error: %s takes no arguments\n\n", command)
+			flag.Usage()
+			os.Exit(1)
+		}
+		if command == "diff" && (len(data) < 1 || len(data) > 2) {
+			fmt.Fprintf(os.Stderr, "Error: diff requires one or two dump files\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if command != "diff" && command != "tui" && command != "erase" && len(data) != 1 {
+			fmt.Fprintf(os.Stderr, "Error: %s requires a file name (- for standard I/O)\n\n", command)
+			flag.Usage()
+			os.Exit(1)
+		}
+		if *read || *write || *erase || (command != "dump" && (*hico || *loco || *bpi != "")) {
+			fmt.Fprintf(os.Stderr, "Error: %s cannot be combined with other operations\n\n", command)
+			flag.Usage()
+			os.Exit(1)
+		}
+		if *hico && *loco {
+			fmt.Fprintf(os.Stderr, "Error: -C and -c are mutually exclusive\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	dumpFormat := magstripe.DumpJSON
+	switch *format {
+	case "json":
+	case "binary":
+		dumpFormat = magstripe.DumpBinary
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown dump format '%s'\n\n", *format)
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	// Count mutually exclusive operations
 	opCount := 0
 	if *read {
@@ -68,14 +187,16 @@ func main() {
 		opCount++
 	}
 
+	if command != "" {
+		opCount = 1
+	}
+
 	if opCount != 1 {
-		fmt.Fprintf(os.Stderr, "Error: Must specify exactly one operation (-r, -w, -e, -C, -c, or -b)\n\n")
+		fmt.Fprintf(os.Stderr, "Error: Must specify exactly one operation (-r, -w, -e, -C, -c, -b, dump, restore, plot, diff or tui)\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	data := flag.Args()
-
 	// Validate arguments
 	if (*read || *erase) && len(data) != 0 {
 		fmt.Fprintf(os.Stderr, "Error: too many arguments for read/erase operation\n\n")
@@ -90,109 +211,262 @@ func main() {
 	}
 
 	// Parse tracks
-	trackFlags := [3]bool{false, false, false}
-	trackData := [3]string{"", "", ""}
-
-	for i, trackChar := range *tracks {
-		n, err := strconv.Atoi(string(trackChar))
-		if err != nil || n < 1 || n > 3 || trackFlags[n-1] {
-			fmt.Fprintf(os.Stderr, "Error: invalid tracks specification '%s'\n\n", *tracks)
-			flag.Usage()
-			os.Exit(1)
-		}
-		trackFlags[n-1] = true
-		if *write && i < len(data) {
-			trackData[n-1] = data[i]
+	sel, err := magstripe.ParseTracks(*tracks)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	var trackData [3]string
+	if *write {
+		for i, trackChar := range *tracks {
+			trackData[trackChar-'1'] = data[i]
 		}
 	}
 
-	// Parse BPC
-	bpc1, bpc2, bpc3 := 8, 8, 8
+	// Parse the settings to apply, and the widths raw data is packed in
+	var settings magstripe.Settings
+	if *hico {
+		settings.Coercivity = magstripe.CoercivityHigh
+	} else if *loco {
+		settings.Coercivity = magstripe.CoercivityLow
+	}
+
+	bpcs := [3]int{8, 8, 8}
 	if *bpc != "" {
 		if len(*bpc) != 3 {
 			fmt.Fprintf(os.Stderr, "Error: BPC must be 3 characters (e.g., '888')\n")
 			os.Exit(1)
 		}
-		var err error
-		if bpc1, err = strconv.Atoi(string((*bpc)[0])); err != nil || bpc1 < 5 || bpc1 > 8 {
-			fmt.Fprintf(os.Stderr, "Error: invalid BPC format, must be 5-8\n")
-			os.Exit(1)
-		}
-		if bpc2, err = strconv.Atoi(string((*bpc)[1])); err != nil || bpc2 < 5 || bpc2 > 8 {
-			fmt.Fprintf(os.Stderr, "Error: invalid BPC format, must be 5-8\n")
-			os.Exit(1)
+		for i := range bpcs {
+			var err error
+			if bpcs[i], err = strconv.Atoi(string((*bpc)[i])); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid BPC format, must be 5-8\n")
+				os.Exit(1)
+			}
 		}
-		if bpc3, err = strconv.Atoi(string((*bpc)[2])); err != nil || bpc3 < 5 || bpc3 > 8 {
-			fmt.Fprintf(os.Stderr, "Error: invalid BPC format, must be 5-8\n")
-			os.Exit(1)
+	}
+	if *bpc != "" || *raw || command == "dump" || command == "plot" || command == "diff" {
+		// raw modes are set up with 8 bits per character unless told otherwise
+		for i, n := range bpcs {
+			settings.Tracks[i].BPC = magstripe.BPC(n)
 		}
-	} else if *raw {
-		*bpc = "888" // force setup for raw mode
 	}
 
 	// Parse BPI
-	var bpi1, bpi2, bpi3 *bool
 	if *bpi != "" {
 		if len(*bpi) != 3 {
 			fmt.Fprintf(os.Stderr, "Error: BPI must be 3 characters (e.g., 'hhl')\n")
 			os.Exit(1)
 		}
-		for _, char := range *bpi {
-			if char != 'h' && char != 'l' {
+		for i, char := range *bpi {
+			switch char {
+			case 'h':
+				settings.Tracks[i].BPI = magstripe.BPI210
+			case 'l':
+				settings.Tracks[i].BPI = magstripe.BPI75
+			default:
 				fmt.Fprintf(os.Stderr, "Error: BPI characters must be 'h' or 'l'\n")
 				os.Exit(1)
 			}
 		}
-		val1 := (*bpi)[0] != 'l'
-		val2 := (*bpi)[1] != 'l'
-		val3 := (*bpi)[2] != 'l'
-		bpi1 = &val1
-		bpi2 = &val2
-		bpi3 = &val3
+	}
+	if err := settings.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse charset
+	var cs *magstripe.Charset
+	if *charset != "" {
+		var err error
+		if cs, err = loadCharset(*charset); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Card data is redacted unless asked for in clear
+	policy := magstripe.RedactPCI
+	if *reveal {
+		policy = magstripe.RedactNone
+	}
+
+	// Comparing two dumps needs no device
+	if command == "diff" && len(data) == 2 {
+		a, err := loadDump(data[0], k)
+		if err == nil {
+			var b *magstripe.Dump
+			if b, err = loadDump(data[1], k); err == nil {
+				err = printDiff(a, b, *jsonOut, policy)
+			}
+		}
+		exitOnError(err)
+		return
 	}
 
 	// Connect to device
-	if *device == "" {
+	if *device == "" && *replay == "" && !*sim {
 		fmt.Fprintf(os.Stderr, "Error: device path required (-d)\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	dev, err := magstripe.NewMSR(*device)
+	profile, ok := magstripe.LookupProfile(*model)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown device model '%s'\n\n", *model)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	opts := []magstripe.Option{
+		magstripe.WithProfile(profile),
+		magstripe.WithRedaction(policy),
+		magstripe.WithBaudRate(*baud),
+		magstripe.WithCommandTimeout(*timeout),
+	}
+	if *noReset {
+		opts = append(opts, magstripe.WithoutInitialReset())
+	}
+	if *retries > 0 {
+		// the interface polls for swipes, so only failures are retried there
+		retry := magstripe.RetryPolicy{
+			Attempts:   *retries + 1,
+			Backoff:    500 * time.Millisecond,
+			MaxBackoff: 5 * time.Second,
+			Reset:      *rReset,
+			Timeouts:   command != "tui",
+		}
+		if command != "tui" {
+			retry.OnRetry = func(ev magstripe.RetryEvent) {
+				reason := fmt.Sprint(ev.Err)
+				if ev.Err == nil {
+					reason = fmt.Sprintf("status %c", ev.Status)
+					if text := profile.StatusText[ev.Status]; text != "" {
+						reason += " (" + text + ")"
+					}
+				}
+				fmt.Fprintf(os.Stderr, "Retrying after %s (attempt %d of %d)\n", reason, ev.Attempt, *retries+1)
+			}
+		}
+		opts = append(opts, magstripe.WithRetry(retry))
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if *verbose {
+		opts = append(opts, magstripe.WithLogger(logger))
+	}
+	if *trace {
+		opts = append(opts, magstripe.WithTracer(magstripe.NewSlogTracer(logger)))
+	}
+
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to create session file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		opts = append(opts, magstripe.WithRecording(f))
+	}
+
+	var dev *magstripe.MSR
+	name := *device
+	if *sim {
+		name = "simulator"
+		dev, err = magstripe.NewMSRPort(magstripe.NewSimulator(), opts...)
+	} else if *replay != "" {
+		name = *replay
+		var replayer *magstripe.Replayer
+		if replayer, err = openReplay(*replay, k); err == nil {
+			dev, err = magstripe.NewMSRPort(replayer, opts...)
+		}
+	} else {
+		if command == "tui" {
+			// the interface outlives the device being unplugged and plugged back
+			opts = append(opts, magstripe.WithReconnect(magstripe.ReconnectPolicy{}))
+		}
+		dev, err = magstripe.NewMSR(*device, opts...)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: failed to connect to device: %v\n", err)
 		os.Exit(1)
 	}
 	defer dev.Close()
 
+	if command == "tui" {
+		exitOnError(startTUI(dev, name, *timeout, policy))
+		return
+	}
+	if command == "erase" {
+		exitOnError(secureErase(dev, sel, eraseOpts))
+		return
+	}
+	if command != "" {
+		exitOnError(executeCommand(dev, command, data[0], dumpFormat, *jsonOut,
+			sel, bpcs, settings, cs, policy, k))
+		return
+	}
+
 	// Execute operations
-	if err := executeOperation(dev, *read, *write, *erase, *hico, *loco, *raw, *bpi != "",
-		trackFlags, trackData, bpc1, bpc2, bpc3, bpi1, bpi2, bpi3, *bpc != ""); err != nil {
+	if err := executeOperation(dev, *read, *write, *erase, *raw, *auto, *decode,
+		sel, trackData, bpcs, settings, cs, policy); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func executeOperation(dev *magstripe.MSR, read, write, erase, hicoOp, locoOp, raw, bpiOp bool,
-	trackFlags [3]bool, trackData [3]string, bpc1, bpc2, bpc3 int,
-	bpi1, bpi2, bpi3 *bool, setBPC bool) error {
+func executeOperation(dev *magstripe.MSR, read, write, erase, raw, auto, decode bool,
+	sel magstripe.Tracks, trackData [3]string, bpcs [3]int,
+	settings magstripe.Settings, cs *magstripe.Charset, p magstripe.Redact) error {
 
-	// Set BPC if needed
-	if setBPC {
-		if err := dev.SetBPC(bpc1, bpc2, bpc3); err != nil {
-			return fmt.Errorf("failed to set BPC: %w", err)
-		}
+	// Apply the coercivity, BPI and BPC given; -C, -c and -b need nothing more
+	if err := dev.Configure(settings); err != nil {
+		return fmt.Errorf("failed to configure device: %w", err)
 	}
 
 	switch {
+	case read && raw && auto:
+		results, err := dev.ReadSelectedRawTracks(sel)
+		if err != nil {
+			return fmt.Errorf("failed to read raw tracks: %w", err)
+		}
+
+		for i, r := range results {
+			if !printStatus(i+1, r) {
+				continue
+			}
+			var opts *magstripe.DecodeOptions
+			if cs != nil {
+				opts = &magstripe.DecodeOptions{Charsets: []*magstripe.Charset{cs}}
+			}
+			d, err := magstripe.DecodeBits(magstripe.BitsFromRaw(string(r.Raw), bpcs[i]), opts)
+			if err != nil {
+				fmt.Printf("%d= (%v)\n", i+1, err)
+				continue
+			}
+			line := fmt.Sprintf("%d=%s (%d+%s bits", i+1, p.Track(d.Text()), d.Width, d.Parity)
+			if d.Reversed {
+				line += ", reversed"
+			}
+			line += fmt.Sprintf(", confidence %.2f)", d.Confidence)
+			if n := d.ParityErrors(); n > 0 {
+				line += fmt.Sprintf(" (%d parity errors)", n)
+			}
+			if d.LRCError {
+				line += " (LRC error)"
+			}
+			fmt.Println(line)
+		}
+		return results.Err()
+
 	case read && raw:
-		s1, s2, s3, err := dev.ReadRawTracks()
+		results, err := dev.ReadSelectedRawTracks(sel)
 		if err != nil {
 			return fmt.Errorf("failed to read raw tracks: %w", err)
 		}
 
 		printResult := func(num int, res magstripe.RawData) {
-			line := fmt.Sprintf("%d=%s", num, res.Data)
+			line := fmt.Sprintf("%d=%s", num, p.Track(res.Data))
 			if len(res.Data) != res.TotalLength {
 				line += fmt.Sprintf(" (+%d null)", res.TotalLength-len(res.Data))
 			}
@@ -205,60 +479,385 @@ func executeOperation(dev *magstripe.MSR, read, write, erase, hicoOp, locoOp, ra
 			}
 		}
 
-		if trackFlags[0] {
-			printResult(1, magstripe.UnpackRaw(s1, magstripe.Track1Map, 6, bpc1))
-		}
-		if trackFlags[1] {
-			printResult(2, magstripe.UnpackRaw(s2, magstripe.Track23Map, 4, bpc2))
+		unpack := func(s string, def *magstripe.Charset, bpc int) magstripe.RawData {
+			if cs != nil {
+				def = cs
+			}
+			d, err := def.Unpack(s, bpc)
+			if err != nil {
+				return magstripe.RawData{}
+			}
+			return d.RawData()
 		}
-		if trackFlags[2] {
-			printResult(3, magstripe.UnpackRaw(s3, magstripe.Track23Map, 4, bpc3))
+
+		defaults := [3]*magstripe.Charset{magstripe.CharsetIATA, magstripe.CharsetABA, magstripe.CharsetABA}
+		for i, r := range results {
+			if printStatus(i+1, r) {
+				printResult(i+1, unpack(string(r.Raw), defaults[i], bpcs[i]))
+			}
 		}
+		return results.Err()
 
 	case read: // ISO mode
-		tracks, err := dev.ReadTracks()
+		results, err := dev.ReadSelectedTracks(sel)
 		if err != nil {
 			return fmt.Errorf("failed to read tracks: %w", err)
 		}
 
-		if trackFlags[0] {
-			fmt.Printf("1=%s\n", tracks.Track1)
-		}
-		if trackFlags[1] {
-			fmt.Printf("2=%s\n", tracks.Track2)
+		for i, r := range results {
+			if printStatus(i+1, r) {
+				fmt.Printf("%d=%s\n", i+1, p.Track(r.Data))
+			}
 		}
-		if trackFlags[2] {
-			fmt.Printf("3=%s\n", tracks.Track3)
+		if decode {
+			printDetection(results.TrackData(), p)
 		}
+		return results.Err()
 
 	case write && raw:
-		d1, d2, d3 := "", "", ""
-		if trackFlags[0] {
-			d1 = magstripe.PackRaw(trackData[0], magstripe.Track1Map, 6, bpc1)
-		}
-		if trackFlags[1] {
-			d2 = magstripe.PackRaw(trackData[1], magstripe.Track23Map, 4, bpc2)
-		}
-		if trackFlags[2] {
-			d3 = magstripe.PackRaw(trackData[2], magstripe.Track23Map, 4, bpc3)
+		defaults := [3]*magstripe.Charset{magstripe.CharsetIATA, magstripe.CharsetABA, magstripe.CharsetABA}
+		var packed [3]string
+		var plan magstripe.EncodingPlan
+		for i := range packed {
+			if !sel.Has(i + 1) {
+				continue
+			}
+			c := defaults[i]
+			if cs != nil {
+				c = cs
+			}
+			var err error
+			if packed[i], err = c.Pack(trackData[i], bpcs[i], 0); err != nil {
+				return fmt.Errorf("track %d: %w", i+1, err)
+			}
+			// the packed bits keep the width of the charset on the stripe
+			plan[i], _ = magstripe.PlanTrack(i+1, trackData[i], magstripe.PlanOptions{Charset: c, BPI: settings.Tracks[i].BPI})
 		}
-		return dev.WriteRawTracks(d1, d2, d3)
+		warnOverflow(&plan)
+		return dev.WriteSelectedRawTracks(sel, packed[0], packed[1], packed[2])
 
 	case write: // ISO mode
-		return dev.WriteTracks(trackData[0], trackData[1], trackData[2])
+		// errors other than overflows are left for the write to report
+		plan, _ := dev.PlanTracks(sel, trackData[0], trackData[1], trackData[2])
+		warnOverflow(plan)
+		return dev.WriteSelectedTracks(sel, trackData[0], trackData[1], trackData[2])
 
 	case erase:
-		return dev.EraseTracks(trackFlags[0], trackFlags[1], trackFlags[2])
+		return dev.EraseTracks(sel.Has(1), sel.Has(2), sel.Has(3))
+	}
+
+	return nil
+}
+
+// warnOverflow warns of the planned tracks longer than a card, which the
+// device truncates or fails to write
+func warnOverflow(plan *magstripe.EncodingPlan) {
+	for _, p := range plan {
+		if p != nil && !p.Fits() {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", p)
+		}
+	}
+}
+
+// printStatus prints the tracks that returned no data and reports whether the
+// track holds data to print
+func printStatus(n int, r magstripe.Track) bool {
+	switch r.Status {
+	case magstripe.TrackEmpty:
+		fmt.Printf("%d= (empty)\n", n)
+	case magstripe.TrackError:
+		fmt.Printf("%d= (%v)\n", n, r.Err)
+	}
+	return r.Status == magstripe.TrackPresent
+}
+
+// printDetection prints the format of the card and its decoded fields,
+// redacted with p
+func printDetection(tracks *magstripe.TrackData, p magstripe.Redact) {
+	d, err := magstripe.Detect(tracks)
+	if err != nil {
+		fmt.Printf("format: %v\n", err)
+		return
+	}
+	fmt.Printf("format: %s (score %.2f)\n", d.Format, d.Score)
+	for _, f := range d.Card.Fields() {
+		fmt.Printf("  %s: %s\n", f.Name, p.Field(f.Name, f.Value))
+	}
+}
+
+// errDiffers reports that diff found differences
+var errDiffers = errors.New("cards differ")
+
+// exitOnError prints err and exits with status 1; differences found by diff
+// exit with status 1 silently
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, errDiffers) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	os.Exit(1)
+}
+
+// loadDump reads a dump file, or standard input for "-", decrypting it with
+// the keys of k when it is encrypted
+func loadDump(file string, k *keys) (*magstripe.Dump, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	case locoOp:
-		return dev.SetCoercivity(magstripe.LoCo)
+	var ids []magstripe.Identity
+	if magstripe.IsEncrypted(data) {
+		if ids, err = k.identities(); err != nil {
+			return nil, err
+		}
+	}
+	d, err := magstripe.LoadDump(bytes.NewReader(data), ids...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return d, nil
+}
+
+// openReplay loads a session file, decrypting it with the keys of k when it
+// is encrypted
+func openReplay(file string, k *keys) (*magstripe.Replayer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var ids []magstripe.Identity
+	if magstripe.IsEncrypted(data) {
+		if ids, err = k.identities(); err != nil {
+			return nil, err
+		}
+	}
+	r, err := magstripe.Decrypt(bytes.NewReader(data), ids...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return magstripe.NewReplayer(r)
+}
 
-	case hicoOp:
-		return dev.SetCoercivity(magstripe.HiCo)
+// saveDump writes a dump, encrypted for r unless it is nil
+func saveDump(w io.Writer, d *magstripe.Dump, format magstripe.DumpFormat, r magstripe.Recipient) error {
+	if r == nil {
+		return d.Save(w, format)
+	}
+	return d.SaveEncrypted(w, format, r)
+}
 
-	case bpiOp:
-		return dev.SetBPI(bpi1, bpi2, bpi3)
+// printDiff prints the differences between two dumps redacted with p,
+// returning errDiffers when there are any
+func printDiff(a, b *magstripe.Dump, jsonOut bool, p magstripe.Redact) error {
+	d := magstripe.DiffDumps(a, b).Redact(p)
+	var err error
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(d)
+	} else {
+		err = d.WriteText(os.Stdout)
 	}
+	if err == nil && !d.Equal {
+		err = errDiffers
+	}
+	return err
+}
 
+// executeCommand runs the dump, restore, plot or diff command on the named file
+func executeCommand(dev *magstripe.MSR, command, file string, format magstripe.DumpFormat,
+	jsonOut bool, sel magstripe.Tracks, bpcs [3]int, settings magstripe.Settings,
+	cs *magstripe.Charset, p magstripe.Redact, k *keys) error {
+
+	switch command {
+	case "dump":
+		if err := dev.Configure(settings); err != nil {
+			return fmt.Errorf("failed to configure device: %w", err)
+		}
+
+		// ask for the passphrase before the card is swiped
+		r, err := k.recipientFor()
+		if err != nil {
+			return err
+		}
+		d, err := dev.ReadDump()
+		if err != nil {
+			return fmt.Errorf("failed to read card: %w", err)
+		}
+		if file == "-" {
+			return saveDump(os.Stdout, d, format, r)
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		if err := saveDump(f, d, format, r); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+
+	case "restore":
+		d, err := loadDump(file, k)
+		if err != nil {
+			return err
+		}
+		return dev.RestoreDump(d)
+
+	case "diff":
+		a, err := loadDump(file, k)
+		if err != nil {
+			return err
+		}
+		if err := dev.Configure(settings); err != nil {
+			return fmt.Errorf("failed to configure device: %w", err)
+		}
+		b, err := dev.ReadDump()
+		if err != nil {
+			return fmt.Errorf("failed to read card: %w", err)
+		}
+		return printDiff(a, b, jsonOut, p)
+
+	case "plot":
+		if err := dev.Configure(settings); err != nil {
+			return fmt.Errorf("failed to configure device: %w", err)
+		}
+		s1, s2, s3, err := dev.ReadRawTracks()
+		if err != nil {
+			return fmt.Errorf("failed to read raw tracks: %w", err)
+		}
+
+		var plots []*magstripe.TrackPlot
+		for i, s := range []string{s1, s2, s3} {
+			if !sel.Has(i + 1) {
+				continue
+			}
+			bits := magstripe.BitsFromRaw(s, bpcs[i])
+			var d *magstripe.BitDecoding
+			if cs != nil {
+				d, _ = cs.Decode(bits)
+			} else {
+				d, _ = magstripe.DecodeBits(bits, nil)
+			}
+			plots = append(plots, magstripe.NewTrackPlot(i+1, bits, d))
+		}
+
+		if file == "-" {
+			fi, err := os.Stdout.Stat()
+			color := err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("NO_COLOR") == ""
+			return magstripe.WritePlotText(os.Stdout, plots, magstripe.PlotOptions{Color: color})
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".svg":
+			err = magstripe.WritePlotSVG(f, plots)
+		case ".png":
+			err = magstripe.WritePlotPNG(f, plots)
+		default:
+			err = magstripe.WritePlotText(f, plots, magstripe.PlotOptions{})
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
 	return nil
 }
+
+// loadCharset returns a registered charset, or loads and registers the
+// charset defined in the named JSON file
+func loadCharset(name string) (*magstripe.Charset, error) {
+	if cs, ok := magstripe.LookupCharset(name); ok {
+		return cs, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown charset '%s'", name)
+	}
+	defer f.Close()
+
+	cs, err := magstripe.LoadCharset(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := magstripe.RegisterCharset(cs); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// parseEraseFlags parses the options of the erase command, returning the
+// remaining arguments
+func parseEraseFlags(args []string) (magstripe.EraseOptions, []string) {
+	fs := flag.NewFlagSet("erase", flag.ExitOnError)
+	secure := fs.Bool("secure", false, "overwrite the tracks with patterns before erasing them")
+	patterns := fs.String("patterns", "zeros,random", "with -secure, patterns written over the tracks in turn (zeros, random)")
+	verify := fs.Bool("verify", false, "read the card back and fail if a track still decodes")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] erase [-secure [-patterns LIST]] [-verify]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Erase the tracks selected with -t, each step taking a swipe of the card\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	opts := magstripe.EraseOptions{Verify: *verify}
+	if *secure {
+		var err error
+		if opts.Patterns, err = magstripe.ParseErasePatterns(*patterns); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+			fs.Usage()
+			os.Exit(1)
+		}
+	}
+	return opts, fs.Args()
+}
+
+// secureErase erases the selected tracks and prints the outcome for each
+func secureErase(dev *magstripe.MSR, sel magstripe.Tracks, opts magstripe.EraseOptions) error {
+	swipes := len(opts.Patterns) + 1
+	if opts.Verify {
+		swipes++
+	}
+	fmt.Fprintf(os.Stderr, "Swipe the card %d time(s)\n", swipes)
+
+	results, err := dev.SecureErase(sel, opts)
+	if results != nil {
+		for _, r := range results {
+			if !sel.Has(r.Number) {
+				continue
+			}
+			var steps []string
+			if r.Passes > 0 {
+				steps = append(steps, fmt.Sprintf("overwritten %d time(s)", r.Passes))
+			}
+			switch {
+			case !r.Erased:
+				steps = append(steps, "not erased")
+			case r.Err != nil:
+				steps = append(steps, "erased", "still decodes")
+			case r.Verified:
+				steps = append(steps, "erased", "verified blank")
+			default:
+				steps = append(steps, "erased")
+			}
+			fmt.Printf("%d= %s\n", r.Number, strings.Join(steps, ", "))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return results.Err()
+}
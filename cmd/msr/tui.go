@@ -0,0 +1,601 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/abrahan/magstripe-go"
+	"golang.org/x/term"
+)
+
+// tuiPoll is how long each read waits for a swipe before pending operations
+// get a turn
+const tuiPoll = time.Second
+
+// tuiLogSize is the number of swipe log entries kept
+const tuiLogSize = 100
+
+// Terminal control sequences
+const (
+	ansiClear   = "\x1b[H\x1b[2J"
+	ansiHome    = "\x1b[H"
+	ansiEOL     = "\x1b[K"
+	ansiReverse = "\x1b[7m"
+	ansiBold    = "\x1b[1m"
+	ansiReset   = "\x1b[0m"
+	ansiHide    = "\x1b[?25l"
+	ansiShow    = "\x1b[?25h"
+	ansiAltOn   = "\x1b[?1049h"
+	ansiAltOff  = "\x1b[?1049l"
+)
+
+// tuiJob is an operation run on the device between swipe polls
+type tuiJob struct {
+	name string
+	run  func(dev *magstripe.MSR) error
+	// done updates the screen state once run succeeded
+	done func(t *tui)
+	// swipe is set for jobs that wait for a card
+	swipe bool
+}
+
+// tuiEvent is the outcome of a swipe or job reported by the device worker
+type tuiEvent struct {
+	job   *tuiJob
+	swipe *magstripe.TrackResults
+	err   error
+	time  time.Time
+}
+
+// tuiLogEntry is a line of the swipe log, counting identical events in a row
+type tuiLogEntry struct {
+	time  string
+	text  string
+	count int
+}
+
+// tuiPrompt reads a line of input for an operation
+type tuiPrompt struct {
+	label string
+	input []byte
+	done  func(t *tui, input string)
+}
+
+// tui is the state of the full-screen interface of `msr tui`. Only the device
+// worker goroutine uses the device; the screen state is owned by the UI loop.
+type tui struct {
+	out     io.Writer
+	p       magstripe.Redact
+	size    func() (int, int)
+	drawn   [2]int
+	device  string
+	timeout time.Duration
+
+	settings magstripe.Settings
+	log      []tuiLogEntry
+	last     *magstripe.TrackResults
+	message  string
+	prompt   *tuiPrompt
+	pending  []string
+	jobs     chan *tuiJob
+	quit     bool
+}
+
+// startTUI runs the interface on the terminal, which works the same over SSH
+func startTUI(dev *magstripe.MSR, name string, timeout time.Duration, p magstripe.Redact) error {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return errors.New("tui needs a terminal")
+	}
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(in, state)
+
+	size := func() (int, int) {
+		w, h, err := term.GetSize(out)
+		if err != nil {
+			return 80, 24
+		}
+		return w, h
+	}
+	return runTUI(dev, name, os.Stdin, os.Stdout, size, timeout, p)
+}
+
+// runTUI runs the interface until q is pressed or in is closed. Keys are read
+// from in, which the caller puts in raw mode, and the screen is drawn on out at
+// the dimensions returned by size. Operations wait up to timeout for a swipe.
+func runTUI(dev *magstripe.MSR, name string, in io.Reader, out io.Writer, size func() (int, int),
+	timeout time.Duration, p magstripe.Redact) error {
+	t := &tui{out: out, p: p, size: size, timeout: timeout, jobs: make(chan *tuiJob, 8)}
+	t.device = fmt.Sprintf("%s on %s", dev.Profile().Name, name)
+	if dev.Profile().Commands.Version != "" {
+		if fw, err := dev.Firmware(); err == nil {
+			t.device += " (" + fw + ")"
+		}
+	}
+
+	keys := make(chan byte)
+	go func() {
+		defer close(keys)
+		b := make([]byte, 1)
+		for {
+			if n, err := in.Read(b); n == 0 || err != nil {
+				return
+			}
+			keys <- b[0]
+		}
+	}()
+
+	stop := make(chan struct{})
+	events := make(chan tuiEvent)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		t.work(dev, stop, events)
+	}()
+	defer func() {
+		close(stop)
+		// drain events until the worker sees stop after its current read
+		for {
+			select {
+			case <-events:
+			case <-finished:
+				return
+			}
+		}
+	}()
+
+	// redraw regularly to follow changes of the terminal size
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	fmt.Fprint(out, ansiAltOn+ansiHide)
+	defer fmt.Fprint(out, ansiShow+ansiAltOff)
+	t.message = "Swipe a card to read it"
+	for !t.quit {
+		t.render()
+		select {
+		case k, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			t.key(k)
+		case ev := <-events:
+			t.event(ev)
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+// work owns the device: it runs queued jobs and otherwise polls for swipes
+func (t *tui) work(dev *magstripe.MSR, stop <-chan struct{}, events chan<- tuiEvent) {
+	for {
+		select {
+		case <-stop:
+			return
+		case job := <-t.jobs:
+			dev.SetCommandTimeout(t.timeout)
+			err := job.run(dev)
+			select {
+			case events <- tuiEvent{job: job, err: err, time: time.Now()}:
+			case <-stop:
+				return
+			}
+			continue
+		default:
+		}
+
+		dev.SetCommandTimeout(tuiPoll)
+		results, err := dev.ReadSelectedTracks(magstripe.AllTracks)
+		if errors.Is(err, magstripe.ErrTimeout) {
+			continue
+		}
+		select {
+		case events <- tuiEvent{swipe: results, err: err, time: time.Now()}:
+		case <-stop:
+			return
+		}
+		if errors.Is(err, magstripe.ErrDisconnected) {
+			// wait before trying to reopen the device
+			select {
+			case <-time.After(tuiPoll):
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// submit queues a job for the device worker
+func (t *tui) submit(job *tuiJob) {
+	select {
+	case t.jobs <- job:
+		t.pending = append(t.pending, job.name)
+		t.message = job.name + ": pending"
+		if job.swipe {
+			t.message = job.name + ": swipe a card"
+		}
+	default:
+		t.message = "Too many pending operations"
+	}
+}
+
+// event records a swipe or the end of a job
+func (t *tui) event(ev tuiEvent) {
+	stamp := ev.time.Format("15:04:05")
+	if ev.job != nil {
+		if len(t.pending) > 0 {
+			t.pending = t.pending[1:]
+		}
+		if ev.err != nil {
+			t.message = fmt.Sprintf("%s failed: %v", ev.job.name, ev.err)
+			t.addLog(stamp, fmt.Sprintf("%s: %v", ev.job.name, ev.err))
+			return
+		}
+		if ev.job.done != nil {
+			ev.job.done(t)
+		}
+		t.message = ev.job.name + ": done"
+		t.addLog(stamp, ev.job.name+": ok")
+		return
+	}
+
+	if ev.err != nil {
+		t.message = fmt.Sprintf("Read failed: %v", ev.err)
+		t.addLog(stamp, fmt.Sprintf("read: %v", ev.err))
+		return
+	}
+	t.last = ev.swipe
+	text := "read:"
+	for _, r := range ev.swipe {
+		text += fmt.Sprintf(" %d:%s", r.Number, r.Status)
+	}
+	if d, err := magstripe.Detect(ev.swipe.TrackData()); err == nil {
+		text += "  " + d.Format
+	}
+	t.message = "Card read"
+	t.addLog(stamp, text)
+}
+
+// addLog appends an entry to the swipe log, dropping the oldest. An entry
+// repeating the last one updates its time and count instead.
+func (t *tui) addLog(stamp, text string) {
+	if n := len(t.log); n > 0 && t.log[n-1].text == text {
+		t.log[n-1].time = stamp
+		t.log[n-1].count++
+		return
+	}
+	t.log = append(t.log, tuiLogEntry{time: stamp, text: text, count: 1})
+	if len(t.log) > tuiLogSize {
+		t.log = t.log[len(t.log)-tuiLogSize:]
+	}
+}
+
+// key handles a key press, feeding the prompt when one is open
+func (t *tui) key(k byte) {
+	if t.prompt != nil {
+		switch k {
+		case '\r', '\n':
+			pr := t.prompt
+			t.prompt = nil
+			pr.done(t, string(pr.input))
+		case 0x1b, 0x03: // Esc, Ctrl-C
+			t.prompt = nil
+			t.message = "Cancelled"
+		case 0x7f, 0x08: // Backspace
+			if len(t.prompt.input) > 0 {
+				_, n := utf8.DecodeLastRune(t.prompt.input)
+				t.prompt.input = t.prompt.input[:len(t.prompt.input)-n]
+			}
+		default:
+			if k >= ' ' {
+				t.prompt.input = append(t.prompt.input, k)
+			}
+		}
+		return
+	}
+
+	switch k {
+	case 'q', 0x03:
+		t.quit = true
+	case 'w':
+		t.promptWrite()
+	case 'e':
+		t.ask("Erase tracks (e.g. 123)", func(t *tui, s string) {
+			sel, err := magstripe.ParseTracks(s)
+			if err != nil {
+				t.message = err.Error()
+				return
+			}
+			t.submit(&tuiJob{name: "erase " + sel.String(), swipe: true, run: func(dev *magstripe.MSR) error {
+				return dev.EraseTracks(sel.Has(1), sel.Has(2), sel.Has(3))
+			}})
+		})
+	case 'c':
+		t.clone()
+	case 'h', 'l':
+		c, name := magstripe.CoercivityLow, "set LoCo"
+		if k == 'h' {
+			c, name = magstripe.CoercivityHigh, "set HiCo"
+		}
+		t.submit(&tuiJob{name: name,
+			run:  func(dev *magstripe.MSR) error { return dev.Configure(magstripe.Settings{Coercivity: c}) },
+			done: func(t *tui) { t.settings.Coercivity = c },
+		})
+	case 'b':
+		t.ask("BPI for tracks 1-3 (h or l, e.g. hlh)", func(t *tui, s string) {
+			if len(s) != 3 || strings.Trim(s, "hl") != "" {
+				t.message = "BPI must be 3 characters h or l"
+				return
+			}
+			var settings magstripe.Settings
+			for i := range settings.Tracks {
+				settings.Tracks[i].BPI = magstripe.BPI75
+				if s[i] == 'h' {
+					settings.Tracks[i].BPI = magstripe.BPI210
+				}
+			}
+			t.submit(&tuiJob{name: "set BPI " + s,
+				run: func(dev *magstripe.MSR) error { return dev.Configure(settings) },
+				done: func(t *tui) {
+					for i, tr := range settings.Tracks {
+						t.settings.Tracks[i].BPI = tr.BPI
+					}
+				},
+			})
+		})
+	case 'p':
+		t.ask("BPC for tracks 1-3 (5 to 8, e.g. 755)", func(t *tui, s string) {
+			var settings magstripe.Settings
+			for i := 0; i < len(s) && i < 3; i++ {
+				n, _ := strconv.Atoi(s[i : i+1])
+				settings.Tracks[i].BPC = magstripe.BPC(n)
+			}
+			if len(s) != 3 || settings.Validate() != nil {
+				t.message = "BPC must be 3 digits from 5 to 8"
+				return
+			}
+			t.submit(&tuiJob{name: "set BPC " + s,
+				run: func(dev *magstripe.MSR) error { return dev.Configure(settings) },
+				done: func(t *tui) {
+					for i, tr := range settings.Tracks {
+						t.settings.Tracks[i].BPC = tr.BPC
+					}
+				},
+			})
+		})
+	}
+}
+
+// ask opens a prompt
+func (t *tui) ask(label string, done func(t *tui, input string)) {
+	t.prompt = &tuiPrompt{label: label, done: done}
+}
+
+// promptWrite asks for each track in turn and writes those given, leaving
+// the tracks left blank untouched
+func (t *tui) promptWrite() {
+	var data [3]string
+	var next func(n int) func(t *tui, s string)
+	next = func(n int) func(t *tui, s string) {
+		return func(t *tui, s string) {
+			data[n-1] = s
+			if n < 3 {
+				t.ask(fmt.Sprintf("Track %d (empty to keep)", n+1), next(n+1))
+				return
+			}
+			var sel magstripe.Tracks
+			for i, d := range data {
+				if d != "" {
+					sel |= 1 << i
+				}
+			}
+			if sel == 0 {
+				t.message = "Nothing to write"
+				return
+			}
+			t.submit(&tuiJob{name: "write " + sel.String(), swipe: true, run: func(dev *magstripe.MSR) error {
+				return dev.WriteSelectedTracks(sel, data[0], data[1], data[2])
+			}})
+		}
+	}
+	t.ask("Track 1 (empty to keep)", next(1))
+}
+
+// clone writes the tracks of the last card read to the next card swiped
+func (t *tui) clone() {
+	if t.last == nil {
+		t.message = "Read a card to clone first"
+		return
+	}
+	var sel magstripe.Tracks
+	data := t.last.TrackData()
+	for i, r := range t.last {
+		if r.Status == magstripe.TrackPresent {
+			sel |= 1 << i
+		}
+	}
+	if sel == 0 {
+		t.message = "The last card has no data to clone"
+		return
+	}
+	t.submit(&tuiJob{name: "clone " + sel.String(), swipe: true, run: func(dev *magstripe.MSR) error {
+		return dev.WriteSelectedTracks(sel, data.Track1, data.Track2, data.Track3)
+	}})
+}
+
+// render draws the whole screen
+func (t *tui) render() {
+	width, height := t.size()
+	if [2]int{width, height} != t.drawn {
+		fmt.Fprint(t.out, ansiClear)
+		t.drawn = [2]int{width, height}
+	}
+	if width < 20 || height < 10 {
+		fmt.Fprint(t.out, ansiHome+"terminal too small"+ansiEOL)
+		return
+	}
+
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	rule := strings.Repeat("─", width)
+
+	add(ansiReverse+"%s"+ansiReset, pad(" msr tui  "+t.device, width))
+	add(" Coercivity: %s   BPI: %s   BPC: %s", t.coercivity(), t.bpi(), t.bpc())
+	add("%s", rule)
+
+	add(ansiBold+" Last card"+ansiReset+"%s", t.format())
+	if t.last == nil {
+		add("  none yet")
+	} else {
+		for _, r := range t.last {
+			line := fmt.Sprintf("  %d %-10s", r.Number, r.Status)
+			switch r.Status {
+			case magstripe.TrackPresent:
+				line += " " + t.p.Track(r.Data)
+			case magstripe.TrackError:
+				line += fmt.Sprintf(" %v", r.Err)
+			}
+			add("%s", line)
+		}
+		if d, err := magstripe.Detect(t.last.TrackData()); err == nil {
+			for _, f := range d.Card.Fields() {
+				add("    %s: %s", f.Name, t.p.Field(f.Name, f.Value))
+			}
+		}
+	}
+	add("%s", rule)
+
+	// the log fills the space left above the status lines, newest last
+	add(ansiBold + " Swipe log" + ansiReset)
+	room := height - len(lines) - 3
+	log := t.log
+	if len(log) > room {
+		log = log[len(log)-room:]
+	}
+	for _, e := range log {
+		if e.count > 1 {
+			add("  %s  %s (x%d)", e.time, e.text, e.count)
+		} else {
+			add("  %s  %s", e.time, e.text)
+		}
+	}
+	for len(lines) < height-3 {
+		add("")
+	}
+
+	add("%s", rule)
+	if t.prompt != nil {
+		add(" %s: %s_", t.prompt.label, t.prompt.input)
+	} else {
+		status := t.message
+		if len(t.pending) > 0 {
+			status += fmt.Sprintf("  [%d pending]", len(t.pending))
+		}
+		add(" %s", status)
+	}
+	add(ansiReverse+"%s"+ansiReset, pad(" w write  e erase  c clone  h HiCo  l LoCo  b BPI  p BPC  q quit", width))
+
+	// the status lines stay at the bottom when the card does not fit
+	if len(lines) > height {
+		lines = append(lines[:height-3], lines[len(lines)-3:]...)
+	}
+
+	var b strings.Builder
+	b.WriteString(ansiHome)
+	for i, l := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(clip(l, width))
+		b.WriteString(ansiEOL)
+	}
+	io.WriteString(t.out, b.String())
+}
+
+// format names the detected format of the last card
+func (t *tui) format() string {
+	if t.last == nil {
+		return ""
+	}
+	d, err := magstripe.Detect(t.last.TrackData())
+	if err != nil {
+		return " (unknown format)"
+	}
+	return fmt.Sprintf(" (%s)", d.Format)
+}
+
+func (t *tui) coercivity() string {
+	switch t.settings.Coercivity {
+	case magstripe.CoercivityHigh:
+		return "HiCo"
+	case magstripe.CoercivityLow:
+		return "LoCo"
+	}
+	return "?"
+}
+
+func (t *tui) bpi() string {
+	parts := make([]string, 3)
+	for i, tr := range t.settings.Tracks {
+		parts[i] = "?"
+		if tr.BPI != 0 {
+			parts[i] = strconv.Itoa(int(tr.BPI))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (t *tui) bpc() string {
+	parts := make([]string, 3)
+	for i, tr := range t.settings.Tracks {
+		parts[i] = "?"
+		if tr.BPC != 0 {
+			parts[i] = strconv.Itoa(int(tr.BPC))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// pad extends s with spaces to width columns
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+// clip cuts s to width visible columns, leaving escape sequences intact
+func clip(s string, width int) string {
+	var b strings.Builder
+	cols := 0
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			end := strings.IndexByte(s[i:], 'm')
+			if end == -1 {
+				break
+			}
+			b.WriteString(s[i : i+end+1])
+			i += end + 1
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if cols < width {
+			b.WriteRune(r)
+		}
+		cols++
+		i += n
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abrahan/magstripe-go"
+)
+
+// screen collects what the TUI draws
+type screen struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *screen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+// waitFor waits until the screen shows text, failing the test after a while
+func (s *screen) waitFor(t *testing.T, text string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		s.mu.Lock()
+		found := strings.Contains(s.buf.String(), text)
+		s.mu.Unlock()
+		if found {
+			return
+		}
+	}
+	t.Fatalf("screen never showed %q", text)
+}
+
+func TestTUI(t *testing.T) {
+	sim := magstripe.NewSimulator()
+	sim.SetCard(magstripe.TrackData{
+		Track1: "%B4111111111111111^DOE/JOHN^2512101000000000?",
+		Track2: ";4111111111111111=2512101000000000?",
+	})
+	dev, err := magstripe.NewMSRPort(sim, magstripe.WithoutInitialReset())
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+
+	keys, typing := io.Pipe()
+	out := &screen{}
+	done := make(chan error)
+	go func() {
+		done <- runTUI(dev, "simulator", keys, out, func() (int, int) { return 100, 30 }, time.Second, magstripe.RedactPCI)
+	}()
+	press := func(s string) {
+		if _, err := typing.Write([]byte(s)); err != nil {
+			t.Fatalf("typing: %v", err)
+		}
+	}
+
+	out.waitFor(t, "msr605 on simulator (REVS1.00)")
+	out.waitFor(t, "411111******1111")
+	out.waitFor(t, "name: DOE/JOHN")
+	out.mu.Lock()
+	if strings.Contains(out.buf.String(), "4111111111111111") {
+		t.Error("card number shown in clear")
+	}
+	out.mu.Unlock()
+
+	press("l")
+	out.waitFor(t, "Coercivity: LoCo")
+	if sim.Coercivity() {
+		t.Error("coercivity not set to LoCo")
+	}
+
+	press("p755\r")
+	out.waitFor(t, "BPC: 7 5 5")
+
+	press("w\r;123=45?\r\r")
+	out.waitFor(t, "write 2: ok")
+	if card := sim.Card(); card.Track2 != ";123=45?" || !strings.HasPrefix(card.Track1, "%B4111") {
+		t.Errorf("write changed the card to %+v", card)
+	}
+
+	press("e4\r")
+	out.waitFor(t, "invalid tracks specification")
+
+	press("q")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("runTUI: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runTUI did not quit")
+	}
+}
+
+func TestClip(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{"abcdef", "abcd"},
+		{"ab", "ab"},
+		{ansiBold + "abcdef" + ansiReset, ansiBold + "abcd" + ansiReset},
+		{"──────", "────"},
+	} {
+		if got := clip(tt.in, 4); got != tt.want {
+			t.Errorf("clip(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+module msrd
+
+go 1.21
+
+replace github.com/abrahan/magstripe-go => ../..
+
+require github.com/abrahan/magstripe-go v0.0.0-00010101000000-000000000000
+
+require (
+	github.com/creack/goselect v0.1.2 // indirect
+	go.bug.st/serial v1.6.2 // indirect
+	golang.org/x/crypto v0.21.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+)
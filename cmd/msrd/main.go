@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/abrahan/magstripe-go"
+	"github.com/abrahan/magstripe-go/remote"
+)
+
+func main() {
+	var (
+		device  = flag.String("d", "", "path to serial communication device")
+		model   = flag.String("model", "msr605", "device model ("+strings.Join(magstripe.ProfileNames(), ", ")+")")
+		baud    = flag.Int("baud", magstripe.DefaultBaudRate, "serial line speed")
+		timeout = flag.Duration("timeout", magstripe.DefaultCommandTimeout, "time to wait for a command response or card swipe")
+		listen  = flag.String("listen", "127.0.0.1:8605", "address to serve the HTTP API on")
+		queue   = flag.Int("queue", 0, "maximum number of requests waiting for the device, beyond which requests fail as busy (0 for no limit)")
+		sim     = flag.Bool("sim", false, "serve a simulated device instead of real hardware")
+		verbose = flag.Bool("v", false, "log device diagnostics")
+		trace   = flag.Bool("trace", false, "log every command and response exchanged with the device")
+		metrics = flag.Bool("metrics", false, "serve Prometheus metrics of the device on /metrics")
+		reopen  = flag.Bool("reconnect", true, "reopen the device when it is unplugged and plugged back, waiting up to -timeout for it")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Share an MSR605-compatible device over HTTP/JSON\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -listen :8605         # serve a device on all interfaces\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -sim                                  # serve a simulated device\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d /dev/ttyUSB0 -metrics              # also serve Prometheus metrics\n", os.Args[0])
+	}
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *verbose || *trace {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	profile, ok := magstripe.LookupProfile(*model)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown device model '%s'\n\n", *model)
+		flag.Usage()
+		os.Exit(1)
+	}
+	opts := []magstripe.Option{
+		magstripe.WithProfile(profile),
+		magstripe.WithBaudRate(*baud),
+		magstripe.WithCommandTimeout(*timeout),
+		magstripe.WithLogger(logger),
+	}
+	if *trace {
+		opts = append(opts, magstripe.WithTracer(magstripe.NewSlogTracer(logger)))
+	}
+	var collected *magstripe.PrometheusMetrics
+	if *metrics {
+		collected = magstripe.NewPrometheusMetrics(nil)
+		opts = append(opts, magstripe.WithMetrics(collected))
+	}
+
+	if *reopen {
+		opts = append(opts, magstripe.WithReconnect(magstripe.ReconnectPolicy{Wait: *timeout}))
+	}
+
+	var dev *magstripe.MSR
+	var err error
+	switch {
+	case *sim:
+		dev, err = magstripe.NewMSRPort(magstripe.NewSimulator(), opts...)
+	case *device != "":
+		dev, err = magstripe.NewMSR(*device, opts...)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: device path required (-d) unless -sim is given\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to connect to device: %v\n", err)
+		os.Exit(1)
+	}
+	defer dev.Close()
+
+	srv := remote.NewServer(dev, *queue, logger)
+	defer srv.Close()
+	var handler http.Handler = srv
+	if collected != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/", srv)
+		mux.Handle("/metrics", collected)
+		handler = mux
+	}
+	httpServer := &http.Server{Addr: *listen, Handler: handler}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("serving device", "listen", *listen, "model", profile.Name, "simulated", *sim)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
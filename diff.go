@@ -0,0 +1,225 @@
+package magstripe
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Span is a range of positions, End excluded
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// FieldDiff is a field whose value differs between two tracks
+type FieldDiff struct {
+	Name string `json:"name"`
+	A    string `json:"a"`
+	B    string `json:"b"`
+}
+
+// BitDiff compares the raw bit streams of a track, ignoring trailing zeros
+type BitDiff struct {
+	LenA  int    `json:"len_a"`
+	LenB  int    `json:"len_b"`
+	Spans []Span `json:"spans,omitempty"`
+}
+
+// TrackDiff compares one track of two cards
+type TrackDiff struct {
+	Track int    `json:"track"`
+	Equal bool   `json:"equal"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+	// Chars lists the character positions that differ
+	Chars []Span `json:"chars,omitempty"`
+	// Layout is set when both tracks parse with the same layout (see
+	// TrackFields), and Fields then lists the fields that differ
+	Layout string      `json:"layout,omitempty"`
+	Fields []FieldDiff `json:"fields,omitempty"`
+	// Bits compares the raw tracks when both cards have them
+	Bits *BitDiff `json:"bits,omitempty"`
+}
+
+// CardDiff compares two cards track by track
+type CardDiff struct {
+	Equal  bool         `json:"equal"`
+	Tracks [3]TrackDiff `json:"tracks"`
+}
+
+// Diff compares the tracks of two reads at the character level and, where
+// both parse as ISO 7813 or AAMVA, field by field
+func Diff(a, b *TrackData) *CardDiff {
+	return diffCards(
+		[3]string{a.Track1, a.Track2, a.Track3},
+		[3]string{b.Track1, b.Track2, b.Track3},
+		nil, nil)
+}
+
+// DiffDumps compares two card dumps like Diff, and also compares their raw
+// tracks bit by bit. Each raw track is unpacked with its dump's BPC and
+// trailing zero padding is ignored, so the same card read with different
+// lengths of padding compares equal.
+func DiffDumps(a, b *Dump) *CardDiff {
+	return diffCards(
+		[3]string{a.Tracks[0].Data, a.Tracks[1].Data, a.Tracks[2].Data},
+		[3]string{b.Tracks[0].Data, b.Tracks[1].Data, b.Tracks[2].Data},
+		dumpBits(a), dumpBits(b))
+}
+
+// dumpBits unpacks the raw tracks of a dump, nil for tracks without raw data
+func dumpBits(d *Dump) []Bits {
+	bits := make([]Bits, 3)
+	for i, t := range d.Tracks {
+		if len(t.Raw) == 0 {
+			continue
+		}
+		bits[i] = trimZeros(BitsFromRaw(string(t.Raw), trackBPC(i, BPC(t.BPC))))
+	}
+	return bits
+}
+
+// trimZeros drops the trailing zeros of a bit stream
+func trimZeros(bits Bits) Bits {
+	n := len(bits)
+	for n > 0 && bits[n-1] == 0 {
+		n--
+	}
+	return bits[:n]
+}
+
+func diffCards(a, b [3]string, bitsA, bitsB []Bits) *CardDiff {
+	d := &CardDiff{Equal: true}
+	for i := range d.Tracks {
+		t := &d.Tracks[i]
+		*t = TrackDiff{Track: i + 1, A: a[i], B: b[i]}
+		t.Chars = diffSpans(len(a[i]), len(b[i]), func(j int) bool { return a[i][j] == b[i][j] })
+
+		layoutA, fieldsA := TrackFields(i+1, a[i])
+		layoutB, fieldsB := TrackFields(i+1, b[i])
+		if layoutA != "" && layoutA == layoutB {
+			t.Layout = layoutA
+			t.Fields = diffFields(fieldsA, fieldsB)
+		}
+
+		if bitsA != nil && bitsB != nil && bitsA[i] != nil && bitsB[i] != nil {
+			ba, bb := bitsA[i], bitsB[i]
+			t.Bits = &BitDiff{
+				LenA:  len(ba),
+				LenB:  len(bb),
+				Spans: diffSpans(len(ba), len(bb), func(j int) bool { return ba[j] == bb[j] }),
+			}
+		}
+
+		t.Equal = len(t.Chars) == 0 && (t.Bits == nil || len(t.Bits.Spans) == 0)
+		d.Equal = d.Equal && t.Equal
+	}
+	return d
+}
+
+// diffSpans returns the runs of positions where two sequences of lengths la
+// and lb differ; positions past the end of the shorter one always differ
+func diffSpans(la, lb int, same func(int) bool) []Span {
+	n := la
+	if lb > n {
+		n = lb
+	}
+	var spans []Span
+	for i := 0; i < n; i++ {
+		if i < la && i < lb && same(i) {
+			continue
+		}
+		if len(spans) > 0 && spans[len(spans)-1].End == i {
+			spans[len(spans)-1].End++
+		} else {
+			spans = append(spans, Span{Start: i, End: i + 1})
+		}
+	}
+	return spans
+}
+
+// diffFields lists the fields whose values differ, in the order of a then b
+func diffFields(a, b []Field) []FieldDiff {
+	values := map[string]*FieldDiff{}
+	var order []string
+	add := func(fields []Field, side func(*FieldDiff, string)) {
+		for _, f := range fields {
+			fd, ok := values[f.Name]
+			if !ok {
+				fd = &FieldDiff{Name: f.Name}
+				values[f.Name] = fd
+				order = append(order, f.Name)
+			}
+			side(fd, f.Value)
+		}
+	}
+	add(a, func(fd *FieldDiff, v string) { fd.A = v })
+	add(b, func(fd *FieldDiff, v string) { fd.B = v })
+
+	var diffs []FieldDiff
+	for _, name := range order {
+		if fd := values[name]; fd.A != fd.B {
+			diffs = append(diffs, *fd)
+		}
+	}
+	return diffs
+}
+
+// WriteText prints the differences in a readable form, one block per track
+func (d *CardDiff) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	w = bw
+	for _, t := range d.Tracks {
+		if t.Equal {
+			fmt.Fprintf(w, "track %d: equal\n", t.Track)
+			continue
+		}
+
+		header := fmt.Sprintf("track %d: differs", t.Track)
+		if t.Layout != "" {
+			header += " (" + t.Layout + ")"
+		}
+		fmt.Fprintln(w, header)
+		fmt.Fprintf(w, "  a: %q\n  b: %q\n", t.A, t.B)
+		if len(t.Chars) > 0 {
+			fmt.Fprintf(w, "     %s\n", spanMarkers(t.Chars))
+		}
+		for _, f := range t.Fields {
+			fmt.Fprintf(w, "  %s: %q != %q\n", f.Name, f.A, f.B)
+		}
+		if t.Bits != nil && len(t.Bits.Spans) > 0 {
+			fmt.Fprintf(w, "  bits: %s (lengths %d, %d)\n", formatSpans(t.Bits.Spans), t.Bits.LenA, t.Bits.LenB)
+		}
+	}
+	return bw.Flush()
+}
+
+// spanMarkers draws ^ under the differing characters of a quoted string,
+// assuming one byte per character
+func spanMarkers(spans []Span) string {
+	line := make([]byte, spans[len(spans)-1].End+1)
+	for i := range line {
+		line[i] = ' '
+	}
+	for _, s := range spans {
+		for i := s.Start; i < s.End; i++ {
+			line[i+1] = '^' // after the opening quote
+		}
+	}
+	return string(line)
+}
+
+// formatSpans renders spans as "3, 10-12"
+func formatSpans(spans []Span) string {
+	parts := make([]string, len(spans))
+	for i, sp := range spans {
+		if sp.End-sp.Start == 1 {
+			parts[i] = fmt.Sprint(sp.Start)
+		} else {
+			parts[i] = fmt.Sprintf("%d-%d", sp.Start, sp.End-1)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,111 @@
+package magstripe
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := &TrackData{
+		Track1: "%B4111111111111111^DOE/JOHN^2512101123?",
+		Track2: ";4111111111111111=2512101123?",
+		Track3: ";011?",
+	}
+	b := &TrackData{
+		Track1: "%B4111111111111112^DOE/JANE^2512101123?",
+		Track2: ";4111111111111111=2512101123?",
+		Track3: ";0112?",
+	}
+	d := Diff(a, b)
+
+	if d.Equal || d.Tracks[0].Equal || !d.Tracks[1].Equal || d.Tracks[2].Equal {
+		t.Fatalf("unexpected equality: %+v", d)
+	}
+
+	t1 := d.Tracks[0]
+	if want := []Span{{17, 18}, {24, 27}}; !reflect.DeepEqual(t1.Chars, want) {
+		t.Errorf("track 1 chars %v, expected %v", t1.Chars, want)
+	}
+	want := []FieldDiff{
+		{Name: "pan", A: "4111111111111111", B: "4111111111111112"},
+		{Name: "name", A: "DOE/JOHN", B: "DOE/JANE"},
+	}
+	if t1.Layout != LayoutISO7813 || !reflect.DeepEqual(t1.Fields, want) {
+		t.Errorf("track 1 fields %q %v", t1.Layout, t1.Fields)
+	}
+
+	t3 := d.Tracks[2]
+	if want := []Span{{4, 6}}; !reflect.DeepEqual(t3.Chars, want) || t3.Layout != "" {
+		t.Errorf("track 3: %+v", t3)
+	}
+	if t3.Bits != nil {
+		t.Error("reads have no raw bits to compare")
+	}
+}
+
+func TestDiffDumps(t *testing.T) {
+	pack := func(text string, zeros, bpc int) []byte {
+		raw, err := CharsetABA.Pack(text, bpc, zeros)
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		return []byte(raw + "\x00\x00")
+	}
+
+	a := &Dump{Version: DumpVersion}
+	a.Tracks[1] = DumpTrack{Data: ";123?", Raw: pack(";123?", 10, 8), BPC: 8}
+	a.Tracks[2] = DumpTrack{Data: ";9?", Raw: pack(";9?", 10, 8), BPC: 8}
+
+	b := &Dump{Version: DumpVersion}
+	b.Tracks[1] = DumpTrack{Data: ";123?", Raw: append(pack(";123?", 10, 5), 0, 0, 0), BPC: 5}
+	b.Tracks[2] = DumpTrack{Data: ";8?", Raw: pack(";8?", 10, 8), BPC: 8}
+
+	d := DiffDumps(a, b)
+	if !d.Tracks[0].Equal || d.Tracks[0].Bits != nil {
+		t.Errorf("blank track 1 should be equal without bits: %+v", d.Tracks[0])
+	}
+	if t2 := d.Tracks[1]; !t2.Equal || t2.Bits == nil || t2.Bits.LenA != t2.Bits.LenB {
+		t.Errorf("track 2 should be equal ignoring padding and BPC: %+v %+v", t2, t2.Bits)
+	}
+	t3 := d.Tracks[2]
+	if t3.Equal || t3.Bits == nil || len(t3.Bits.Spans) == 0 {
+		t.Fatalf("track 3 should differ: %+v", t3)
+	}
+	// '9' (1001 0) and '8' (0001 0) differ in their first bit, after 10 zeros and ';'
+	if got := t3.Bits.Spans[0]; got != (Span{15, 16}) {
+		t.Errorf("first bit difference %v", got)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var decoded CardDiff
+	if err := json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(&decoded, d) {
+		t.Errorf("JSON round trip failed: %v\n%s", err, data)
+	}
+}
+
+func TestCardDiffWriteText(t *testing.T) {
+	d := Diff(
+		&TrackData{Track2: ";4111111111111111=2512101?"},
+		&TrackData{Track2: ";4111111111111111=2612101?"},
+	)
+	var buf bytes.Buffer
+	if err := d.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	want := `track 1: equal
+track 2: differs (iso7813)
+  a: ";4111111111111111=2512101?"
+  b: ";4111111111111111=2612101?"
+                         ^
+  expiry: "2512" != "2612"
+track 3: equal
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,409 @@
+package magstripe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DumpVersion is the version of the card dump format written by this package
+const DumpVersion = 1
+
+// dumpMagic starts a card dump in binary form
+const dumpMagic = "MSRD"
+
+// ErrDumpFormat is returned when a card dump cannot be parsed
+var ErrDumpFormat = errors.New("invalid card dump")
+
+// DumpFormat selects how a card dump is stored
+type DumpFormat int
+
+const (
+	// DumpJSON stores the dump as indented JSON
+	DumpJSON DumpFormat = iota
+	// DumpBinary stores the dump in a compact binary form
+	DumpBinary
+)
+
+// Dump is a full image of a card: the decoded and raw tracks together with the
+// settings and device they were read with
+type Dump struct {
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"`
+	Model    string    `json:"model,omitempty"`
+	Firmware string    `json:"firmware,omitempty"`
+	// HiCo is the coercivity the card was read with, nil when unknown
+	HiCo   *bool        `json:"hico,omitempty"`
+	Tracks [3]DumpTrack `json:"tracks"`
+}
+
+// DumpTrack is one track of a card dump
+type DumpTrack struct {
+	// Data is the decoded track with its sentinels, without the LRC
+	Data string `json:"data,omitempty"`
+	// Raw is the track as returned by ReadRawTracks
+	Raw []byte `json:"raw,omitempty"`
+	// BPI is the density in bits per inch (75 or 210), 0 when unknown
+	BPI int `json:"bpi,omitempty"`
+	// BPC is the number of bits per character, 0 when unknown
+	BPC int `json:"bpc,omitempty"`
+	// Charset names the charset Data was decoded with
+	Charset string `json:"charset,omitempty"`
+	// ParityErrors lists the positions of characters with a parity error
+	ParityErrors []int `json:"parity_errors,omitempty"`
+	// LRCError is set when the LRC did not match the data
+	LRCError bool `json:"lrc_error,omitempty"`
+}
+
+// TrackData returns the decoded tracks of the dump
+func (d *Dump) TrackData() *TrackData {
+	return &TrackData{Track1: d.Tracks[0].Data, Track2: d.Tracks[1].Data, Track3: d.Tracks[2].Data}
+}
+
+// hasRaw reports whether any track holds raw data
+func (d *Dump) hasRaw() bool {
+	for _, t := range d.Tracks {
+		if len(t.Raw) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// trackCharsets are the ISO charsets of tracks 1, 2 and 3
+var trackCharsets = [3]*Charset{CharsetIATA, CharsetABA, CharsetABA}
+
+// ReadDump reads the card in the device into a Dump. Devices supporting raw
+// reads are read raw and each track is decoded with its ISO charset at the
+// BPC last set with Configure (the ISO width when unknown); other devices are read in ISO
+// format. The dump records the device model and firmware and the settings
+// last applied through this MSR.
+func (m *MSR) ReadDump() (*Dump, error) {
+	d := &Dump{
+		Version: DumpVersion,
+		Time:    time.Now().UTC(),
+		Model:   m.profile.Name,
+		HiCo:    m.settings.Coercivity.hico(),
+	}
+	if m.profile.Commands.Version != "" {
+		firmware, err := m.Firmware()
+		if err != nil {
+			m.logger.Debug("firmware version unavailable", "err", err)
+		}
+		d.Firmware = firmware
+	}
+	for i := range d.Tracks {
+		d.Tracks[i].BPI = int(m.settings.Tracks[i].BPI)
+		d.Tracks[i].BPC = int(m.settings.Tracks[i].BPC)
+	}
+
+	if !m.profile.Raw {
+		tracks, err := m.ReadTracks()
+		if err != nil {
+			return nil, err
+		}
+		d.Tracks[0].Data, d.Tracks[1].Data, d.Tracks[2].Data = tracks.Track1, tracks.Track2, tracks.Track3
+		return d, nil
+	}
+
+	s1, s2, s3, err := m.ReadRawTracks()
+	if err != nil {
+		return nil, err
+	}
+	for i, raw := range []string{s1, s2, s3} {
+		if raw == "" {
+			continue
+		}
+		t := &d.Tracks[i]
+		t.Raw = []byte(raw)
+		decoded, err := trackCharsets[i].Unpack(raw, trackBPC(i, BPC(t.BPC)))
+		if err != nil {
+			continue
+		}
+		t.Charset = decoded.Charset
+		t.Data = decoded.Text()
+		t.ParityErrors = parityErrorPositions(decoded)
+		t.LRCError = decoded.LRCError
+	}
+	return d, nil
+}
+
+// RestoreDump writes a dump back to the card in the device, first applying the
+// coercivity, BPI and BPC recorded in it. Raw tracks are written when the dump
+// holds any and the device supports raw writes; otherwise the decoded tracks
+// are written in ISO format.
+func (m *MSR) RestoreDump(d *Dump) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	var s Settings
+	if d.HiCo != nil && m.profile.Coercivity {
+		s.Coercivity = CoercivityLow
+		if *d.HiCo {
+			s.Coercivity = CoercivityHigh
+		}
+	}
+	complete := d.Tracks[0].BPC != 0 && d.Tracks[1].BPC != 0 && d.Tracks[2].BPC != 0
+	for i, t := range d.Tracks {
+		s.Tracks[i].BPI = BPI(t.BPI)
+		if complete {
+			s.Tracks[i].BPC = BPC(t.BPC)
+		}
+	}
+	if err := m.Configure(s); err != nil {
+		return fmt.Errorf("failed to apply settings: %w", err)
+	}
+
+	if d.hasRaw() && m.profile.Raw {
+		return m.WriteRawTracks(string(d.Tracks[0].Raw), string(d.Tracks[1].Raw), string(d.Tracks[2].Raw))
+	}
+	return m.WriteTracks(d.Tracks[0].Data, d.Tracks[1].Data, d.Tracks[2].Data)
+}
+
+// Validate checks that the dump can be restored
+func (d *Dump) Validate() error {
+	if d.Version < 1 || d.Version > DumpVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrDumpFormat, d.Version)
+	}
+	for i, t := range d.Tracks {
+		if t.BPI != 0 && t.BPI != 75 && t.BPI != 210 {
+			return fmt.Errorf("%w: track %d BPI %d is not 75 or 210", ErrDumpFormat, i+1, t.BPI)
+		}
+		if t.BPC != 0 && (t.BPC < 5 || t.BPC > 8) {
+			return fmt.Errorf("%w: track %d BPC %d is not between 5 and 8", ErrDumpFormat, i+1, t.BPC)
+		}
+		if len(t.Raw) > 255 {
+			return fmt.Errorf("%w: track %d raw data is %d bytes, more than 255", ErrDumpFormat, i+1, len(t.Raw))
+		}
+	}
+	return nil
+}
+
+// Save writes the dump in the given format
+func (d *Dump) Save(w io.Writer, format DumpFormat) error {
+	switch format {
+	case DumpJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	case DumpBinary:
+		data, err := d.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return fmt.Errorf("unknown dump format %d", format)
+}
+
+// SaveEncrypted writes the dump in the given format, encrypted for r
+func (d *Dump) SaveEncrypted(w io.Writer, format DumpFormat, r Recipient) error {
+	ew := Encrypt(w, r)
+	if err := d.Save(ew, format); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+// LoadDump reads a dump in either format. Encrypted dumps are decrypted with
+// the first of ids that can, or fail with ErrEncrypted when none is given.
+func LoadDump(r io.Reader, ids ...Identity) (*Dump, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(dumpMagic))
+	if IsEncrypted(magic) {
+		plain, err := Decrypt(br, ids...)
+		if err != nil {
+			return nil, err
+		}
+		return LoadDump(plain)
+	}
+
+	d := &Dump{}
+	if string(magic) == dumpMagic {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+	} else if err := json.NewDecoder(br).Decode(d); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDumpFormat, err)
+	}
+
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Binary form, integers big endian:
+//
+//	"MSRD" version:u8 flags:u8 time:i64(unix ns, 0 if unset) model:str8 firmware:str8
+//	3 × (bpi:u16 bpc:u8 flags:u8 charset:str8 data:str16 raw:str16
+//	     parity errors:u16 × pos:u16)
+//
+// Dump flags: bit 0 coercivity known, bit 1 high coercivity. Track flags:
+// bit 0 LRC error.
+
+// MarshalBinary encodes the dump in its compact binary form
+func (d *Dump) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(dumpMagic)
+	b.WriteByte(byte(d.Version))
+	var flags byte
+	if d.HiCo != nil {
+		flags |= 1
+		if *d.HiCo {
+			flags |= 2
+		}
+	}
+	b.WriteByte(flags)
+	var ns int64
+	if !d.Time.IsZero() {
+		ns = d.Time.UnixNano()
+	}
+	binary.Write(&b, binary.BigEndian, ns)
+	if err := writeString8(&b, d.Model); err != nil {
+		return nil, err
+	}
+	if err := writeString8(&b, d.Firmware); err != nil {
+		return nil, err
+	}
+
+	for _, t := range d.Tracks {
+		binary.Write(&b, binary.BigEndian, uint16(t.BPI))
+		b.WriteByte(byte(t.BPC))
+		var flags byte
+		if t.LRCError {
+			flags |= 1
+		}
+		b.WriteByte(flags)
+		if err := writeString8(&b, t.Charset); err != nil {
+			return nil, err
+		}
+		if err := writeString16(&b, t.Data); err != nil {
+			return nil, err
+		}
+		if err := writeString16(&b, string(t.Raw)); err != nil {
+			return nil, err
+		}
+		binary.Write(&b, binary.BigEndian, uint16(len(t.ParityErrors)))
+		for _, pos := range t.ParityErrors {
+			binary.Write(&b, binary.BigEndian, uint16(pos))
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a dump in its compact binary form
+func (d *Dump) UnmarshalBinary(data []byte) error {
+	r := &dumpReader{data: data}
+	if string(r.bytes(len(dumpMagic))) != dumpMagic {
+		return fmt.Errorf("%w: missing %s header", ErrDumpFormat, dumpMagic)
+	}
+
+	var out Dump
+	out.Version = int(r.u8())
+	flags := r.u8()
+	if flags&1 != 0 {
+		hico := flags&2 != 0
+		out.HiCo = &hico
+	}
+	if ns := int64(r.u64()); ns != 0 {
+		out.Time = time.Unix(0, ns).UTC()
+	}
+	out.Model = string(r.bytes(int(r.u8())))
+	out.Firmware = string(r.bytes(int(r.u8())))
+
+	for i := range out.Tracks {
+		t := &out.Tracks[i]
+		t.BPI = int(r.u16())
+		t.BPC = int(r.u8())
+		t.LRCError = r.u8()&1 != 0
+		t.Charset = string(r.bytes(int(r.u8())))
+		t.Data = string(r.bytes(int(r.u16())))
+		if raw := r.bytes(int(r.u16())); len(raw) > 0 {
+			t.Raw = append([]byte(nil), raw...)
+		}
+		for n := int(r.u16()); n > 0 && r.err == nil; n-- {
+			t.ParityErrors = append(t.ParityErrors, int(r.u16()))
+		}
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.data) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrDumpFormat, len(r.data))
+	}
+	*d = out
+	return nil
+}
+
+// writeString8 writes s prefixed with its length as one byte
+func writeString8(b *bytes.Buffer, s string) error {
+	if len(s) > 0xff {
+		return fmt.Errorf("%w: %q longer than 255 bytes", ErrDumpFormat, s)
+	}
+	b.WriteByte(byte(len(s)))
+	b.WriteString(s)
+	return nil
+}
+
+// writeString16 writes s prefixed with its length as two bytes
+func writeString16(b *bytes.Buffer, s string) error {
+	if len(s) > 0xffff {
+		return fmt.Errorf("%w: field longer than 65535 bytes", ErrDumpFormat)
+	}
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+	return nil
+}
+
+// dumpReader consumes a binary dump, remembering the first truncation
+type dumpReader struct {
+	data []byte
+	err  error
+}
+
+func (r *dumpReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data) {
+		r.err = fmt.Errorf("%w: truncated", ErrDumpFormat)
+		return nil
+	}
+	out := r.data[:n]
+	r.data = r.data[n:]
+	return out
+}
+
+func (r *dumpReader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *dumpReader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *dumpReader) u64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
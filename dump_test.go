@@ -0,0 +1,267 @@
+package magstripe
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDumpedCard writes an ISO card in raw form to a simulator configured with
+// known settings and returns a dump of it
+func newDumpedCard(t *testing.T) (*Dump, *MSR, *Simulator) {
+	t.Helper()
+	m, sim := newSimulatedMSR(t)
+
+	high, low := HiBPI, LoBPI
+	if err := m.SetCoercivity(LoCo); err != nil {
+		t.Fatalf("SetCoercivity: %v", err)
+	}
+	if err := m.SetBPI(&high, &low, &high); err != nil {
+		t.Fatalf("SetBPI: %v", err)
+	}
+	if err := m.SetBPC(8, 8, 8); err != nil {
+		t.Fatalf("SetBPC: %v", err)
+	}
+
+	var raw [3]string
+	for i, text := range []string{"%B123^DOE/JOHN^99?", ";123=99?", ""} {
+		if text == "" {
+			continue
+		}
+		var err error
+		if raw[i], err = trackCharsets[i].Pack(text, 8, 16); err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+	}
+	if err := m.WriteRawTracks(raw[0], raw[1], raw[2]); err != nil {
+		t.Fatalf("WriteRawTracks: %v", err)
+	}
+
+	d, err := m.ReadDump()
+	if err != nil {
+		t.Fatalf("ReadDump: %v", err)
+	}
+	return d, m, sim
+}
+
+func TestReadDump(t *testing.T) {
+	d, _, _ := newDumpedCard(t)
+
+	if d.Version != DumpVersion || d.Model != "msr605" || d.Firmware != SimulatorFirmware {
+		t.Errorf("unexpected header: version %d, model %q, firmware %q", d.Version, d.Model, d.Firmware)
+	}
+	if d.HiCo == nil || *d.HiCo != LoCo {
+		t.Errorf("coercivity not recorded: %v", d.HiCo)
+	}
+	if time.Since(d.Time) > time.Minute {
+		t.Errorf("unexpected time %v", d.Time)
+	}
+
+	want := []DumpTrack{
+		{Data: "%B123^DOE/JOHN^99?", BPI: 210, BPC: 8, Charset: "iata"},
+		{Data: ";123=99?", BPI: 75, BPC: 8, Charset: "aba"},
+		{BPI: 210, BPC: 8},
+	}
+	for i, w := range want {
+		got := d.Tracks[i]
+		got.Raw = nil
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("track %d: got %+v, expected %+v", i+1, got, w)
+		}
+	}
+	if len(d.Tracks[0].Raw) == 0 || len(d.Tracks[2].Raw) != 0 {
+		t.Errorf("unexpected raw lengths %d, %d", len(d.Tracks[0].Raw), len(d.Tracks[2].Raw))
+	}
+	if got := d.TrackData(); got.Track2 != ";123=99?" {
+		t.Errorf("unexpected TrackData %+v", got)
+	}
+}
+
+func TestReadDumpParityErrors(t *testing.T) {
+	m, _ := newSimulatedMSR(t)
+
+	bits, err := CharsetABA.Encode(";1234?")
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	bits[2*5] ^= 1 // '2' becomes '3' with bad parity
+	// packed at the 5 bits per character the device starts with on track 2
+	if err := m.WriteRawTracks("", PackBits(bits, 5), ""); err != nil {
+		t.Fatalf("WriteRawTracks: %v", err)
+	}
+
+	d, err := m.ReadDump()
+	if err != nil {
+		t.Fatalf("ReadDump: %v", err)
+	}
+	if got := d.Tracks[1]; !reflect.DeepEqual(got.ParityErrors, []int{2}) || !got.LRCError {
+		t.Errorf("unexpected track 2 results: parity errors %v, LRC error %v", got.ParityErrors, got.LRCError)
+	}
+}
+
+func TestReadDumpISOOnly(t *testing.T) {
+	sim := NewSimulator()
+	sim.SetCard(TrackData{Track1: "%A?", Track2: ";1?"})
+	profile := ProfileReadOnly
+	profile.Raw = false
+	m, err := NewMSRPort(sim, WithProfile(profile), WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+
+	d, err := m.ReadDump()
+	if err != nil {
+		t.Fatalf("ReadDump: %v", err)
+	}
+	if got := *d.TrackData(); got != (TrackData{Track1: "%A?", Track2: ";1?"}) {
+		t.Errorf("unexpected tracks %+v", got)
+	}
+	if d.Tracks[0].Raw != nil {
+		t.Error("ISO dump should not hold raw data")
+	}
+}
+
+func TestDiffRawAndISODumps(t *testing.T) {
+	raw, _, _ := newDumpedCard(t)
+
+	sim := NewSimulator()
+	sim.SetCard(TrackData{Track1: "%B123^DOE/JOHN^99?", Track2: ";123=99?"})
+	profile := ProfileMSR605
+	profile.Raw = false
+	m, err := NewMSRPort(sim, WithProfile(profile), WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+	iso, err := m.ReadDump()
+	if err != nil {
+		t.Fatalf("ReadDump: %v", err)
+	}
+
+	if d := DiffDumps(raw, iso); !d.Equal {
+		var buf bytes.Buffer
+		d.WriteText(&buf)
+		t.Errorf("raw and ISO dumps of the same card differ:\n%s", buf.String())
+	}
+
+	// a device without raw writes restores the raw dump from its decoded tracks
+	sim.SetCard(TrackData{})
+	if err := m.RestoreDump(raw); err != nil {
+		t.Fatalf("RestoreDump: %v", err)
+	}
+	if got := sim.Card(); got != (TrackData{Track1: "%B123^DOE/JOHN^99?", Track2: ";123=99?"}) {
+		t.Errorf("unexpected card %+v", got)
+	}
+}
+
+func TestDumpSaveLoad(t *testing.T) {
+	d, _, _ := newDumpedCard(t)
+
+	for _, format := range []DumpFormat{DumpJSON, DumpBinary} {
+		var buf bytes.Buffer
+		if err := d.Save(&buf, format); err != nil {
+			t.Fatalf("Save(%d): %v", format, err)
+		}
+		if format == DumpBinary && !bytes.HasPrefix(buf.Bytes(), []byte(dumpMagic)) {
+			t.Errorf("binary dump does not start with %s", dumpMagic)
+		}
+		got, err := LoadDump(&buf)
+		if err != nil {
+			t.Fatalf("LoadDump(%d): %v", format, err)
+		}
+		if !got.Time.Equal(d.Time) {
+			t.Errorf("format %d: time %v, expected %v", format, got.Time, d.Time)
+		}
+		got.Time = d.Time
+		if !reflect.DeepEqual(got, d) {
+			t.Errorf("format %d: round trip mismatch:\n got %+v\nwant %+v", format, got, d)
+		}
+	}
+}
+
+func TestDumpBinaryZeroTime(t *testing.T) {
+	d := &Dump{Version: DumpVersion}
+	data, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	var got Dump
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if !reflect.DeepEqual(&got, d) {
+		t.Errorf("got %+v, expected %+v", got, d)
+	}
+}
+
+func TestLoadDumpErrors(t *testing.T) {
+	d, _, _ := newDumpedCard(t)
+	binary, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	tests := map[string]string{
+		"garbage":        "not a dump",
+		"future version": `{"version": 99}`,
+		"no version":     `{"tracks": []}`,
+		"bad bpc":        `{"version": 1, "tracks": [{"bpc": 12}, {}, {}]}`,
+		"bad bpi":        `{"version": 1, "tracks": [{}, {"bpi": 100}, {}]}`,
+		"truncated":      string(binary[:len(binary)-3]),
+		"trailing":       string(binary) + "x",
+	}
+	for name, data := range tests {
+		if _, err := LoadDump(strings.NewReader(data)); !errors.Is(err, ErrDumpFormat) {
+			t.Errorf("%s: expected ErrDumpFormat, got %v", name, err)
+		}
+	}
+}
+
+func TestRestoreDump(t *testing.T) {
+	d, _, src := newDumpedCard(t)
+	m, sim := newSimulatedMSR(t)
+
+	if err := m.RestoreDump(d); err != nil {
+		t.Fatalf("RestoreDump: %v", err)
+	}
+	if sim.Coercivity() != LoCo {
+		t.Error("coercivity not restored")
+	}
+	if !reflect.DeepEqual(sim.raw, src.raw) {
+		t.Errorf("raw tracks not restored: %q, expected %q", sim.raw, src.raw)
+	}
+	want := []string{"a", "y", "b", "b", "b", "o", "n"}
+	if got := sim.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands %v, expected %v", got, want)
+	}
+}
+
+func TestRestoreDumpISO(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+	d := &Dump{Version: DumpVersion, Tracks: [3]DumpTrack{{Data: "%A?"}, {Data: ";1?"}, {}}}
+
+	if err := m.RestoreDump(d); err != nil {
+		t.Fatalf("RestoreDump: %v", err)
+	}
+	if got := sim.Card(); got != (TrackData{Track1: "%A?", Track2: ";1?"}) {
+		t.Errorf("unexpected card %+v", got)
+	}
+
+	d.Version = 0
+	if err := m.RestoreDump(d); !errors.Is(err, ErrDumpFormat) {
+		t.Errorf("expected ErrDumpFormat, got %v", err)
+	}
+}
+
+func TestFirmware(t *testing.T) {
+	m, _ := newSimulatedMSR(t)
+	version, err := m.Firmware()
+	if err != nil {
+		t.Fatalf("Firmware: %v", err)
+	}
+	if version != SimulatorFirmware {
+		t.Errorf("Firmware() = %q, expected %q", version, SimulatorFirmware)
+	}
+}
@@ -0,0 +1,354 @@
+package magstripe
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted files start with encryptMagic and a version byte, followed by the
+// key method, its parameters and the AES-GCM nonce. The whole header is
+// authenticated with the content.
+const (
+	encryptMagic   = "MSRE"
+	encryptVersion = 1
+
+	methodScrypt = 1
+	methodX25519 = 2
+
+	// scrypt cost used for new files, and the highest accepted when decrypting
+	scryptLogN    = 15
+	scryptMaxLogN = 22
+	scryptR       = 8
+	scryptMaxR    = 32
+	scryptP       = 1
+	scryptMaxP    = 16
+	// scryptMaxRP bounds r*p, the parallel work of a file header
+	scryptMaxRP = 64
+	// scryptMaxMemory bounds the 128*r*N bytes scrypt allocates, 8 times
+	// that of new files
+	scryptMaxMemory = 256 << 20
+
+	saltSize  = 16
+	keySize   = 32
+	nonceSize = 12
+
+	x25519Info = "magstripe-go x25519"
+
+	identityPrefix  = "MSR-SECRET-KEY-"
+	recipientPrefix = "msr-pub-"
+)
+
+var (
+	// ErrEncrypted is returned when loading an encrypted file without an identity
+	ErrEncrypted = errors.New("file is encrypted")
+	// ErrDecrypt is returned when no identity can decrypt a file, or it has been
+	// tampered with
+	ErrDecrypt = errors.New("cannot decrypt: wrong key or corrupted data")
+)
+
+// Recipient encrypts files for the holder of a passphrase or key
+type Recipient interface {
+	// wrap returns the header fields of the key method and the key to
+	// encrypt the content with
+	wrap() (method byte, params, key []byte, err error)
+}
+
+// Identity decrypts files encrypted for it
+type Identity interface {
+	// unwrap derives the content key from the header fields, or returns
+	// ok false when the method is not its own
+	unwrap(method byte, params []byte) (key []byte, ok bool, err error)
+}
+
+// Passphrase is a Recipient and Identity deriving keys from a passphrase with
+// scrypt
+type Passphrase string
+
+func (p Passphrase) wrap() (byte, []byte, []byte, error) {
+	params := make([]byte, saltSize+3)
+	if _, err := rand.Read(params[:saltSize]); err != nil {
+		return 0, nil, nil, err
+	}
+	params[saltSize], params[saltSize+1], params[saltSize+2] = scryptLogN, scryptR, scryptP
+	key, err := p.derive(params)
+	return methodScrypt, params, key, err
+}
+
+func (p Passphrase) unwrap(method byte, params []byte) ([]byte, bool, error) {
+	if method != methodScrypt {
+		return nil, false, nil
+	}
+	if len(params) != saltSize+3 {
+		return nil, true, fmt.Errorf("%w: bad scrypt parameters", ErrDecrypt)
+	}
+	key, err := p.derive(params)
+	return key, true, err
+}
+
+// derive runs scrypt with the salt and costs of params
+func (p Passphrase) derive(params []byte) ([]byte, error) {
+	logN, r, par := params[saltSize], int(params[saltSize+1]), int(params[saltSize+2])
+	// the costs come from the file, so bound the memory and time they take
+	if logN < 1 || logN > scryptMaxLogN || r < 1 || r > scryptMaxR || par < 1 || par > scryptMaxP ||
+		r*par > scryptMaxRP || 128*r<<logN > scryptMaxMemory {
+		return nil, fmt.Errorf("%w: scrypt cost out of range", ErrDecrypt)
+	}
+	return scrypt.Key([]byte(p), params[:saltSize], 1<<logN, r, par, keySize)
+}
+
+// X25519Identity is a secret key decrypting files encrypted for its Recipient
+type X25519Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// X25519Recipient is a public key files are encrypted for, without needing the
+// secret key
+type X25519Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// GenerateX25519Identity creates a new random secret key
+func GenerateX25519Identity() (*X25519Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{key: key}, nil
+}
+
+// ParseX25519Identity parses a secret key formatted by String
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	b, err := parseKey(s, identityPrefix)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %w", err)
+	}
+	return &X25519Identity{key: key}, nil
+}
+
+// ParseX25519Recipient parses a public key formatted by String
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	b, err := parseKey(s, recipientPrefix)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return &X25519Recipient{key: key}, nil
+}
+
+// parseKey decodes a prefixed base64 key
+func parseKey(s, prefix string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("key does not start with %s", prefix)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s[len(prefix):])
+	if err != nil || len(b) != keySize {
+		return nil, fmt.Errorf("invalid %s key", strings.TrimSuffix(prefix, "-"))
+	}
+	return b, nil
+}
+
+// String formats the secret key as MSR-SECRET-KEY- and its base64 encoding
+func (id *X25519Identity) String() string {
+	return identityPrefix + base64.RawURLEncoding.EncodeToString(id.key.Bytes())
+}
+
+// Recipient returns the public key matching the secret key
+func (id *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{key: id.key.PublicKey()}
+}
+
+// String formats the public key as msr-pub- and its base64 encoding
+func (r *X25519Recipient) String() string {
+	return recipientPrefix + base64.RawURLEncoding.EncodeToString(r.key.Bytes())
+}
+
+// wrap agrees on a key with an ephemeral key pair, whose public half is
+// stored in the header
+func (r *X25519Recipient) wrap() (byte, []byte, []byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	shared, err := ephemeral.ECDH(r.key)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	params := ephemeral.PublicKey().Bytes()
+	key, err := x25519Key(shared, params, r.key.Bytes())
+	return methodX25519, params, key, err
+}
+
+func (id *X25519Identity) unwrap(method byte, params []byte) ([]byte, bool, error) {
+	if method != methodX25519 {
+		return nil, false, nil
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(params)
+	if err != nil {
+		return nil, true, fmt.Errorf("%w: bad ephemeral key", ErrDecrypt)
+	}
+	shared, err := id.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, true, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	key, err := x25519Key(shared, params, id.key.PublicKey().Bytes())
+	return key, true, err
+}
+
+// x25519Key derives the content key from a shared secret with HKDF, bound to
+// both public keys
+func x25519Key(shared, ephemeral, recipient []byte) ([]byte, error) {
+	defer clear(shared)
+	key := make([]byte, keySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, append(append([]byte(nil), ephemeral...), recipient...), []byte(x25519Info)), key)
+	return key, err
+}
+
+// methodParamsSize is the length of the header fields of each method
+var methodParamsSize = map[byte]int{
+	methodScrypt: saltSize + 3,
+	methodX25519: keySize,
+}
+
+// IsEncrypted reports whether data starts like a file written by Encrypt
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptMagic))
+}
+
+// encryptWriter buffers the content until Close seals it
+type encryptWriter struct {
+	w   io.Writer
+	r   Recipient
+	buf []byte
+}
+
+// Encrypt returns a writer encrypting everything written to it for r with
+// AES-256-GCM. The content is buffered in memory, so Encrypt suits card dumps
+// and session recordings, and written to w when the writer is closed.
+func Encrypt(w io.Writer, r Recipient) io.WriteCloser {
+	return &encryptWriter{w: w, r: r}
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	e.buf = appendWiped(e.buf, p)
+	return len(p), nil
+}
+
+// Close encrypts the buffered content and writes the file, zeroing the buffer
+func (e *encryptWriter) Close() error {
+	plain := e.buf
+	defer clear(plain[:cap(plain)])
+
+	method, params, key, err := e.r.wrap()
+	if err != nil {
+		return err
+	}
+	defer clear(key)
+
+	header := append([]byte(encryptMagic), encryptVersion, method)
+	header = append(header, params...)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	header = append(header, nonce...)
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.Write(header); err != nil {
+		return err
+	}
+	_, err = e.w.Write(aead.Seal(nil, nonce, plain, header))
+	return err
+}
+
+// Decrypt reads a file written by Encrypt and returns its content, trying each
+// identity in turn. Unencrypted input is returned unchanged, so callers can
+// accept either.
+func Decrypt(r io.Reader, ids ...Identity) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(data) {
+		return bytes.NewReader(data), nil
+	}
+	plain, err := decrypt(data, ids)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plain), nil
+}
+
+// decrypt opens an encrypted file with the first identity matching its method
+func decrypt(data []byte, ids []Identity) ([]byte, error) {
+	if len(ids) == 0 {
+		return nil, ErrEncrypted
+	}
+	pos := len(encryptMagic)
+	if len(data) < pos+2 || data[pos] != encryptVersion {
+		return nil, fmt.Errorf("%w: unsupported version", ErrDecrypt)
+	}
+	method := data[pos+1]
+	size, ok := methodParamsSize[method]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key method %d", ErrDecrypt, method)
+	}
+	pos += 2
+	if len(data) < pos+size+nonceSize {
+		return nil, fmt.Errorf("%w: truncated header", ErrDecrypt)
+	}
+	params := data[pos : pos+size]
+	nonce := data[pos+size : pos+size+nonceSize]
+	header := data[:pos+size+nonceSize]
+
+	for _, id := range ids {
+		key, ok, err := id.unwrap(method, params)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		aead, err := newGCM(key)
+		clear(key)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := aead.Open(nil, nonce, data[len(header):], header)
+		if err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrDecrypt
+}
+
+// newGCM returns AES-256-GCM with key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,175 @@
+package magstripe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func encryptBytes(t *testing.T, plain []byte, r Recipient) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := Encrypt(&buf, r)
+	if _, err := w.Write(plain); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decryptBytes(data []byte, ids ...Identity) ([]byte, error) {
+	r, err := Decrypt(bytes.NewReader(data), ids...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptPassphrase(t *testing.T) {
+	plain := []byte(testBankTrack2)
+	data := encryptBytes(t, plain, Passphrase("correct horse"))
+	if !IsEncrypted(data) || bytes.Contains(data, []byte("4111")) {
+		t.Fatalf("data not encrypted: %q", data)
+	}
+
+	got, err := decryptBytes(data, Passphrase("correct horse"))
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+	if _, err := decryptBytes(data, Passphrase("wrong")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong passphrase: expected ErrDecrypt, got %v", err)
+	}
+	if _, err := decryptBytes(data); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("no identity: expected ErrEncrypted, got %v", err)
+	}
+
+	for _, pos := range []int{5, 10, len(data) - 1} {
+		tampered := append([]byte(nil), data...)
+		tampered[pos] ^= 1
+		if _, err := decryptBytes(tampered, Passphrase("correct horse")); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("tampered byte %d: expected ErrDecrypt, got %v", pos, err)
+		}
+	}
+	if _, err := decryptBytes(data[:20], Passphrase("correct horse")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("truncated: expected ErrDecrypt, got %v", err)
+	}
+}
+
+func TestDecryptScryptCost(t *testing.T) {
+	data := encryptBytes(t, []byte(testBankTrack2), Passphrase("pw"))
+	costs := len(encryptMagic) + 2 + saltSize
+	for _, c := range []struct{ logN, r, p byte }{
+		{22, 255, 255}, // tens of GiB and minutes of work
+		{22, 8, 1},     // 4 GiB
+		{18, 32, 1},    // 1 GiB
+		{15, 33, 1},
+		{15, 8, 17},
+		{15, 16, 8}, // r*p of 128
+		{0, 8, 1},
+	} {
+		hostile := append([]byte(nil), data...)
+		hostile[costs], hostile[costs+1], hostile[costs+2] = c.logN, c.r, c.p
+		_, err := decryptBytes(hostile, Passphrase("pw"))
+		if !errors.Is(err, ErrDecrypt) || !strings.Contains(err.Error(), "scrypt cost out of range") {
+			t.Errorf("logN %d, r %d, p %d: expected the costs rejected, got %v", c.logN, c.r, c.p, err)
+		}
+	}
+}
+
+func TestEncryptX25519(t *testing.T) {
+	id, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity: %v", err)
+	}
+	recipient, err := ParseX25519Recipient(id.Recipient().String())
+	if err != nil {
+		t.Fatalf("ParseX25519Recipient: %v", err)
+	}
+	parsed, err := ParseX25519Identity(id.String() + "\n")
+	if err != nil {
+		t.Fatalf("ParseX25519Identity: %v", err)
+	}
+
+	plain := []byte(testBankTrack1)
+	data := encryptBytes(t, plain, recipient)
+
+	// identities of the wrong kind are skipped
+	got, err := decryptBytes(data, Passphrase("x"), parsed)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+	other, _ := GenerateX25519Identity()
+	if _, err := decryptBytes(data, other); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("other key: expected ErrDecrypt, got %v", err)
+	}
+	if _, err := decryptBytes(data, Passphrase("x")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("passphrase: expected ErrDecrypt, got %v", err)
+	}
+
+	for _, bad := range []string{"", "msr-pub-", "msr-pub-AAAA", id.String()} {
+		if _, err := ParseX25519Recipient(bad); err == nil {
+			t.Errorf("ParseX25519Recipient(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestDecryptPlain(t *testing.T) {
+	got, err := decryptBytes([]byte("plain"), Passphrase("x"))
+	if err != nil || string(got) != "plain" {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+}
+
+func TestDumpEncrypted(t *testing.T) {
+	d, _, _ := newDumpedCard(t)
+	for _, format := range []DumpFormat{DumpJSON, DumpBinary} {
+		var buf bytes.Buffer
+		if err := d.SaveEncrypted(&buf, format, Passphrase("secret")); err != nil {
+			t.Fatalf("SaveEncrypted: %v", err)
+		}
+		data := buf.Bytes()
+
+		if _, err := LoadDump(bytes.NewReader(data)); !errors.Is(err, ErrEncrypted) {
+			t.Errorf("format %d: expected ErrEncrypted, got %v", format, err)
+		}
+		loaded, err := LoadDump(bytes.NewReader(data), Passphrase("secret"))
+		if err != nil {
+			t.Fatalf("format %d: LoadDump: %v", format, err)
+		}
+		if loaded.Tracks[1].Data != d.Tracks[1].Data || !bytes.Equal(loaded.Tracks[1].Raw, d.Tracks[1].Raw) {
+			t.Errorf("format %d: round trip changed the dump", format)
+		}
+	}
+}
+
+func TestOpenReplayEncrypted(t *testing.T) {
+	plain, err := os.ReadFile("testdata/sessions/msr605_read_iso.jsonl")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(path, encryptBytes(t, plain, Passphrase("secret")), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, err := OpenReplay(path); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("expected ErrEncrypted, got %v", err)
+	}
+	replayer, err := OpenReplay(path, Passphrase("secret"))
+	if err != nil {
+		t.Fatalf("OpenReplay: %v", err)
+	}
+	m, err := NewMSRPort(replayer)
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+	if _, err := m.ReadTracks(); err != nil {
+		t.Errorf("ReadTracks: %v", err)
+	}
+}
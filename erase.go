@@ -0,0 +1,177 @@
+package magstripe
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotErased is reported for a track that still decodes after an erase
+var ErrNotErased = errors.New("track still decodes after erase")
+
+// ErasePattern is written over tracks before they are erased
+type ErasePattern int
+
+const (
+	// PatternZeros writes zero bits over the whole track
+	PatternZeros ErasePattern = iota
+	// PatternRandom writes random bits over the whole track
+	PatternRandom
+)
+
+func (p ErasePattern) String() string {
+	if p == PatternZeros {
+		return "zeros"
+	}
+	return "random"
+}
+
+// ParseErasePatterns parses a comma separated list of patterns, e.g.
+// "zeros,random"
+func ParseErasePatterns(s string) ([]ErasePattern, error) {
+	var patterns []ErasePattern
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "zeros":
+			patterns = append(patterns, PatternZeros)
+		case "random":
+			patterns = append(patterns, PatternRandom)
+		default:
+			return nil, fmt.Errorf("unknown erase pattern %q (zeros, random)", name)
+		}
+	}
+	return patterns, nil
+}
+
+// EraseOptions sets how SecureErase erases a card
+type EraseOptions struct {
+	// Patterns are written over the selected tracks in turn, in raw mode,
+	// before the final erase. Each pass takes a swipe.
+	Patterns []ErasePattern
+	// Verify reads the card back after the erase, taking another swipe, and
+	// fails the tracks that still decode
+	Verify bool
+}
+
+// EraseResult is the outcome of a secure erase on one track
+type EraseResult struct {
+	Number int
+	// Passes is the number of patterns written over the track
+	Passes int
+	// Erased is set once the final erase succeeded
+	Erased bool
+	// Verified is set when the read back found nothing on the track
+	Verified bool
+	// Err is ErrNotErased when the track still decoded
+	Err error
+}
+
+// EraseResults holds the outcome of a secure erase on tracks 1 to 3
+type EraseResults [3]EraseResult
+
+// Err returns the first track error, naming the track
+func (r *EraseResults) Err() error {
+	for _, t := range r {
+		if t.Err != nil {
+			return fmt.Errorf("track %d: %w", t.Number, t.Err)
+		}
+	}
+	return nil
+}
+
+// SecureErase erases the selected tracks for decommissioning a card: it
+// overwrites them with each of the patterns of opts in raw mode, erases them
+// and, with opts.Verify, reads the card back to check that nothing decodes.
+// Every step takes a swipe of the card. A failed step stops the erase and is
+// returned along with the results so far; a track that still decodes is
+// reported in its result and by EraseResults.Err.
+func (m *MSR) SecureErase(sel Tracks, opts EraseOptions) (*EraseResults, error) {
+	if err := m.profile.require("erase", !m.profile.ReadOnly); err != nil {
+		return nil, err
+	}
+	if len(opts.Patterns) > 0 {
+		if err := m.profile.require("raw write", m.profile.Raw); err != nil {
+			return nil, err
+		}
+	}
+
+	var results EraseResults
+	for i := range results {
+		results[i].Number = i + 1
+	}
+	for pass, pattern := range opts.Patterns {
+		var strips [3]string
+		for i := range strips {
+			if !sel.Has(i + 1) {
+				continue
+			}
+			strip, err := m.erasePattern(i, pattern)
+			if err != nil {
+				return &results, err
+			}
+			strips[i] = strip
+		}
+		if err := m.WriteSelectedRawTracks(sel, strips[0], strips[1], strips[2]); err != nil {
+			return &results, fmt.Errorf("overwrite pass %d (%s): %w", pass+1, pattern, err)
+		}
+		for i := range results {
+			if sel.Has(i + 1) {
+				results[i].Passes++
+			}
+		}
+	}
+
+	if err := m.EraseTracks(sel.Has(1), sel.Has(2), sel.Has(3)); err != nil {
+		return &results, fmt.Errorf("erase: %w", err)
+	}
+	for i := range results {
+		results[i].Erased = sel.Has(i + 1)
+	}
+	if !opts.Verify {
+		return &results, nil
+	}
+
+	read, err := m.ReadSelectedTracks(sel)
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		// the device found nothing to read on the card
+		read = &TrackResults{}
+	case err != nil:
+		return &results, fmt.Errorf("verify: %w", err)
+	}
+	for i := range results {
+		if !sel.Has(i + 1) {
+			continue
+		}
+		results[i].Verified = read[i].Status != TrackPresent
+		if !results[i].Verified {
+			results[i].Err = ErrNotErased
+		}
+	}
+	return &results, nil
+}
+
+// erasePattern returns the raw bytes of pattern covering track i over the
+// length of a card, at the BPI and BPC set through the MSR or else the ISO
+// ones the device starts with
+func (m *MSR) erasePattern(i int, pattern ErasePattern) (string, error) {
+	bpi, bpc := int(m.settings.Tracks[i].BPI), trackBPC(i, m.settings.Tracks[i].BPC)
+	if bpi == 0 {
+		bpi = int(isoBPI[i])
+	}
+	// raw bytes hold bpc bits each, and their length is sent in a byte
+	n := min(int(float64(bpi)*CardLength/25.4)/bpc, 255)
+
+	b := make([]byte, n)
+	if pattern == PatternRandom {
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("failed to generate random pattern: %w", err)
+		}
+		for j := range b {
+			b[j] &= byte(1<<bpc - 1)
+		}
+	}
+	return string(b), nil
+}
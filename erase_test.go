@@ -0,0 +1,105 @@
+package magstripe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecureErase(t *testing.T) {
+	sim := NewSimulator()
+	sim.SetCard(TrackData{Track1: testBankTrack1, Track2: testBankTrack2, Track3: testISO4909Track3})
+	m, err := NewMSRPort(sim, WithoutInitialReset(), WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+
+	results, err := m.SecureErase(Track1|Track2, EraseOptions{
+		Patterns: []ErasePattern{PatternZeros, PatternRandom},
+		Verify:   true,
+	})
+	if err != nil || results.Err() != nil {
+		t.Fatalf("SecureErase = %v, %v", err, results.Err())
+	}
+	for i, want := range []EraseResult{
+		{Number: 1, Passes: 2, Erased: true, Verified: true},
+		{Number: 2, Passes: 2, Erased: true, Verified: true},
+		{Number: 3},
+	} {
+		if results[i] != want {
+			t.Errorf("track %d: %+v, expected %+v", i+1, results[i], want)
+		}
+	}
+	if got := strings.Join(sim.Commands(), ""); got != "nncr" {
+		t.Errorf("commands %q", got)
+	}
+	if card := sim.Card(); card.Track1 != "" || card.Track2 != "" || card.Track3 != testISO4909Track3 {
+		t.Errorf("card after erase %+v", card)
+	}
+
+	// the random pass covers the card at the 5 bits per character the device
+	// starts with on track 2
+	pattern, err := m.erasePattern(1, PatternRandom)
+	if err != nil || len(pattern) != 50 {
+		t.Fatalf("track 2 pattern of %d bytes, %v", len(pattern), err)
+	}
+	for _, b := range []byte(pattern) {
+		if b >= 1<<5 {
+			t.Fatalf("pattern byte %#x wider than 5 bits", b)
+		}
+	}
+
+	sim.FailNext('1')
+	results, err = m.SecureErase(Track2, EraseOptions{Patterns: []ErasePattern{PatternZeros}})
+	if err == nil || !strings.Contains(err.Error(), "overwrite pass 1 (zeros)") || results[1].Passes != 0 || results[1].Erased {
+		t.Errorf("failed pass: %v, %+v", err, results[1])
+	}
+}
+
+// keepingEraser acknowledges erase commands without erasing
+type keepingEraser struct {
+	*Simulator
+}
+
+func (p keepingEraser) Write(b []byte) (int, error) {
+	if string(b[:2]) == EscapeCode+"c" {
+		return p.Simulator.Write([]byte(EscapeCode + "b"))
+	}
+	return p.Simulator.Write(b)
+}
+
+func TestSecureEraseVerify(t *testing.T) {
+	sim := NewSimulator()
+	sim.SetCard(TrackData{Track2: testBankTrack2})
+	m, err := NewMSRPort(keepingEraser{sim}, WithoutInitialReset(), WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+
+	results, err := m.SecureErase(AllTracks, EraseOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("SecureErase: %v", err)
+	}
+	if !results[0].Verified || results[1].Verified || !errors.Is(results[1].Err, ErrNotErased) {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if err := results.Err(); err == nil || !strings.HasPrefix(err.Error(), "track 2:") {
+		t.Errorf("Err() = %v", err)
+	}
+
+	ro, _ := NewMSRPort(NewSimulator(), WithoutInitialReset(), WithProfile(ProfileReadOnly))
+	if _, err := ro.SecureErase(AllTracks, EraseOptions{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestParseErasePatterns(t *testing.T) {
+	patterns, err := ParseErasePatterns("zeros, random,zeros")
+	if err != nil || len(patterns) != 3 || patterns[1] != PatternRandom || patterns[2].String() != "zeros" {
+		t.Errorf("ParseErasePatterns = %v, %v", patterns, err)
+	}
+	if _, err := ParseErasePatterns("ones"); err == nil {
+		t.Error("expected an error for an unknown pattern")
+	}
+}
@@ -18,7 +18,7 @@ func main() {
 
 	// Set bits per character for raw mode
 	fmt.Println("Setting BPC to 8,8,8...")
-	err = device.SetBPC(8, 8, 8)
+	err = device.Configure(magstripe.Settings{Tracks: [3]magstripe.TrackSettings{{BPC: 8}, {BPC: 8}, {BPC: 8}}})
 	if err != nil {
 		log.Fatal("Failed to set BPC:", err)
 	}
@@ -17,7 +17,7 @@ func main() {
 
 	// Set high coercivity for writing
 	fmt.Println("Setting high coercivity...")
-	err = device.SetCoercivity(magstripe.HiCo)
+	err = device.Configure(magstripe.Settings{Coercivity: magstripe.CoercivityHigh})
 	if err != nil {
 		log.Fatal("Failed to set coercivity:", err)
 	}
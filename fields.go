@@ -0,0 +1,182 @@
+package magstripe
+
+import "strings"
+
+// Track layouts recognised by TrackFields
+const (
+	// LayoutISO7813 is the ISO/IEC 7813 financial card layout
+	LayoutISO7813 = "iso7813"
+	// LayoutAAMVA is the AAMVA driver license and ID card layout
+	LayoutAAMVA = "aamva"
+)
+
+// Field is a named span of a track
+type Field struct {
+	Name string `json:"name"`
+	// Start and End delimit the field in the track data, End excluded
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Value string `json:"value"`
+}
+
+// TrackFields splits track data into the fields of a known layout: ISO 7813
+// financial tracks 1 and 2, or AAMVA license tracks 1 and 2 (recognised by
+// their 636 issuer prefix). Start and end sentinels are optional. The layout
+// name and fields are returned, or "" and nil when no layout matches.
+func TrackFields(track int, data string) (string, []Field) {
+	s := newFieldScanner(data)
+	switch track {
+	case 1:
+		if s.rest() != "" && s.rest()[0] == 'B' {
+			return s.parse(LayoutISO7813, iso7813Track1)
+		}
+		return s.parse(LayoutAAMVA, aamvaTrack1)
+	case 2:
+		if strings.HasPrefix(s.rest(), "636") {
+			return s.parse(LayoutAAMVA, aamvaTrack2)
+		}
+		return s.parse(LayoutISO7813, iso7813Track2)
+	}
+	return "", nil
+}
+
+// fieldSpec describes one field: a fixed width, or when width is 0 a field
+// running up to sep (or to the end of the track when sep is 0). A full field
+// of maxWidth may omit its separator when full is set. Fields with digits or
+// letters set must be numeric or upper case letters; optional fields may be
+// missing at the end of the track.
+type fieldSpec struct {
+	name     string
+	width    int
+	maxWidth int
+	sep      byte
+	full     bool
+	digits   bool
+	letters  bool
+	optional bool
+}
+
+var (
+	// iso7813Track1 is %B PAN ^ NAME ^ YYMM SVC discretionary ?
+	iso7813Track1 = []fieldSpec{
+		{name: "format", width: 1},
+		{name: "pan", sep: '^', maxWidth: 19, digits: true},
+		{name: "name", sep: '^', maxWidth: 26},
+		{name: "expiry", width: 4, digits: true},
+		{name: "service_code", width: 3, digits: true},
+		{name: "discretionary", optional: true},
+	}
+
+	// iso7813Track2 is ;PAN = YYMM SVC discretionary ?
+	iso7813Track2 = []fieldSpec{
+		{name: "pan", sep: '=', maxWidth: 19, digits: true},
+		{name: "expiry", width: 4, digits: true},
+		{name: "service_code", width: 3, digits: true},
+		{name: "discretionary", optional: true},
+	}
+
+	// aamvaTrack1 is %SS CITY ^ NAME ^ ADDRESS ^ ?, the city being 13
+	// characters or shorter and terminated by ^
+	aamvaTrack1 = []fieldSpec{
+		{name: "state", width: 2, letters: true},
+		{name: "city", sep: '^', maxWidth: 13, full: true},
+		{name: "name", sep: '^', maxWidth: 35},
+		{name: "address", sep: '^', maxWidth: 77, optional: true},
+	}
+
+	// aamvaTrack2 is ;IIN ID = YYMM CCYYMMDD overflow ?
+	aamvaTrack2 = []fieldSpec{
+		{name: "iin", width: 6, digits: true},
+		{name: "id", sep: '=', maxWidth: 13, digits: true},
+		{name: "expiry", width: 4, digits: true},
+		{name: "birth_date", width: 8, digits: true},
+		{name: "id_overflow", optional: true, digits: true},
+	}
+)
+
+// fieldScanner walks the data of a track between its sentinels
+type fieldScanner struct {
+	data     string
+	pos, end int
+}
+
+func newFieldScanner(data string) *fieldScanner {
+	s := &fieldScanner{data: data, end: len(data)}
+	if s.end > 0 && (data[0] == '%' || data[0] == ';') {
+		s.pos = 1
+	}
+	if s.end > s.pos && data[s.end-1] == '?' {
+		s.end--
+	}
+	return s
+}
+
+func (s *fieldScanner) rest() string {
+	return s.data[s.pos:s.end]
+}
+
+// parse splits the rest of the track with specs, failing when a field is
+// missing, too long or not numeric as required
+func (s *fieldScanner) parse(layout string, specs []fieldSpec) (string, []Field) {
+	var fields []Field
+	for _, spec := range specs {
+		if s.pos >= s.end {
+			if spec.optional {
+				continue
+			}
+			return "", nil
+		}
+
+		start, end, next := s.pos, s.end, s.end
+		switch {
+		case spec.width > 0:
+			if start+spec.width > s.end {
+				return "", nil
+			}
+			end, next = start+spec.width, start+spec.width
+		case spec.sep != 0:
+			i := strings.IndexByte(s.rest(), spec.sep)
+			switch {
+			case spec.full && (i == -1 || i > spec.maxWidth) && start+spec.maxWidth <= s.end:
+				end, next = start+spec.maxWidth, start+spec.maxWidth
+			case i != -1:
+				end, next = start+i, start+i+1
+			case !spec.optional:
+				return "", nil
+			}
+		}
+		if spec.maxWidth > 0 && end-start > spec.maxWidth {
+			return "", nil
+		}
+		value := s.data[start:end]
+		if (spec.digits && !isDigits(value)) || (spec.letters && !isUpper(value)) {
+			return "", nil
+		}
+		fields = append(fields, Field{Name: spec.name, Start: start, End: end, Value: value})
+		s.pos = next
+	}
+	if s.pos < s.end {
+		return "", nil
+	}
+	return layout, fields
+}
+
+// isDigits reports whether s holds only decimal digits
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isUpper reports whether s holds only upper case letters
+func isUpper(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package magstripe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrackFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		track  int
+		data   string
+		layout string
+		fields map[string]string
+	}{
+		{
+			name:   "ISO track 1",
+			track:  1,
+			data:   "%B4111111111111111^DOE/JOHN^2512101123456789?",
+			layout: LayoutISO7813,
+			fields: map[string]string{"format": "B", "pan": "4111111111111111", "name": "DOE/JOHN",
+				"expiry": "2512", "service_code": "101", "discretionary": "123456789"},
+		},
+		{
+			name:   "ISO track 2 without sentinels",
+			track:  2,
+			data:   "4111111111111111=25121011234",
+			layout: LayoutISO7813,
+			fields: map[string]string{"pan": "4111111111111111", "expiry": "2512", "service_code": "101", "discretionary": "1234"},
+		},
+		{
+			name:   "AAMVA track 1",
+			track:  1,
+			data:   "%CAANYTOWN^DOE$JOHN$Q^123 MAIN ST^?",
+			layout: LayoutAAMVA,
+			fields: map[string]string{"state": "CA", "city": "ANYTOWN", "name": "DOE$JOHN$Q", "address": "123 MAIN ST"},
+		},
+		{
+			name:   "AAMVA track 1 with a full city",
+			track:  1,
+			data:   "%TXSOUTHLAKEVILLEDOE$JANE^",
+			layout: LayoutAAMVA,
+			fields: map[string]string{"state": "TX", "city": "SOUTHLAKEVILL", "name": "EDOE$JANE"},
+		},
+		{
+			name:   "AAMVA track 2",
+			track:  2,
+			data:   ";6360141234567=2604198701011?",
+			layout: LayoutAAMVA,
+			fields: map[string]string{"iin": "636014", "id": "1234567", "expiry": "2604", "birth_date": "19870101", "id_overflow": "1"},
+		},
+		{name: "unknown track 1", track: 1, data: "%HOTEL ROOM 12?"},
+		{name: "bad PAN", track: 2, data: ";41111X11=2512101?"},
+		{name: "short track 2", track: 2, data: ";4111=25?"},
+		{name: "track 3", track: 3, data: ";011?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, fields := TrackFields(tt.track, tt.data)
+			if layout != tt.layout {
+				t.Fatalf("layout %q, expected %q", layout, tt.layout)
+			}
+			got := map[string]string{}
+			for _, f := range fields {
+				got[f.Name] = f.Value
+				if tt.data[f.Start:f.End] != f.Value {
+					t.Errorf("field %s span %d-%d does not hold %q", f.Name, f.Start, f.End, f.Value)
+				}
+			}
+			if tt.fields == nil {
+				tt.fields = map[string]string{}
+			}
+			if !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("fields %v, expected %v", got, tt.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package magstripe
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownFormat is returned by Detect when no registered format matches
+var ErrUnknownFormat = errors.New("unknown card format")
+
+// CardField is one decoded value of a card
+type CardField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Card is a card decoded by a CardFormat
+type Card interface {
+	// Format returns the name of the format that decoded the card
+	Format() string
+	// Fields lists the decoded values in display order
+	Fields() []CardField
+}
+
+// CardFormat recognises and decodes cards of one format
+type CardFormat interface {
+	// Name identifies the format in the registry
+	Name() string
+	// Decode parses the tracks and scores the match between 0 (not this
+	// format) and 1 (certainly this format)
+	Decode(tracks *TrackData) (Card, float64)
+}
+
+// funcFormat adapts a function to CardFormat
+type funcFormat struct {
+	name   string
+	decode func(*TrackData) (Card, float64)
+}
+
+func (f *funcFormat) Name() string { return f.name }
+
+func (f *funcFormat) Decode(tracks *TrackData) (Card, float64) { return f.decode(tracks) }
+
+// NewFormat returns a CardFormat with the given name and decode function
+func NewFormat(name string, decode func(tracks *TrackData) (Card, float64)) CardFormat {
+	return &funcFormat{name: name, decode: decode}
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]CardFormat{}
+)
+
+func init() {
+	for _, f := range []CardFormat{
+		NewFormat("bank", decodeBankCard),
+		NewFormat("aamva", decodeLicense),
+		NewFormat("iso4909", decodeISO4909),
+	} {
+		formats[f.Name()] = f
+	}
+}
+
+// RegisterFormat adds a format to the ones tried by Detect. Names must be unique.
+func RegisterFormat(f CardFormat) error {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if _, ok := formats[f.Name()]; ok {
+		return fmt.Errorf("card format %q already registered", f.Name())
+	}
+	formats[f.Name()] = f
+	return nil
+}
+
+// LookupFormat returns the registered format with the given name
+func LookupFormat(name string) (CardFormat, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	f, ok := formats[name]
+	return f, ok
+}
+
+// FormatNames returns the names of the registered formats in sorted order
+func FormatNames() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Detection is a format matching a card
+type Detection struct {
+	Format string
+	Score  float64
+	Card   Card
+}
+
+// DetectAll tries every registered format on the tracks and returns those
+// that match, best score first
+func DetectAll(tracks *TrackData) []Detection {
+	formatsMu.RLock()
+	candidates := make([]CardFormat, 0, len(formats))
+	for _, f := range formats {
+		candidates = append(candidates, f)
+	}
+	formatsMu.RUnlock()
+
+	var matches []Detection
+	for _, f := range candidates {
+		card, score := f.Decode(tracks)
+		if card != nil && score > 0 {
+			matches = append(matches, Detection{Format: f.Name(), Score: score, Card: card})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Format < matches[j].Format
+	})
+	return matches
+}
+
+// Detect returns the best match for the tracks among the registered formats
+func Detect(tracks *TrackData) (Detection, error) {
+	matches := DetectAll(tracks)
+	if len(matches) == 0 {
+		return Detection{}, ErrUnknownFormat
+	}
+	return matches[0], nil
+}
+
+// fieldMap indexes fields by name
+func fieldMap(fields []Field) map[string]string {
+	m := make(map[string]string, len(fields))
+	for _, f := range fields {
+		m[f.Name] = f.Value
+	}
+	return m
+}
+
+// luhnValid reports whether a card number passes the Luhn check
+func luhnValid(number string) bool {
+	if len(number) < 2 || !isDigits(number) {
+		return false
+	}
+	sum := 0
+	for i := 0; i < len(number); i++ {
+		d := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// BankCard is a financial card with ISO 7813 tracks 1 and 2
+type BankCard struct {
+	PAN  string `json:"pan"`
+	Name string `json:"name,omitempty"`
+	// Expiry is the expiration date as YYMM
+	Expiry        string `json:"expiry"`
+	ServiceCode   string `json:"service_code"`
+	Discretionary string `json:"discretionary,omitempty"`
+	// LuhnValid is set when the PAN check digit is correct
+	LuhnValid bool `json:"luhn_valid"`
+}
+
+func (c *BankCard) Format() string { return "bank" }
+
+func (c *BankCard) Fields() []CardField {
+	fields := []CardField{{"pan", c.PAN}}
+	if c.Name != "" {
+		fields = append(fields, CardField{"name", c.Name})
+	}
+	fields = append(fields,
+		CardField{"expiry", c.Expiry},
+		CardField{"service_code", c.ServiceCode},
+	)
+	if c.Discretionary != "" {
+		fields = append(fields, CardField{"discretionary", c.Discretionary})
+	}
+	return append(fields, CardField{"luhn_valid", fmt.Sprint(c.LuhnValid)})
+}
+
+// decodeBankCard scores 0.35 for each ISO 7813 track, less 0.2 when the
+// tracks disagree on the PAN, and 0.3 for a valid Luhn digit
+func decodeBankCard(tracks *TrackData) (Card, float64) {
+	layout1, fields1 := TrackFields(1, tracks.Track1)
+	layout2, fields2 := TrackFields(2, tracks.Track2)
+	t1, t2 := fieldMap(fields1), fieldMap(fields2)
+	has1, has2 := layout1 == LayoutISO7813, layout2 == LayoutISO7813
+	if !has1 && !has2 {
+		return nil, 0
+	}
+
+	c := &BankCard{}
+	score := 0.0
+	if has1 {
+		c.PAN, c.Name, c.Expiry, c.ServiceCode, c.Discretionary = t1["pan"], t1["name"], t1["expiry"], t1["service_code"], t1["discretionary"]
+		score += 0.35
+	}
+	if has2 {
+		if !has1 {
+			c.PAN, c.Expiry, c.ServiceCode, c.Discretionary = t2["pan"], t2["expiry"], t2["service_code"], t2["discretionary"]
+		} else if t2["pan"] != c.PAN {
+			score -= 0.2
+		}
+		score += 0.35
+	}
+	c.LuhnValid = luhnValid(c.PAN)
+	if c.LuhnValid {
+		score += 0.3
+	}
+	return c, score
+}
+
+// License is an AAMVA driver license or ID card
+type License struct {
+	State   string `json:"state,omitempty"`
+	City    string `json:"city,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address,omitempty"`
+	// IIN is the issuer identification number, 636xxx
+	IIN string `json:"iin,omitempty"`
+	ID  string `json:"id,omitempty"`
+	// Expiry is the expiration date as YYMM
+	Expiry string `json:"expiry,omitempty"`
+	// BirthDate is the date of birth as CCYYMMDD
+	BirthDate string `json:"birth_date,omitempty"`
+}
+
+func (c *License) Format() string { return "aamva" }
+
+func (c *License) Fields() []CardField {
+	var fields []CardField
+	for _, f := range []CardField{
+		{"state", c.State}, {"city", c.City}, {"name", c.Name}, {"address", c.Address},
+		{"iin", c.IIN}, {"id", c.ID}, {"expiry", c.Expiry}, {"birth_date", c.BirthDate},
+	} {
+		if f.Value != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// decodeLicense scores 0.6 for an AAMVA track 2, which carries the 636 issuer
+// prefix, and 0.3 for track 1, plus 0.1 when both are present
+func decodeLicense(tracks *TrackData) (Card, float64) {
+	layout1, fields1 := TrackFields(1, tracks.Track1)
+	layout2, fields2 := TrackFields(2, tracks.Track2)
+	has1, has2 := layout1 == LayoutAAMVA, layout2 == LayoutAAMVA
+	if !has1 && !has2 {
+		return nil, 0
+	}
+
+	c := &License{}
+	score := 0.0
+	if has1 {
+		t1 := fieldMap(fields1)
+		c.State, c.City, c.Name, c.Address = t1["state"], t1["city"], t1["name"], t1["address"]
+		score += 0.3
+	}
+	if has2 {
+		t2 := fieldMap(fields2)
+		c.IIN, c.ID, c.Expiry, c.BirthDate = t2["iin"], t2["id"]+t2["id_overflow"], t2["expiry"], t2["birth_date"]
+		score += 0.6
+	}
+	if has1 && has2 {
+		score += 0.1
+	}
+	return c, score
+}
+
+// ISO4909Card is a financial card with an ISO 4909 track 3
+type ISO4909Card struct {
+	FormatCode string `json:"format_code"`
+	PAN        string `json:"pan"`
+	// Country and Currency are ISO 3166 and ISO 4217 numeric codes, empty when absent
+	Country  string `json:"country,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	// Control holds the fixed fields between the currency code and the expiry
+	// date (see iso4909Fixed)
+	Control string `json:"control"`
+	// Expiry is the expiration date as YYMM
+	Expiry string `json:"expiry"`
+	// Data holds the fields following the expiry date
+	Data      string `json:"data,omitempty"`
+	LuhnValid bool   `json:"luhn_valid"`
+}
+
+func (c *ISO4909Card) Format() string { return "iso4909" }
+
+func (c *ISO4909Card) Fields() []CardField {
+	fields := []CardField{{"format_code", c.FormatCode}, {"pan", c.PAN}}
+	if c.Country != "" {
+		fields = append(fields, CardField{"country", c.Country})
+	}
+	if c.Currency != "" {
+		fields = append(fields, CardField{"currency", c.Currency})
+	}
+	fields = append(fields, CardField{"control", c.Control}, CardField{"expiry", c.Expiry})
+	if c.Data != "" {
+		fields = append(fields, CardField{"data", c.Data})
+	}
+	return append(fields, CardField{"luhn_valid", fmt.Sprint(c.LuhnValid)})
+}
+
+// iso4909Fixed is the width of the fixed fields between the currency code and
+// the expiry date: exponent, amount authorised, amount remaining, cycle begin,
+// cycle length, retry count, PIN control parameters, interchange control and
+// the PAN, SAN-1 and SAN-2 service restrictions
+const iso4909Fixed = 1 + 4 + 4 + 4 + 2 + 1 + 6 + 1 + 2 + 2 + 2
+
+// decodeISO4909 parses ;FC PAN = CCC|= CUR fixed YYMM ... ? from track 3,
+// scoring 0.6 for the layout and 0.3 more for a valid Luhn digit
+func decodeISO4909(tracks *TrackData) (Card, float64) {
+	s := newFieldScanner(tracks.Track3)
+	rest := s.rest()
+
+	sep := strings.IndexByte(rest, '=')
+	if sep < 3 || sep > 2+19 || !isDigits(rest[:sep]) {
+		return nil, 0
+	}
+	c := &ISO4909Card{FormatCode: rest[:2], PAN: rest[2:sep]}
+	rest = rest[sep+1:]
+
+	if len(rest) > 0 && rest[0] == '=' {
+		rest = rest[1:]
+	} else if len(rest) >= 3 && isDigits(rest[:3]) {
+		c.Country, rest = rest[:3], rest[3:]
+	} else {
+		return nil, 0
+	}
+	if len(rest) < 3+iso4909Fixed+4 || !isDigits(rest[:3+iso4909Fixed+4]) {
+		return nil, 0
+	}
+	c.Currency = rest[:3]
+	c.Control = rest[3 : 3+iso4909Fixed]
+	c.Expiry = rest[3+iso4909Fixed : 3+iso4909Fixed+4]
+	c.Data = rest[3+iso4909Fixed+4:]
+	c.LuhnValid = luhnValid(c.PAN)
+
+	score := 0.6
+	if c.LuhnValid {
+		score += 0.3
+	}
+	return c, score
+}
@@ -0,0 +1,144 @@
+package magstripe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testBankTrack1    = "%B4111111111111111^DOE/JOHN^2512101000000000?"
+	testBankTrack2    = ";4111111111111111=2512101000000000?"
+	testLicenseTrack1 = "%CASACRAMENTO^DOE$JOHN^123 MAIN ST^?"
+	testLicenseTrack2 = ";6360281234567890=251219800101?"
+	testISO4909Track3 = ";014111111111111111=840840" + "20000100001000001200000000000" + "2512" + "0000?"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name   string
+		tracks TrackData
+		format string
+		score  float64
+		fields string
+	}{
+		{
+			name:   "bank card",
+			tracks: TrackData{Track1: testBankTrack1, Track2: testBankTrack2},
+			format: "bank",
+			score:  1,
+			fields: "pan=4111111111111111 name=DOE/JOHN expiry=2512 service_code=101 discretionary=000000000 luhn_valid=true",
+		},
+		{
+			name:   "bank card track 2 only, bad check digit",
+			tracks: TrackData{Track2: ";4111111111111112=2512101?"},
+			format: "bank",
+			score:  0.35,
+			fields: "pan=4111111111111112 expiry=2512 service_code=101 luhn_valid=false",
+		},
+		{
+			name:   "license",
+			tracks: TrackData{Track1: testLicenseTrack1, Track2: testLicenseTrack2},
+			format: "aamva",
+			score:  1,
+			fields: "state=CA city=SACRAMENTO name=DOE$JOHN address=123 MAIN ST iin=636028 id=1234567890 expiry=2512 birth_date=19800101",
+		},
+		{
+			name:   "iso 4909",
+			tracks: TrackData{Track3: testISO4909Track3},
+			format: "iso4909",
+			score:  0.9,
+			fields: "format_code=01 pan=4111111111111111 country=840 currency=840 control=20000100001000001200000000000 expiry=2512 data=0000 luhn_valid=true",
+		},
+		{
+			name:   "iso 4909 without country",
+			tracks: TrackData{Track3: ";014111111111111111==978" + "20000100001000001200000000000" + "2512?"},
+			format: "iso4909",
+			score:  0.9,
+			fields: "format_code=01 pan=4111111111111111 currency=978 control=20000100001000001200000000000 expiry=2512 luhn_valid=true",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Detect(&tt.tracks)
+			if err != nil {
+				t.Fatalf("Detect: %v", err)
+			}
+			if d.Format != tt.format || d.Card.Format() != tt.format {
+				t.Errorf("format %q (card %q), expected %q", d.Format, d.Card.Format(), tt.format)
+			}
+			if diff := d.Score - tt.score; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("score %v, expected %v", d.Score, tt.score)
+			}
+			var fields []string
+			for _, f := range d.Card.Fields() {
+				fields = append(fields, f.Name+"="+f.Value)
+			}
+			if got := strings.Join(fields, " "); got != tt.fields {
+				t.Errorf("fields\n got %s\nwant %s", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestDetectUnknown(t *testing.T) {
+	for _, tracks := range []TrackData{
+		{},
+		{Track1: "%HELLO?"},
+		{Track3: ";12345?"},
+	} {
+		if d, err := Detect(&tracks); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("Detect(%+v) = %+v, %v; expected ErrUnknownFormat", tracks, d, err)
+		}
+	}
+}
+
+type testCard struct{ value string }
+
+func (c *testCard) Format() string      { return "test" }
+func (c *testCard) Fields() []CardField { return []CardField{{"value", c.value}} }
+
+func TestRegisterFormat(t *testing.T) {
+	f := NewFormat("test-hotel", func(tracks *TrackData) (Card, float64) {
+		if !strings.HasPrefix(tracks.Track2, ";4111") {
+			return nil, 0
+		}
+		return &testCard{tracks.Track2}, 2
+	})
+	if err := RegisterFormat(f); err != nil {
+		t.Fatalf("RegisterFormat: %v", err)
+	}
+	t.Cleanup(func() {
+		formatsMu.Lock()
+		delete(formats, f.Name())
+		formatsMu.Unlock()
+	})
+	if err := RegisterFormat(f); err == nil {
+		t.Error("expected an error registering a duplicate name")
+	}
+	if got, ok := LookupFormat("test-hotel"); !ok || got != f {
+		t.Error("LookupFormat did not find the format")
+	}
+	if got := strings.Join(FormatNames(), ","); got != "aamva,bank,iso4909,test-hotel" {
+		t.Errorf("FormatNames() = %s", got)
+	}
+
+	matches := DetectAll(&TrackData{Track1: testBankTrack1, Track2: testBankTrack2})
+	if len(matches) != 2 || matches[0].Format != "test-hotel" || matches[1].Format != "bank" {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	for number, want := range map[string]bool{
+		"4111111111111111": true,
+		"4111111111111112": false,
+		"79927398713":      true,
+		"0":                false,
+		"41111x1111111111": false,
+	} {
+		if got := luhnValid(number); got != want {
+			t.Errorf("luhnValid(%q) = %v", number, got)
+		}
+	}
+}
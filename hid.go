@@ -0,0 +1,112 @@
+package magstripe
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// HID report framing used by the MSR605X
+const (
+	hidReportSize  = 64
+	hidFirstPacket = 0x80
+	hidLastPacket  = 0x40
+	hidLengthMask  = 0x3F
+)
+
+// hidPort carries the MSR byte stream over USB HID reports on a Linux hidraw node.
+// Each 64 byte report starts with a header byte holding first/last flags and the
+// payload length.
+type hidPort struct {
+	file    *os.File
+	timeout time.Duration
+	// report holds the last report read and pending the payload not yet
+	// returned from it; the report is zeroed once its payload is consumed
+	report  [hidReportSize]byte
+	pending []byte
+}
+
+// openHID opens a hidraw device node as a Port
+func openHID(path string) (*hidPort, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open HID device: %w", err)
+	}
+	return &hidPort{file: f}, nil
+}
+
+// Write splits p into HID reports
+func (h *hidPort) Write(p []byte) (int, error) {
+	// The leading zero is the report ID expected by hidraw
+	report := make([]byte, hidReportSize+1)
+	defer clear(report)
+	written := 0
+	for first := true; first || written < len(p); first = false {
+		n := len(p) - written
+		if n > hidLengthMask {
+			n = hidLengthMask
+		}
+		clear(report)
+		header := byte(n)
+		if first {
+			header |= hidFirstPacket
+		}
+		if written+n == len(p) {
+			header |= hidLastPacket
+		}
+		report[1] = header
+		copy(report[2:], p[written:written+n])
+		if _, err := h.file.Write(report); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Read returns payload bytes from incoming HID reports, or 0 when the read timeout expires
+func (h *hidPort) Read(p []byte) (int, error) {
+	if len(h.pending) == 0 {
+		if h.timeout > 0 {
+			h.file.SetReadDeadline(time.Now().Add(h.timeout))
+		}
+		n, err := h.file.Read(h.report[:])
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		h.pending = hidPayload(h.report[:n])
+	}
+	n := copy(p, h.pending)
+	h.pending = h.pending[n:]
+	if len(h.pending) == 0 {
+		clear(h.report[:])
+	}
+	return n, nil
+}
+
+// SetReadTimeout sets how long Read waits for a report
+func (h *hidPort) SetReadTimeout(t time.Duration) error {
+	h.timeout = t
+	return nil
+}
+
+// Close closes the hidraw device
+func (h *hidPort) Close() error {
+	return h.file.Close()
+}
+
+// hidPayload extracts the payload of a single HID report
+func hidPayload(report []byte) []byte {
+	if len(report) == 0 {
+		return nil
+	}
+	n := int(report[0] & hidLengthMask)
+	if n > len(report)-1 {
+		n = len(report) - 1
+	}
+	return report[1 : 1+n]
+}
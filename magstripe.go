@@ -1,8 +1,11 @@
 package magstripe
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -11,28 +14,62 @@ import (
 
 // MSR represents a magnetic stripe card reader/writer
 type MSR struct {
-	port serial.Port
+	port           Port
+	profile        *Profile
+	readTimeout    time.Duration
+	commandTimeout time.Duration
+	logger         *slog.Logger
+	tracer         Tracer
+	redact         Redact
+	retry          RetryPolicy
+	metrics        Metrics
+	recording      io.Writer
+	reconnect      *ReconnectPolicy
+	state          ConnState
+	// traceRaw is set while a raw read or write is traced, whose datablocks
+	// are masked whole
+	traceRaw bool
+
+	// settings last applied with Configure, recorded in card dumps and
+	// restored on reconnecting; unset when not made through this MSR
+	settings Settings
 }
 
+// Port is the byte stream an MSR talks to its device over. serial.Port satisfies it.
+type Port interface {
+	io.ReadWriteCloser
+	// SetReadTimeout bounds how long Read blocks; a Read that times out returns 0, nil
+	SetReadTimeout(t time.Duration) error
+}
+
+// ErrTimeout is returned when the device does not answer a command in time
+var ErrTimeout = errors.New("operation timed out")
+
 // Protocol constants
 const (
 	EscapeCode = "\x1B"
 	EndCode    = "\x1C"
 )
 
-// Coercivity constants
+// Coercivity constants for SetCoercivity.
+//
+// Deprecated: use CoercivityHigh and CoercivityLow with Configure.
 const (
 	HiCo = true
 	LoCo = false
 )
 
-// BPI constants
+// BPI constants for SetBPI.
+//
+// Deprecated: use BPI210 and BPI75 with Configure.
 const (
 	HiBPI = true
 	LoBPI = false
 )
 
-// Character mappings
+// Character mappings for PackRaw and UnpackRaw. Track1Map is kept as it has
+// always been, which differs from CharsetIATA for codes 12 and 13; the Charset
+// API encodes track 1 by ISO/IEC 7811.
 var (
 	Track1Map  = " !\"#$%&'()*+`,./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_"
 	Track23Map = "0123456789:;<=>?"
@@ -54,64 +91,194 @@ type RawData struct {
 }
 
 // NewMSR creates a new MSR instance
-func NewMSR(devPath string) (*MSR, error) {
-	if !strings.Contains(devPath, "/") && !strings.Contains(devPath, "\\") {
-		if strings.Contains(devPath, "COM") {
-			// Windows
-			devPath = devPath
-		} else {
-			// Unix-like
-			devPath = "/dev/" + devPath
+func NewMSR(devPath string, opts ...Option) (*MSR, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bare names like "ttyUSB0" are resolved under /dev; Windows "COMx" names are used as is
+	if !strings.Contains(devPath, "/") && !strings.Contains(devPath, "\\") && !strings.Contains(devPath, "COM") {
+		devPath = "/dev/" + devPath
+	}
+
+	if cfg.reconnect != nil && cfg.reconnect.Open == nil {
+		cfg.reconnect.Open = func() (Port, error) {
+			return openPort(devPath, cfg)
+		}
+	}
+	port, err := openPort(devPath, cfg)
+	if err != nil {
+		return nil, err
+	}
+	msr, err := newMSR(port, cfg)
+	if err != nil {
+		port.Close()
+		return nil, err
+	}
+	return msr, nil
+}
+
+// openPort opens the serial or HID device at devPath
+func openPort(devPath string, cfg config) (Port, error) {
+	if cfg.profile.HID {
+		cfg.logger.Debug("opening HID device", "path", devPath, "model", cfg.profile.Name)
+		port, err := openHID(devPath)
+		if err != nil {
+			return nil, err
 		}
+		return port, nil
 	}
 
 	mode := &serial.Mode{
-		BaudRate: 9600,
+		BaudRate: cfg.baudRate,
 		DataBits: 8,
 		Parity:   serial.NoParity,
 		StopBits: serial.OneStopBit,
 	}
-
+	cfg.logger.Debug("opening serial port", "path", devPath, "baud", cfg.baudRate, "model", cfg.profile.Name)
 	port, err := serial.Open(devPath, mode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open serial port: %w", err)
 	}
+	return port, nil
+}
+
+// NewMSRPort creates an MSR that talks over an already open Port. The baud rate
+// option is ignored since the port is configured by the caller.
+func NewMSRPort(port Port, opts ...Option) (*MSR, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newMSR(port, cfg)
+}
 
-	msr := &MSR{port: port}
-	msr.Reset()
+// newMSR prepares the port and builds an MSR from a validated config
+func newMSR(port Port, cfg config) (*MSR, error) {
+	if cfg.recording != nil {
+		port = NewRecorder(port, cfg.recording)
+	}
+	if err := port.SetReadTimeout(cfg.readTimeout); err != nil {
+		return nil, fmt.Errorf("failed to set read timeout: %w", err)
+	}
+
+	msr := &MSR{
+		port:           port,
+		profile:        cfg.profile,
+		readTimeout:    cfg.readTimeout,
+		commandTimeout: cfg.commandTimeout,
+		logger:         cfg.logger,
+		tracer:         cfg.tracer,
+		redact:         cfg.redact,
+		retry:          cfg.retry,
+		metrics:        cfg.metrics,
+		recording:      cfg.recording,
+		reconnect:      cfg.reconnect,
+	}
+	if cfg.initialReset {
+		if err := msr.Reset(); err != nil {
+			cfg.logger.Warn("initial reset failed", "error", err)
+		}
+	}
 	return msr, nil
 }
 
+// Profile returns a copy of the device profile in use
+func (m *MSR) Profile() Profile {
+	return *m.profile.clone()
+}
+
+// SetCommandTimeout changes how long to wait for the device to answer a
+// command, set initially with WithCommandTimeout. Like every MSR method it must
+// not be called while another is running.
+func (m *MSR) SetCommandTimeout(d time.Duration) {
+	m.commandTimeout = d
+}
+
 // Close closes the serial connection
 func (m *MSR) Close() error {
+	if m.state == StateDisconnected {
+		// the port was closed when the device was lost
+		return nil
+	}
 	return m.port.Close()
 }
 
 // executeNoResult sends a command without expecting a result
 func (m *MSR) executeNoResult(command string) error {
+	if err := m.connect(); err != nil {
+		return err
+	}
+	m.trace(Sent, []byte(EscapeCode+command))
 	_, err := m.port.Write([]byte(EscapeCode + command))
 	if err != nil {
-		return err
+		return m.portError(err)
 	}
 	time.Sleep(100 * time.Millisecond)
 	return nil
 }
 
-// executeWaitResult sends a command and waits for a result
+// executeWaitResult sends a command and waits for a result, trying again as
+// set with WithRetry
 func (m *MSR) executeWaitResult(command string, timeout time.Duration) (status byte, result string, data string, err error) {
+	for attempt := 1; ; attempt++ {
+		response, status, res, block, err := m.exchangeStatus(command, timeout)
+		if m.retryNext(command, attempt, status, err) {
+			clear(response)
+			continue
+		}
+		result, data = string(res), string(block)
+		clear(response)
+		return status, result, data, err
+	}
+}
+
+// exchangeStatus runs a command and splits the response, resynchronising
+// with the device when it cannot be parsed, and reports it to the metrics. The
+// caller clears the response.
+func (m *MSR) exchangeStatus(command string, timeout time.Duration) (response []byte, status byte, result, data []byte, err error) {
+	start := time.Now()
+	defer func() { m.measure(command[:1], start, status, data, err) }()
+	response, err = m.exchange(command, timeout)
+	if err != nil {
+		return nil, 0, nil, nil, err
+	}
+	status, result, data, err = m.profile.Framing.Split(response)
+	if err != nil {
+		m.resync()
+		clear(response)
+		return nil, 0, nil, nil, err
+	}
+	return response, status, result, data, nil
+}
+
+// exchange sends a command and collects the response. The buffers used on the
+// way are zeroed; the caller clears the response once parsed.
+func (m *MSR) exchange(command string, timeout time.Duration) ([]byte, error) {
+	if err := m.connect(); err != nil {
+		return nil, err
+	}
+
 	// Clear input buffer by reading any available data
 	clearBuffer := make([]byte, 1024)
+	defer clear(clearBuffer)
 	for {
-		n, _ := m.port.Read(clearBuffer)
+		n, err := m.port.Read(clearBuffer)
+		if err != nil && m.reconnect != nil {
+			return nil, m.portError(err)
+		}
 		if n == 0 {
 			break
 		}
 	}
 
 	// Send command
-	_, err = m.port.Write([]byte(EscapeCode + command))
-	if err != nil {
-		return 0, "", "", err
+	cmd := []byte(EscapeCode + command)
+	defer clear(cmd)
+	m.trace(Sent, cmd)
+	if _, err := m.port.Write(cmd); err != nil {
+		return nil, m.portError(err)
 	}
 	time.Sleep(100 * time.Millisecond)
 
@@ -119,22 +286,25 @@ func (m *MSR) executeWaitResult(command string, timeout time.Duration) (status b
 	startTime := time.Now()
 	var response []byte
 	buffer := make([]byte, 1024)
+	defer clear(buffer)
 
 	// Set read timeout
-	oldTimeout := time.Millisecond * 100
 	m.port.SetReadTimeout(timeout)
-	defer m.port.SetReadTimeout(oldTimeout)
+	defer m.port.SetReadTimeout(m.readTimeout)
 
 	for time.Since(startTime) < timeout {
 		n, err := m.port.Read(buffer)
 		if err != nil && n == 0 {
+			if m.reconnect != nil {
+				return nil, m.portError(err)
+			}
 			// Timeout or other error with no data
 			break
 		}
 		if n > 0 {
-			response = append(response, buffer[:n]...)
+			response = appendWiped(response, buffer[:n])
 			// Check if we have a complete response
-			if len(response) > 0 && strings.Contains(string(response), EscapeCode) {
+			if m.profile.Framing.Complete(response) {
 				break
 			}
 		}
@@ -143,95 +313,139 @@ func (m *MSR) executeWaitResult(command string, timeout time.Duration) (status b
 		}
 	}
 
+	if len(response) > 0 {
+		m.trace(Received, response)
+	}
 	if len(response) == 0 {
-		return 0, "", "", errors.New("operation timed out")
+		m.logger.Debug("command timed out", "command", command[:1], "timeout", timeout)
+		return nil, ErrTimeout
 	}
+	return response, nil
+}
 
-	// Parse result: status, result, data
-	responseStr := string(response)
-	pos := strings.LastIndex(responseStr, EscapeCode)
+// splitResponse parses a response into its status, result and data
+func splitResponse(response []byte) (status byte, result, data []byte, err error) {
+	pos := bytes.LastIndexByte(response, EscapeCode[0])
 	if pos == -1 {
-		return 0, "", "", errors.New("invalid response format")
+		return 0, nil, nil, fmt.Errorf("%w: invalid response format", ErrFraming)
 	}
 
-	if pos+1 >= len(responseStr) {
-		return 0, "", "", errors.New("incomplete response")
+	if pos+1 >= len(response) {
+		return 0, nil, nil, fmt.Errorf("%w: incomplete response", ErrFraming)
 	}
 
-	status = responseStr[pos+1]
-	if pos+2 < len(responseStr) {
-		result = responseStr[pos+2:]
+	status = response[pos+1]
+	if pos+2 < len(response) {
+		result = response[pos+2:]
 	}
 	if pos > 0 {
-		data = responseStr[:pos]
+		data = response[:pos]
 	}
 
 	return status, result, data, nil
 }
 
+// responseComplete reports whether response holds a full answer: a bare
+// <ESC>status, a firmware version <ESC>REVxx.xx, or a datablock ending in
+// ?<FS> followed by <ESC>status
+func responseComplete(response []byte) bool {
+	n := len(response)
+	if n < 2 || response[0] != EscapeCode[0] {
+		return false
+	}
+	if response[1] == 'R' {
+		return n >= len(EscapeCode+"REVX1.00")
+	}
+	if response[1] != 's' {
+		return true
+	}
+	return n >= 6 && response[n-2] == EscapeCode[0] && string(response[n-4:n-2]) == "?"+EndCode
+}
+
 // Reset resets the MSR device
 func (m *MSR) Reset() error {
-	return m.executeNoResult("a")
+	return m.executeNoResult(m.profile.Commands.Reset)
 }
 
-// decodeISODataBlock decodes ISO format data block
-func decodeISODataBlock(data string) (string, string, string, error) {
+// Firmware returns the firmware version reported by the device, e.g. "REVH1.02"
+func (m *MSR) Firmware() (string, error) {
+	if err := m.profile.require("firmware", m.profile.Commands.Version != ""); err != nil {
+		return "", err
+	}
+
+	status, result, _, err := m.executeWaitResult(m.profile.Commands.Version, m.commandTimeout)
+	if err != nil {
+		return "", err
+	}
+	version := string(status) + result
+	if !strings.HasPrefix(version, "REV") {
+		return "", fmt.Errorf("bad firmware version response: %q", version)
+	}
+	return version, nil
+}
+
+// decodeISODataBlock decodes ISO format data block, redacting errors with p
+func decodeISODataBlock(data string, p Redact) (string, string, string, error) {
+	return decodeDataBlock(data, isoDataBlockStrips, p)
+}
+
+// decodeDataBlock splits a data block into the three strips located by
+// strips, redacting errors with p
+func decodeDataBlock(data string, strips func([]byte, Redact) ([3]Span, error), p Redact) (string, string, string, error) {
+	spans, err := strips([]byte(data), p)
+	if err != nil {
+		return "", "", "", err
+	}
+	return data[spans[0].Start:spans[0].End], data[spans[1].Start:spans[1].End], data[spans[2].Start:spans[2].End], nil
+}
+
+// isoDataBlockStrips locates the three strips of an ISO format data block
+func isoDataBlockStrips(data []byte, p Redact) ([3]Span, error) {
+	var strips [3]Span
+
 	// Check header
-	if len(data) < 4 || data[:4] != EscapeCode+"s"+EscapeCode+"\x01" {
-		return "", "", "", fmt.Errorf("bad datablock: doesn't start with <ESC>s<ESC>[01]: %v", data)
+	if len(data) < 4 || string(data[:4]) != EscapeCode+"s"+EscapeCode+"\x01" {
+		return strips, fmt.Errorf("bad datablock: doesn't start with <ESC>s<ESC>[01]: %s", p.block(string(data), false))
 	}
 
 	// Check end
-	if len(data) < 2 || data[len(data)-2:] != "?"+EndCode {
-		return "", "", "", fmt.Errorf("bad datablock: doesn't end with ?<FS>: %v", data)
+	if len(data) < 2 || string(data[len(data)-2:]) != "?"+EndCode {
+		return strips, fmt.Errorf("bad datablock: doesn't end with ?<FS>: %s", p.block(string(data), false))
 	}
 
-	// Parse strips
-	var strip1, strip2, strip3 string
-
 	// First strip
 	strip1Start := 4
-	strip1End := strings.Index(data[strip1Start:], EscapeCode)
+	strip1End := bytes.IndexByte(data[strip1Start:], EscapeCode[0])
 	if strip1End == -1 {
-		return "", "", "", fmt.Errorf("bad datablock: missing escape code after strip 1")
+		return strips, fmt.Errorf("bad datablock: missing escape code after strip 1")
 	}
 	strip1End += strip1Start
-
-	if strip1End == strip1Start {
-		strip1End += 2
-	} else {
-		strip1 = data[strip1Start:strip1End]
-	}
+	strips[0] = Span{Start: strip1Start, End: strip1End}
 
 	// Second strip
 	strip2Start := strip1End + 2
-	if strip2Start >= len(data) || data[strip1End:strip2Start] != EscapeCode+"\x02" {
-		return "", "", "", fmt.Errorf("bad datablock: missing <ESC>[02] at position %d", strip1End)
+	if strip2Start >= len(data) || string(data[strip1End:strip2Start]) != EscapeCode+"\x02" {
+		return strips, fmt.Errorf("bad datablock: missing <ESC>[02] at position %d", strip1End)
 	}
 
-	strip2End := strings.Index(data[strip2Start:], EscapeCode)
+	strip2End := bytes.IndexByte(data[strip2Start:], EscapeCode[0])
 	if strip2End == -1 {
-		return "", "", "", fmt.Errorf("bad datablock: missing escape code after strip 2")
+		return strips, fmt.Errorf("bad datablock: missing escape code after strip 2")
 	}
 	strip2End += strip2Start
-
-	if strip2End == strip2Start {
-		strip2End += 2
-	} else {
-		strip2 = data[strip2Start:strip2End]
-	}
+	strips[1] = Span{Start: strip2Start, End: strip2End}
 
 	// Third strip
 	strip3Start := strip2End + 2
-	if strip3Start >= len(data) || data[strip2End:strip3Start] != EscapeCode+"\x03" {
-		return "", "", "", fmt.Errorf("bad datablock: missing <ESC>[03] at position %d", strip2End)
+	if strip3Start >= len(data) || string(data[strip2End:strip3Start]) != EscapeCode+"\x03" {
+		return strips, fmt.Errorf("bad datablock: missing <ESC>[03] at position %d", strip2End)
 	}
 
 	if strip3Start < len(data) && data[strip3Start] != EscapeCode[0] {
-		strip3 = data[strip3Start : len(data)-2]
+		strips[2] = Span{Start: strip3Start, End: len(data) - 2}
 	}
 
-	return strip1, strip2, strip3, nil
+	return strips, nil
 }
 
 // encodeISODataBlock encodes data into ISO format
@@ -241,15 +455,15 @@ func encodeISODataBlock(strip1, strip2, strip3 string) string {
 
 // ReadTracks reads magnetic tracks in ISO format
 func (m *MSR) ReadTracks() (*TrackData, error) {
-	status, _, data, err := m.executeWaitResult("r", 10*time.Second)
+	status, _, data, err := m.executeWaitResult(m.profile.Commands.ReadISO, m.commandTimeout)
 	if err != nil {
 		return nil, err
 	}
-	if status != '0' {
-		return nil, fmt.Errorf("read error: %c", status)
+	if err := m.profile.checkStatus("read", status); err != nil {
+		return nil, err
 	}
 
-	strip1, strip2, strip3, err := decodeISODataBlock(data)
+	strip1, strip2, strip3, err := decodeDataBlock(data, m.profile.Framing.ISOStrips, m.redact)
 	if err != nil {
 		return nil, err
 	}
@@ -263,19 +477,19 @@ func (m *MSR) ReadTracks() (*TrackData, error) {
 
 // WriteTracks writes magnetic tracks in ISO format
 func (m *MSR) WriteTracks(t1, t2, t3 string) error {
-	data := encodeISODataBlock(t1, t2, t3)
-	status, _, _, err := m.executeWaitResult("w"+data, 10*time.Second)
-	if err != nil {
+	if err := m.profile.require("write", !m.profile.ReadOnly); err != nil {
 		return err
 	}
-	if status != '0' {
-		return fmt.Errorf("write error: %c", status)
-	}
-	return nil
+
+	return m.writeSelected(m.profile.Commands.WriteISO, encodeISODataBlock(t1, t2, t3), AllTracks)
 }
 
 // EraseTracks erases specified magnetic tracks
 func (m *MSR) EraseTracks(t1, t2, t3 bool) error {
+	if err := m.profile.require("erase", !m.profile.ReadOnly); err != nil {
+		return err
+	}
+
 	mask := 0
 	if t1 {
 		mask |= 1
@@ -287,132 +501,150 @@ func (m *MSR) EraseTracks(t1, t2, t3 bool) error {
 		mask |= 4
 	}
 
-	status, _, _, err := m.executeWaitResult("c"+string(byte(mask)), 10*time.Second)
+	status, _, _, err := m.executeWaitResult(m.profile.Commands.Erase+string(byte(mask)), m.commandTimeout)
 	if err != nil {
 		return err
 	}
-	if status != '0' {
-		return fmt.Errorf("erase error: %c", status)
+	if err := m.profile.checkStatus("erase", status); err != nil {
+		return err
 	}
 	return nil
 }
 
 // SetCoercivity sets coercivity mode (high or low)
+//
+// Deprecated: use Configure with CoercivityHigh or CoercivityLow.
 func (m *MSR) SetCoercivity(hico bool) error {
-	var command string
+	c := CoercivityLow
 	if hico {
-		command = "x"
-	} else {
-		command = "y"
-	}
-
-	status, _, _, err := m.executeWaitResult(command, 10*time.Second)
-	if err != nil {
-		return err
+		c = CoercivityHigh
 	}
-	if status != '0' {
-		return fmt.Errorf("set_coercivity error: %c", status)
-	}
-	return nil
+	return m.Configure(Settings{Coercivity: c})
 }
 
 // SetBPC sets bits per character for each track
+//
+// Deprecated: use Configure, which validates the BPC.
 func (m *MSR) SetBPC(bpc1, bpc2, bpc3 int) error {
-	status, _, _, err := m.executeWaitResult("o"+string(byte(bpc1))+string(byte(bpc2))+string(byte(bpc3)), 10*time.Second)
-	if err != nil {
-		return err
-	}
-	if status != '0' {
-		return fmt.Errorf("set_bpc error: %c", status)
+	var s Settings
+	for i, bpc := range []int{bpc1, bpc2, bpc3} {
+		if bpc == 0 {
+			return fmt.Errorf("%w: track %d BPC 0 is not between 5 and 8", ErrInvalidSetting, i+1)
+		}
+		s.Tracks[i].BPC = BPC(bpc)
 	}
-	return nil
+	return m.Configure(s)
 }
 
 // SetBPI sets bits per inch for tracks
+//
+// Deprecated: use Configure with BPI210 or BPI75.
 func (m *MSR) SetBPI(bpi1, bpi2, bpi3 *bool) error {
-	var modes []string
-
-	if bpi1 != nil {
-		if *bpi1 {
-			modes = append(modes, "\xA1") // 210bpi
-		} else {
-			modes = append(modes, "\xA0") // 75bpi
+	var s Settings
+	for i, hi := range []*bool{bpi1, bpi2, bpi3} {
+		switch {
+		case hi == nil:
+		case *hi:
+			s.Tracks[i].BPI = BPI210
+		default:
+			s.Tracks[i].BPI = BPI75
 		}
 	}
+	return m.Configure(s)
+}
 
-	if bpi2 != nil {
-		if *bpi2 {
-			modes = append(modes, "\xD2")
-		} else {
-			modes = append(modes, "\x4B")
-		}
-	}
+// decodeRawDataBlock splits a raw datablock into its length prefixed tracks,
+// redacting errors with p
+func decodeRawDataBlock(data string, p Redact) (string, string, string, error) {
+	return decodeDataBlock(data, rawDataBlockStrips, p)
+}
 
-	if bpi3 != nil {
-		if *bpi3 {
-			modes = append(modes, "\xC1")
-		} else {
-			modes = append(modes, "\xC0")
-		}
+// rawDataBlockStrips locates the length prefixed tracks of a raw datablock
+func rawDataBlockStrips(data []byte, p Redact) ([3]Span, error) {
+	var strips [3]Span
+	if len(data) < 4 || string(data[:2]) != EscapeCode+"s" {
+		return strips, fmt.Errorf("bad raw datablock: doesn't start with <ESC>s: %s", p.block(string(data), true))
 	}
 
-	for _, mode := range modes {
-		status, _, _, err := m.executeWaitResult("b"+mode, 10*time.Second)
-		if err != nil {
-			return err
+	pos := 2
+	for i := 0; i < 3; i++ {
+		if pos+3 > len(data) || data[pos] != EscapeCode[0] || data[pos+1] != byte(i+1) {
+			return strips, fmt.Errorf("bad raw datablock: missing <ESC>[%02d] at position %d", i+1, pos)
 		}
-		if status != '0' {
-			return fmt.Errorf("set_bpi error: %c for %x", status, mode)
+		length := int(data[pos+2])
+		start := pos + 3
+		if start+length > len(data) {
+			return strips, fmt.Errorf("bad raw datablock: strip %d length %d exceeds block", i+1, length)
 		}
+		strips[i] = Span{Start: start, End: start + length}
+		pos = start + length
 	}
-	return nil
+
+	if string(data[pos:]) != "?"+EndCode {
+		return strips, fmt.Errorf("bad raw datablock: doesn't end with ?<FS>: %s", p.block(string(data[pos:]), true))
+	}
+	return strips, nil
 }
 
-// ReadRawTracks reads magnetic tracks in raw format (simplified version)
+// encodeRawDataBlock encodes raw tracks with their lengths
+func encodeRawDataBlock(strip1, strip2, strip3 string) string {
+	return "\x1bs\x1b\x01" + string(byte(len(strip1))) + strip1 +
+		"\x1b\x02" + string(byte(len(strip2))) + strip2 +
+		"\x1b\x03" + string(byte(len(strip3))) + strip3 + "?\x1C"
+}
+
+// ReadRawTracks reads magnetic tracks in raw format. Each track is returned as
+// the bytes delivered by the device, holding the bit stream in the bits per
+// character set with SetBPC (see BitsFromRaw).
 func (m *MSR) ReadRawTracks() (string, string, string, error) {
-	status, _, data, err := m.executeWaitResult("m", 10*time.Second)
+	if err := m.profile.require("raw read", m.profile.Raw); err != nil {
+		return "", "", "", err
+	}
+
+	status, _, data, err := m.executeWaitResult(m.profile.Commands.ReadRaw, m.commandTimeout)
 	if err != nil {
 		return "", "", "", err
 	}
-	if status != '0' {
-		return "", "", "", fmt.Errorf("read error: %c", status)
+	if err := m.profile.checkStatus("read", status); err != nil {
+		return "", "", "", err
 	}
 
-	// Simplified raw data parsing - just return the raw binary data
-	// For full raw parsing with bit manipulation, additional functions would be needed
-	return data, "", "", nil
+	return decodeDataBlock(data, m.profile.Framing.RawStrips, m.redact)
 }
 
-// WriteRawTracks writes magnetic tracks in raw format (simplified version)
+// WriteRawTracks writes magnetic tracks in raw format
 func (m *MSR) WriteRawTracks(t1, t2, t3 string) error {
-	// Simplified raw writing - would need full bit manipulation for complete implementation
-	data := "\x1bs\x1b\x01" + string(byte(len(t1))) + t1 +
-		"\x1b\x02" + string(byte(len(t2))) + t2 +
-		"\x1b\x03" + string(byte(len(t3))) + t3 + "?\x1C"
-
-	status, _, _, err := m.executeWaitResult("n"+data, 10*time.Second)
-	if err != nil {
+	if err := m.profile.require("raw write", m.profile.Raw && !m.profile.ReadOnly); err != nil {
 		return err
 	}
-	if status != '0' {
-		return fmt.Errorf("write error: %c", status)
-	}
-	return nil
+
+	return m.writeSelected(m.profile.Commands.WriteRaw, encodeRawDataBlock(t1, t2, t3), AllTracks)
 }
 
-// PackRaw packs data into raw format (simplified placeholder)
+// PackRaw packs data into raw format: each character of data is encoded as its
+// index in mapping on bcountCode bits plus an odd parity bit, followed by the
+// LRC, and the bits are packed into bytes of bcountOutput bits. An empty string
+// is returned if data holds characters outside mapping; use Charset.Pack to get
+// the error.
 func PackRaw(data, mapping string, bcountCode, bcountOutput int) string {
-	// Simplified version - for full implementation, complex bit manipulation is needed
-	return data
+	packed, err := mappingCharset(mapping, bcountCode).Pack(data, bcountOutput, 0)
+	if err != nil {
+		return ""
+	}
+	return packed
 }
 
-// UnpackRaw unpacks raw data (simplified placeholder)
+// UnpackRaw unpacks raw data packed in bytes of bcountOutput bits into
+// characters of bcountCode bits plus odd parity, looked up in mapping
 func UnpackRaw(rawData, mapping string, bcountCode, bcountOutput int) RawData {
-	// Simplified version - for full implementation, complex bit manipulation is needed
-	return RawData{
-		Data:         rawData,
-		TotalLength:  len(rawData),
-		ParityErrors: strings.Repeat(" ", len(rawData)),
-		LRCError:     false,
+	d, err := mappingCharset(mapping, bcountCode).Unpack(rawData, bcountOutput)
+	if err != nil {
+		return RawData{}
 	}
+	return d.RawData()
+}
+
+// mappingCharset describes the encoding used by PackRaw and UnpackRaw
+func mappingCharset(mapping string, bits int) *Charset {
+	return &Charset{Name: "mapping", Symbols: mapping, Bits: bits, Parity: OddParity, LRC: LRCXor}
 }
@@ -16,7 +16,7 @@ func TestEncodeDecodeISODataBlock(t *testing.T) {
 	}
 
 	// Test decoding
-	decoded1, decoded2, decoded3, err := decodeISODataBlock(encoded)
+	decoded1, decoded2, decoded3, err := decodeISODataBlock(encoded, RedactPCI)
 	if err != nil {
 		t.Fatalf("Failed to decode ISO data block: %v", err)
 	}
@@ -32,6 +32,18 @@ func TestEncodeDecodeISODataBlock(t *testing.T) {
 	}
 }
 
+func TestDecodeISODataBlockEmptyStrips(t *testing.T) {
+	encoded := encodeISODataBlock("", "TRACK2DATA", "")
+
+	decoded1, decoded2, decoded3, err := decodeISODataBlock(encoded, RedactPCI)
+	if err != nil {
+		t.Fatalf("Failed to decode ISO data block: %v", err)
+	}
+	if decoded1 != "" || decoded2 != "TRACK2DATA" || decoded3 != "" {
+		t.Errorf("Unexpected strips: %q, %q, %q", decoded1, decoded2, decoded3)
+	}
+}
+
 func TestDecodeISODataBlockErrors(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -57,7 +69,7 @@ func TestDecodeISODataBlockErrors(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, _, _, err := decodeISODataBlock(tt.data)
+			_, _, _, err := decodeISODataBlock(tt.data, RedactPCI)
 			if tt.expectError && err == nil {
 				t.Errorf("Expected error for %s, but got none", tt.name)
 			}
@@ -68,6 +80,45 @@ func TestDecodeISODataBlockErrors(t *testing.T) {
 	}
 }
 
+func TestEncodeDecodeRawDataBlock(t *testing.T) {
+	encoded := encodeRawDataBlock("\x1b\x00\xff", "", "?\x1c")
+
+	strip1, strip2, strip3, err := decodeRawDataBlock(encoded, RedactPCI)
+	if err != nil {
+		t.Fatalf("Failed to decode raw data block: %v", err)
+	}
+	if strip1 != "\x1b\x00\xff" || strip2 != "" || strip3 != "?\x1c" {
+		t.Errorf("Unexpected strips: %q, %q, %q", strip1, strip2, strip3)
+	}
+
+	for _, bad := range []string{"", "\x1bs\x1b\x01\x05ab", encoded[:len(encoded)-1]} {
+		if _, _, _, err := decodeRawDataBlock(bad, RedactPCI); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestResponseComplete(t *testing.T) {
+	block := encodeISODataBlock("A", "1", "")
+	tests := map[string]bool{
+		"":                   false,
+		"\x1b":               false,
+		"\x1b0":              true,
+		block[:6]:            false,
+		block:                false,
+		block + "\x1b":       false,
+		block + "\x1b0":      true,
+		"\x1bs\x1b\x01\x1b0": false,
+		"\x1bREV":            false,
+		"\x1bREVS1.00":       true,
+	}
+	for response, want := range tests {
+		if got := responseComplete([]byte(response)); got != want {
+			t.Errorf("responseComplete(%q) = %v, expected %v", response, got, want)
+		}
+	}
+}
+
 func TestTrackMappings(t *testing.T) {
 	// Test Track1Map contains expected characters
 	if len(Track1Map) == 0 {
@@ -190,6 +241,6 @@ func BenchmarkDecodeISODataBlock(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		decodeISODataBlock(encoded)
+		decodeISODataBlock(encoded, RedactPCI)
 	}
 }
@@ -0,0 +1,352 @@
+package magstripe
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives counters and histograms measuring the commands run by an
+// MSR. Implementations must be safe for concurrent use when shared by several
+// MSRs.
+type Metrics interface {
+	// Add adds delta to the counter name with the given labels
+	Add(name string, delta float64, labels ...Label)
+	// Observe records value in the histogram name with the given labels
+	Observe(name string, value float64, labels ...Label)
+}
+
+// Label is a dimension of a metric, such as the command measured
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Metrics reported by an MSR
+const (
+	// MetricCommands counts the commands answered or timed out, by command and
+	// status: the status byte of the response, "timeout" or "framing"
+	MetricCommands = "msr_commands_total"
+	// MetricCommandDuration is the time from sending a command to its
+	// response by command, including the wait for a swipe
+	MetricCommandDuration = "msr_command_duration_seconds"
+	// MetricRetries counts the retries made under the retry policy, by
+	// command and the status of the attempt that failed
+	MetricRetries = "msr_retries_total"
+	// MetricTrackReads counts the tracks of the reads answered, by track and
+	// result: "present", "empty" or "read error"
+	MetricTrackReads = "msr_track_reads_total"
+	// MetricTrackWrites counts the tracks written, by track, the coercivity
+	// of the card stock ("hico", "loco" or "unknown") and final status
+	MetricTrackWrites = "msr_track_writes_total"
+)
+
+// metricHelp describes the metrics of an MSR for the Prometheus exposition
+var metricHelp = map[string]string{
+	MetricCommands:        "Commands sent to the magnetic stripe device by command and response status.",
+	MetricCommandDuration: "Time from sending a command to its response, including card swipes.",
+	MetricRetries:         "Commands retried by command and status of the failed attempt.",
+	MetricTrackReads:      "Tracks read by track and result.",
+	MetricTrackWrites:     "Tracks written by track, coercivity and response status.",
+}
+
+// WithMetrics reports the commands run by the MSR to metrics
+func WithMetrics(metrics Metrics) Option {
+	return func(c *config) {
+		c.metrics = metrics
+	}
+}
+
+// commandLabel names a command letter for metrics
+func (m *MSR) commandLabel(letter string) string {
+	c := m.profile.Commands
+	switch letter {
+	case c.ReadISO:
+		return "read"
+	case c.WriteISO:
+		return "write"
+	case c.ReadRaw:
+		return "read_raw"
+	case c.WriteRaw:
+		return "write_raw"
+	case c.Erase:
+		return "erase"
+	case c.HiCo:
+		return "set_hico"
+	case c.LoCo:
+		return "set_loco"
+	case c.SetBPI:
+		return "set_bpi"
+	case c.SetBPC:
+		return "set_bpc"
+	case c.GetCoercivity:
+		return "get_coercivity"
+	case c.Version:
+		return "firmware"
+	case c.Reset:
+		return "reset"
+	}
+	return "other"
+}
+
+// statusLabel names the outcome of a command for metrics
+func statusLabel(status byte, err error) string {
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrFraming):
+		return "framing"
+	case err != nil:
+		return "error"
+	}
+	return string(rune(status))
+}
+
+// measure reports the command letter sent at start that ended with status,
+// datablock data or err to the metrics, if any
+func (m *MSR) measure(letter string, start time.Time, status byte, data []byte, err error) {
+	if m.metrics == nil {
+		return
+	}
+	cmd := Label{"command", m.commandLabel(letter)}
+	statusLbl := Label{"status", statusLabel(status, err)}
+	m.metrics.Add(MetricCommands, 1, cmd, statusLbl)
+	m.metrics.Observe(MetricCommandDuration, time.Since(start).Seconds(), cmd)
+	if err != nil {
+		return
+	}
+
+	c := m.profile.Commands
+	switch letter {
+	case c.ReadISO, c.ReadRaw:
+		strips := m.profile.Framing.ISOStrips
+		if letter == c.ReadRaw {
+			strips = m.profile.Framing.RawStrips
+		}
+		spans, err := strips(data, m.redact)
+		if err != nil && status == m.profile.StatusOK {
+			return
+		}
+		// a failed read without a datablock failed on every track
+		for i, s := range spans {
+			result := TrackEmpty
+			if s.End > s.Start {
+				result = TrackPresent
+			} else if status != m.profile.StatusOK {
+				result = TrackError
+			}
+			m.metrics.Add(MetricTrackReads, 1, Label{"track", strconv.Itoa(i + 1)}, Label{"result", result.String()})
+		}
+	}
+}
+
+// measureWrites reports the tracks of sel written by a command that ended
+// with status or err to the metrics, if any
+func (m *MSR) measureWrites(sel Tracks, status byte, err error) {
+	if m.metrics == nil {
+		return
+	}
+	coercivity := m.settings.Coercivity.String()
+	if m.settings.Coercivity == CoercivityUnset {
+		coercivity = "unknown"
+	}
+	for n := 1; n <= 3; n++ {
+		if sel.Has(n) {
+			m.metrics.Add(MetricTrackWrites, 1, Label{"track", strconv.Itoa(n)},
+				Label{"coercivity", coercivity}, Label{"status", statusLabel(status, err)})
+		}
+	}
+}
+
+// DefaultBuckets are the histogram bucket upper bounds in seconds used by
+// PrometheusMetrics, suited to commands that wait for a card to be swiped
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// PrometheusMetrics collects metrics in memory and exposes them in the
+// Prometheus text format. It is an http.Handler serving that format.
+type PrometheusMetrics struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	families map[string]*promFamily
+}
+
+// promFamily holds the series of one metric name
+type promFamily struct {
+	histogram bool
+	series    map[string]*promSeries
+}
+
+// promSeries is one combination of label values of a metric
+type promSeries struct {
+	labels string
+	value  float64
+	// counts holds the observations falling in each bucket, the last one for
+	// values above every bound
+	counts []uint64
+	count  uint64
+}
+
+// NewPrometheusMetrics creates an empty collection whose histograms use
+// buckets, sorted upper bounds in seconds (DefaultBuckets if nil)
+func NewPrometheusMetrics(buckets []float64) *PrometheusMetrics {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &PrometheusMetrics{
+		buckets:  buckets,
+		families: make(map[string]*promFamily),
+	}
+}
+
+// Add implements Metrics
+func (p *PrometheusMetrics) Add(name string, delta float64, labels ...Label) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s := p.series(name, false, labels); s != nil {
+		s.value += delta
+	}
+}
+
+// Observe implements Metrics
+func (p *PrometheusMetrics) Observe(name string, value float64, labels ...Label) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.series(name, true, labels)
+	if s == nil {
+		return
+	}
+	i := sort.SearchFloat64s(p.buckets, value)
+	s.counts[i]++
+	s.count++
+	s.value += value
+}
+
+// series returns the series of name with labels, creating it as needed, or nil
+// when name is already used by a metric of the other kind
+func (p *PrometheusMetrics) series(name string, histogram bool, labels []Label) *promSeries {
+	f := p.families[name]
+	if f == nil {
+		f = &promFamily{histogram: histogram, series: make(map[string]*promSeries)}
+		p.families[name] = f
+	}
+	if f.histogram != histogram {
+		return nil
+	}
+
+	key := formatLabels(labels)
+	s := f.series[key]
+	if s == nil {
+		s = &promSeries{labels: key}
+		if histogram {
+			s.counts = make([]uint64, len(p.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// formatLabels formats labels sorted by name as {name="value",...}
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sorted := append([]Label(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range sorted {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// withLabel adds a label to a formatted label set
+func withLabel(labels, name, value string) string {
+	l := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + l + "}"
+	}
+	return labels[:len(labels)-1] + "," + l + "}"
+}
+
+// formatFloat formats a sample value
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteText writes the metrics to w in the Prometheus text exposition format,
+// sorted by name and labels
+func (p *PrometheusMetrics) WriteText(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := p.families[name]
+		if help, ok := metricHelp[name]; ok {
+			bw.WriteString("# HELP " + name + " " + help + "\n")
+		}
+		kind := "counter"
+		if f.histogram {
+			kind = "histogram"
+		}
+		bw.WriteString("# TYPE " + name + " " + kind + "\n")
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if !f.histogram {
+				bw.WriteString(name + s.labels + " " + formatFloat(s.value) + "\n")
+				continue
+			}
+			var cumulative uint64
+			for i, n := range s.counts {
+				cumulative += n
+				le := math.Inf(1)
+				if i < len(p.buckets) {
+					le = p.buckets[i]
+				}
+				bw.WriteString(name + "_bucket" + withLabel(s.labels, "le", formatFloat(le)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+			}
+			bw.WriteString(name + "_sum" + s.labels + " " + formatFloat(s.value) + "\n")
+			bw.WriteString(name + "_count" + s.labels + " " + strconv.FormatUint(s.count, 10) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteText(w)
+}
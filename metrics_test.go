@@ -0,0 +1,102 @@
+package magstripe
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics(nil)
+	sim := NewSimulator()
+	sim.SetCard(TrackData{Track1: testBankTrack1, Track2: testBankTrack2})
+	m, err := NewMSRPort(sim, WithoutInitialReset(), WithCommandTimeout(200*time.Millisecond),
+		WithMetrics(metrics), WithRetry(RetryPolicy{Attempts: 2}))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+
+	if _, err := m.ReadTracks(); err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if err := m.SetCoercivity(HiCo); err != nil {
+		t.Fatalf("SetCoercivity: %v", err)
+	}
+	sim.FailNext('1')
+	if err := m.WriteSelectedTracks(Track2, "", ";123=45?", ""); err != nil {
+		t.Fatalf("WriteSelectedTracks: %v", err)
+	}
+	// the failing track is retried and fails again
+	sim.FailTracks(Track3)
+	if _, err := m.ReadSelectedTracks(AllTracks); err != nil {
+		t.Fatalf("ReadSelectedTracks: %v", err)
+	}
+	sim.RemoveCard()
+	m.ReadTracks()
+	// a write that times out is counted as failed on each selected track
+	m.WriteSelectedTracks(Track1|Track3, "%A?", "", ";1?")
+
+	var buf bytes.Buffer
+	if err := metrics.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE msr_commands_total counter\n",
+		`msr_commands_total{command="read",status="0"} 1` + "\n",
+		`msr_commands_total{command="read",status="1"} 2` + "\n",
+		`msr_commands_total{command="read",status="timeout"} 1` + "\n",
+		`msr_commands_total{command="write",status="1"} 1` + "\n",
+		`msr_commands_total{command="write",status="0"} 1` + "\n",
+		`msr_commands_total{command="set_hico",status="0"} 1` + "\n",
+		`msr_retries_total{command="write",status="1"} 1` + "\n",
+		`msr_retries_total{command="read",status="1"} 1` + "\n",
+		`msr_track_reads_total{result="present",track="1"} 3` + "\n",
+		`msr_track_reads_total{result="empty",track="3"} 1` + "\n",
+		`msr_track_reads_total{result="read error",track="3"} 2` + "\n",
+		`msr_track_writes_total{coercivity="hico",status="0",track="2"} 1` + "\n",
+		`msr_track_writes_total{coercivity="hico",status="timeout",track="1"} 1` + "\n",
+		`msr_track_writes_total{coercivity="hico",status="timeout",track="3"} 1` + "\n",
+		"# TYPE msr_command_duration_seconds histogram\n",
+		`msr_command_duration_seconds_bucket{command="read",le="+Inf"} 4` + "\n",
+		`msr_command_duration_seconds_count{command="read"} 4` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `msr_track_writes_total{coercivity="hico",status="0",track="1"}`) {
+		t.Errorf("unselected track counted as written:\n%s", out)
+	}
+}
+
+func TestPrometheusText(t *testing.T) {
+	p := NewPrometheusMetrics([]float64{0.5, 1})
+	p.Add("jobs_total", 2, Label{"queue", `a"b`})
+	p.Add("jobs_total", 1, Label{"queue", `a"b`})
+	p.Observe("wait_seconds", 0.5)
+	p.Observe("wait_seconds", 0.75)
+	p.Observe("wait_seconds", 3)
+	// a counter name is not reused for a histogram
+	p.Observe("jobs_total", 1, Label{"queue", `a"b`})
+
+	want := `# TYPE jobs_total counter
+jobs_total{queue="a\"b"} 3
+# TYPE wait_seconds histogram
+wait_seconds_bucket{le="0.5"} 1
+wait_seconds_bucket{le="1"} 2
+wait_seconds_bucket{le="+Inf"} 3
+wait_seconds_sum 4.25
+wait_seconds_count 3
+`
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Body.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+}
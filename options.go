@@ -0,0 +1,116 @@
+package magstripe
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Default connection settings
+const (
+	DefaultBaudRate       = 9600
+	DefaultReadTimeout    = 100 * time.Millisecond
+	DefaultCommandTimeout = 10 * time.Second
+)
+
+// Option configures an MSR created by NewMSR
+type Option func(*config)
+
+// config holds the settings collected from Options
+type config struct {
+	baudRate       int
+	readTimeout    time.Duration
+	commandTimeout time.Duration
+	initialReset   bool
+	logger         *slog.Logger
+	profile        *Profile
+	tracer         Tracer
+	redact         Redact
+	recording      io.Writer
+	retry          RetryPolicy
+	metrics        Metrics
+	reconnect      *ReconnectPolicy
+}
+
+// defaultConfig returns the settings used when no Options are given
+func defaultConfig() config {
+	return config{
+		baudRate:       DefaultBaudRate,
+		readTimeout:    DefaultReadTimeout,
+		commandTimeout: DefaultCommandTimeout,
+		initialReset:   true,
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		profile:        profiles["msr605"].clone(),
+	}
+}
+
+// newConfig applies opts to the defaults and validates the result
+func newConfig(opts []Option) (config, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.baudRate <= 0 {
+		return cfg, fmt.Errorf("invalid baud rate: %d", cfg.baudRate)
+	}
+	if cfg.readTimeout <= 0 || cfg.commandTimeout <= 0 {
+		return cfg, errors.New("timeouts must be positive")
+	}
+	return cfg, nil
+}
+
+// WithBaudRate sets the serial line speed (default 9600)
+func WithBaudRate(baud int) Option {
+	return func(c *config) {
+		c.baudRate = baud
+	}
+}
+
+// WithReadTimeout sets the timeout of a single read from the port (default 100ms)
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.readTimeout = d
+	}
+}
+
+// WithCommandTimeout sets how long to wait for the device to answer a command,
+// including the time spent waiting for a card swipe (default 10s)
+func WithCommandTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.commandTimeout = d
+	}
+}
+
+// WithoutInitialReset skips the reset command normally sent when the port is opened
+func WithoutInitialReset() Option {
+	return func(c *config) {
+		c.initialReset = false
+	}
+}
+
+// WithLogger sets the logger used for connection diagnostics (default discards)
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithProfile selects the device model (default ProfileMSR605). The MSR
+// keeps its own copy of p.
+func WithProfile(p Profile) Option {
+	return func(c *config) {
+		c.profile = p.clone()
+	}
+}
+
+// WithRecording records the full byte exchange with the device to w as a
+// session that a Replayer can play back
+func WithRecording(w io.Writer) Option {
+	return func(c *config) {
+		c.recording = w
+	}
+}
@@ -0,0 +1,72 @@
+package magstripe
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := defaultConfig()
+
+	if cfg.baudRate != DefaultBaudRate {
+		t.Errorf("baud rate: expected %d, got %d", DefaultBaudRate, cfg.baudRate)
+	}
+	if cfg.readTimeout != DefaultReadTimeout {
+		t.Errorf("read timeout: expected %v, got %v", DefaultReadTimeout, cfg.readTimeout)
+	}
+	if cfg.commandTimeout != DefaultCommandTimeout {
+		t.Errorf("command timeout: expected %v, got %v", DefaultCommandTimeout, cfg.commandTimeout)
+	}
+	if !cfg.initialReset {
+		t.Error("initial reset should be enabled by default")
+	}
+	if cfg.logger == nil {
+		t.Error("default logger should not be nil")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	logger := slog.Default()
+	cfg := defaultConfig()
+	for _, opt := range []Option{
+		WithBaudRate(38400),
+		WithReadTimeout(50 * time.Millisecond),
+		WithCommandTimeout(3 * time.Second),
+		WithoutInitialReset(),
+		WithLogger(logger),
+	} {
+		opt(&cfg)
+	}
+
+	if cfg.baudRate != 38400 {
+		t.Errorf("baud rate: expected 38400, got %d", cfg.baudRate)
+	}
+	if cfg.readTimeout != 50*time.Millisecond {
+		t.Errorf("read timeout: expected 50ms, got %v", cfg.readTimeout)
+	}
+	if cfg.commandTimeout != 3*time.Second {
+		t.Errorf("command timeout: expected 3s, got %v", cfg.commandTimeout)
+	}
+	if cfg.initialReset {
+		t.Error("initial reset should be disabled")
+	}
+	if cfg.logger != logger {
+		t.Error("logger not set")
+	}
+
+	// A nil logger keeps the default
+	WithLogger(nil)(&cfg)
+	if cfg.logger != logger {
+		t.Error("nil logger should be ignored")
+	}
+}
+
+func TestNewMSRInvalidOptions(t *testing.T) {
+	if _, err := NewMSR("/dev/null", WithBaudRate(0)); err == nil {
+		t.Error("Expected error for zero baud rate")
+	}
+	if _, err := NewMSR("/dev/null", WithCommandTimeout(0)); err == nil {
+		t.Error("Expected error for zero command timeout")
+	}
+}
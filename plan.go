@@ -0,0 +1,156 @@
+package magstripe
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrTrackOverflow is returned for track data longer than a card at the
+// density and character width it is written with
+var ErrTrackOverflow = errors.New("track data does not fit on the card")
+
+// CardLength is the length in millimetres of an ID-1 card, along which the
+// tracks run
+const CardLength = 85.6
+
+// DefaultMargin is the length in millimetres of the zeros written before and
+// after the data, from the 7.44 mm at which ISO/IEC 7811 starts the data
+const DefaultMargin = 7.44
+
+// isoBPI is the recording density of each track in ISO/IEC 7811
+var isoBPI = [3]BPI{BPI210, BPI75, BPI210}
+
+// PlanOptions sets how a track is encoded. Zero values select the defaults.
+type PlanOptions struct {
+	// Charset encodes the data, the ISO charset of the track by default
+	Charset *Charset
+	// BPI is the recording density, the ISO one of the track by default
+	BPI BPI
+	// BPC is the width each character is written in, the charset's own by
+	// default
+	BPC BPC
+	// LeadingZeros and TrailingZeros are the clocking bits written before and
+	// after the data, DefaultMargin at the density by default
+	LeadingZeros  int
+	TrailingZeros int
+}
+
+// TrackPlan is the layout of data on a track
+type TrackPlan struct {
+	Number  int
+	Charset string
+	BPI     BPI
+	BPC     BPC
+	// Chars is the number of characters written, LRC included
+	Chars         int
+	LeadingZeros  int
+	TrailingZeros int
+	// Bits is the number of bits written, zeros included
+	Bits int
+	// Length is the length of track the bits take, in millimetres
+	Length float64
+	// Capacity is the number of characters of data, sentinels included, that
+	// fit on the card
+	Capacity int
+}
+
+// Fits reports whether the track fits on the card
+func (p *TrackPlan) Fits() bool {
+	return p.Length <= CardLength
+}
+
+func (p *TrackPlan) String() string {
+	s := fmt.Sprintf("track %d: %d %s characters at %d BPI and %d BPC, %d bits over %.1f mm of %.1f mm",
+		p.Number, p.Chars, p.Charset, p.BPI, p.BPC, p.Bits, p.Length, CardLength)
+	if !p.Fits() {
+		s += fmt.Sprintf(", overflows (%d characters fit)", p.Capacity)
+	}
+	return s
+}
+
+// PlanTrack works out how data is laid out on track n (1 to 3) with opts: the
+// bits it takes with its LRC and clocking zeros, and the length of track
+// they cover. Empty data takes no bits. Data longer than a card is reported
+// along with the plan as ErrTrackOverflow.
+func PlanTrack(n int, data string, opts PlanOptions) (*TrackPlan, error) {
+	if n < 1 || n > 3 {
+		return nil, fmt.Errorf("invalid track number %d", n)
+	}
+	cs := opts.Charset
+	if cs == nil {
+		cs = trackCharsets[n-1]
+	}
+	p := &TrackPlan{Number: n, Charset: cs.Name, BPI: opts.BPI, BPC: opts.BPC}
+	if p.BPI == 0 {
+		p.BPI = isoBPI[n-1]
+	}
+	if p.BPC == 0 {
+		p.BPC = BPC(cs.CharBits())
+	}
+	var s Settings
+	s.Tracks[n-1] = TrackSettings{BPI: p.BPI, BPC: p.BPC}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if int(p.BPC) < cs.CharBits() {
+		return nil, fmt.Errorf("%w: track %d BPC %d is narrower than the %d bits of charset %s",
+			ErrInvalidSetting, n, p.BPC, cs.CharBits(), cs.Name)
+	}
+
+	margin := int(math.Round(DefaultMargin / 25.4 * float64(p.BPI)))
+	leading, trailing := opts.LeadingZeros, opts.TrailingZeros
+	if leading == 0 {
+		leading = margin
+	}
+	if trailing == 0 {
+		trailing = margin
+	}
+	usable := int(CardLength/25.4*float64(p.BPI)) - leading - trailing
+	p.Capacity = max(usable/int(p.BPC), 0)
+	if cs.LRC == LRCXor {
+		p.Capacity = max(p.Capacity-1, 0)
+	}
+	// an empty track is written as nothing, not as zeros and an LRC
+	if data == "" {
+		return p, nil
+	}
+
+	bits, err := cs.Encode(data)
+	if err != nil {
+		return nil, fmt.Errorf("track %d: %w", n, err)
+	}
+	p.LeadingZeros, p.TrailingZeros = leading, trailing
+	p.Chars = len(bits) / cs.CharBits()
+	p.Bits = p.LeadingZeros + p.Chars*int(p.BPC) + p.TrailingZeros
+	p.Length = float64(p.Bits) / float64(p.BPI) * 25.4
+	if !p.Fits() {
+		return p, fmt.Errorf("%w: track %d needs %.1f mm, %d characters fit in %.1f mm",
+			ErrTrackOverflow, n, p.Length, p.Capacity, CardLength)
+	}
+	return p, nil
+}
+
+// EncodingPlan holds the plans of tracks 1 to 3, nil for those not written
+type EncodingPlan [3]*TrackPlan
+
+// PlanTracks plans the selected tracks as WriteSelectedTracks would write
+// them, in their ISO charsets at the BPI and BPC set through the MSR or else
+// the ISO ones. It returns the first error, an overflow naming its track,
+// along with the plans so far.
+func (m *MSR) PlanTracks(sel Tracks, t1, t2, t3 string) (*EncodingPlan, error) {
+	var plan EncodingPlan
+	var first error
+	for i, data := range []string{t1, t2, t3} {
+		if !sel.Has(i + 1) {
+			continue
+		}
+		t := m.settings.Tracks[i]
+		p, err := PlanTrack(i+1, data, PlanOptions{BPI: t.BPI, BPC: t.BPC})
+		plan[i] = p
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return &plan, first
+}
@@ -0,0 +1,83 @@
+package magstripe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPlanTrack(t *testing.T) {
+	iso2 := func(digits int) string { return ";" + strings.Repeat("1", digits-2) + "?" }
+	tests := []struct {
+		name     string
+		n        int
+		data     string
+		opts     PlanOptions
+		capacity int
+		wantErr  error
+	}{
+		{"track 1", 1, testBankTrack1, PlanOptions{}, 82, nil},
+		{"track 2 full", 2, iso2(40), PlanOptions{}, 40, nil},
+		{"track 2 overflow", 2, iso2(41), PlanOptions{}, 40, ErrTrackOverflow},
+		{"track 3", 3, testISO4909Track3, PlanOptions{}, 115, nil},
+		{"wider characters", 3, testISO4909Track3, PlanOptions{BPC: 8}, 71, nil},
+		{"wider characters overflow", 3, testISO4909Track3 + testISO4909Track3, PlanOptions{BPC: 8}, 71, ErrTrackOverflow},
+		{"higher density", 2, iso2(41), PlanOptions{BPI: BPI210}, 115, nil},
+		{"no margins to speak of", 2, iso2(41), PlanOptions{LeadingZeros: 1, TrailingZeros: 1}, 49, nil},
+		{"BPC too narrow", 1, testBankTrack1, PlanOptions{BPC: 5}, 0, ErrInvalidSetting},
+		{"invalid BPI", 2, iso2(10), PlanOptions{BPI: 100}, 0, ErrInvalidSetting},
+		{"empty", 2, "", PlanOptions{}, 40, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := PlanTrack(tt.n, tt.data, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PlanTrack error = %v, expected %v", err, tt.wantErr)
+			}
+			if p == nil {
+				return
+			}
+			if p.Capacity != tt.capacity {
+				t.Errorf("capacity %d, expected %d", p.Capacity, tt.capacity)
+			}
+			if p.Fits() != (err == nil) {
+				t.Errorf("Fits() = %v with error %v", p.Fits(), err)
+			}
+		})
+	}
+
+	p, _ := PlanTrack(2, iso2(40), PlanOptions{})
+	if p.Chars != 41 || p.Bits != 22+41*5+22 {
+		t.Errorf("plan %+v", p)
+	}
+	if s := p.String(); s != "track 2: 41 aba characters at 75 BPI and 5 BPC, 249 bits over 84.3 mm of 85.6 mm" {
+		t.Errorf("String() = %q", s)
+	}
+	if p, _ := PlanTrack(1, "", PlanOptions{}); p.Chars != 0 || p.Bits != 0 || p.Length != 0 {
+		t.Errorf("empty track should take no bits: %+v", p)
+	}
+	if _, err := PlanTrack(2, "%A?", PlanOptions{}); err == nil {
+		t.Error("expected an error for characters outside the charset")
+	}
+}
+
+func TestPlanTracks(t *testing.T) {
+	m, _ := newSimulatedMSR(t)
+	long := ";" + strings.Repeat("1", 50) + "?"
+
+	plan, err := m.PlanTracks(Track1|Track2, testBankTrack1, long, long)
+	if !errors.Is(err, ErrTrackOverflow) || !strings.Contains(err.Error(), "track 2") {
+		t.Fatalf("PlanTracks error %v", err)
+	}
+	if plan[0] == nil || !plan[0].Fits() || plan[1] == nil || plan[1].Fits() || plan[2] != nil {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+
+	// the density set through the MSR is planned for
+	if err := m.Configure(Settings{Tracks: [3]TrackSettings{{}, {BPI: BPI210}}}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if plan, err := m.PlanTracks(Track2, "", long, ""); err != nil || plan[1].BPI != BPI210 {
+		t.Errorf("PlanTracks at 210 BPI = %+v, %v", plan[1], err)
+	}
+}
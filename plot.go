@@ -0,0 +1,399 @@
+package magstripe
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// BitClass classifies one bit of a plotted track
+type BitClass int
+
+const (
+	// BitIdle is a zero outside the decoded characters: leading zeros or padding
+	BitIdle BitClass = iota
+	// BitData is a data bit of a character
+	BitData
+	// BitParity is a parity bit that matches its character
+	BitParity
+	// BitParityError is a parity bit that does not match its character
+	BitParityError
+	// BitSentinel is a bit of a start or end sentinel
+	BitSentinel
+	// BitLRC is a bit of a matching LRC character
+	BitLRC
+	// BitLRCError is a bit of an LRC character that does not match the data
+	BitLRCError
+	// BitNoise is a one outside the decoded characters, e.g. a partial erase
+	BitNoise
+)
+
+// bitClassGlyphs marks each class in text plots
+var bitClassGlyphs = [...]byte{
+	BitIdle:        '_',
+	BitData:        'd',
+	BitParity:      'p',
+	BitParityError: '!',
+	BitSentinel:    'S',
+	BitLRC:         'L',
+	BitLRCError:    'E',
+	BitNoise:       '?',
+}
+
+// bitClassANSI colours each class in text plots
+var bitClassANSI = [...]string{
+	BitIdle:        "\x1b[90m",
+	BitData:        "\x1b[0m",
+	BitParity:      "\x1b[32m",
+	BitParityError: "\x1b[1;31m",
+	BitSentinel:    "\x1b[36m",
+	BitLRC:         "\x1b[35m",
+	BitLRCError:    "\x1b[1;31m",
+	BitNoise:       "\x1b[33m",
+}
+
+// bitClassColors fills the background of each class in SVG and PNG plots
+var bitClassColors = [...]color.RGBA{
+	BitIdle:        {0xf0, 0xf0, 0xf0, 0xff},
+	BitData:        {0xd8, 0xe8, 0xff, 0xff},
+	BitParity:      {0xc8, 0xf0, 0xc8, 0xff},
+	BitParityError: {0xff, 0x60, 0x60, 0xff},
+	BitSentinel:    {0xa0, 0xe8, 0xf0, 0xff},
+	BitLRC:         {0xf0, 0xc8, 0xf0, 0xff},
+	BitLRCError:    {0xff, 0x60, 0x60, 0xff},
+	BitNoise:       {0xff, 0xe0, 0x80, 0xff},
+}
+
+// PlotMark labels a character on a plotted track
+type PlotMark struct {
+	// Offset is the position of the first bit of the character
+	Offset int
+	// Label is the character, "<xx>" for codes without one, or "LRC"
+	Label string
+	// Class is the class of the character's data bits
+	Class BitClass
+}
+
+// TrackPlot is a raw track bit stream annotated with its decoding, ready to be
+// rendered as a timeline
+type TrackPlot struct {
+	Track int
+	// Bits is the stream in decoding order, reversed for backwards swipes
+	Bits     Bits
+	Classes  []BitClass
+	Marks    []PlotMark
+	Decoding *BitDecoding
+}
+
+// NewTrackPlot annotates the bits of a track with a decoding of them, as
+// returned by DecodeBits or Charset.Decode. With a nil decoding only the bits
+// are plotted.
+func NewTrackPlot(track int, bits Bits, d *BitDecoding) *TrackPlot {
+	p := &TrackPlot{Track: track, Bits: bits, Decoding: d}
+	if d != nil && d.Reversed {
+		p.Bits = bits.Reverse()
+	}
+	p.Classes = make([]BitClass, len(p.Bits))
+	for i, b := range p.Bits {
+		if b == 1 {
+			p.Classes[i] = BitNoise
+		}
+	}
+	if d == nil {
+		return p
+	}
+
+	cs, ok := LookupCharset(d.Charset)
+	if !ok {
+		cs = widthCharset(d.Width, d.Parity)
+	}
+	for i, c := range d.Chars {
+		class := BitData
+		if (i == 0 && cs.Start != 0 && c.Char == cs.Start) || (i == len(d.Chars)-1 && d.EndSentinel) {
+			class = BitSentinel
+		}
+		p.mark(c, class, cs)
+	}
+	if d.LRC != nil {
+		class := BitLRC
+		if d.LRCError {
+			class = BitLRCError
+		}
+		p.mark(*d.LRC, class, cs)
+	}
+	return p
+}
+
+// mark classifies the bits of a character and labels it
+func (p *TrackPlot) mark(c DecodedChar, class BitClass, cs *Charset) {
+	for i := 0; i < cs.CharBits() && c.Offset+i < len(p.Bits); i++ {
+		switch {
+		case i < cs.Bits:
+			p.Classes[c.Offset+i] = class
+		case c.ParityError:
+			p.Classes[c.Offset+i] = BitParityError
+		case class == BitData:
+			p.Classes[c.Offset+i] = BitParity
+		default:
+			p.Classes[c.Offset+i] = class
+		}
+	}
+
+	label := string(c.Char)
+	switch {
+	case class == BitLRC || class == BitLRCError:
+		label = "LRC"
+	case c.Char == 0:
+		label = fmt.Sprintf("<%02x>", c.Code)
+	}
+	p.Marks = append(p.Marks, PlotMark{Offset: c.Offset, Label: label, Class: class})
+}
+
+// Summary describes the decoding of the track in one line
+func (p *TrackPlot) Summary() string {
+	s := fmt.Sprintf("track %d: %d bits", p.Track, len(p.Bits))
+	d := p.Decoding
+	if d == nil {
+		return s + ", not decoded"
+	}
+	name := d.Charset
+	if name == "" {
+		name = "detected"
+	}
+	s += fmt.Sprintf(", %s %d+%s bits, %d chars", name, d.Width, d.Parity, len(d.Chars))
+	if d.Reversed {
+		s += ", reversed"
+	}
+	if n := d.ParityErrors(); n > 0 {
+		s += fmt.Sprintf(", %d parity errors", n)
+	}
+	switch {
+	case d.LRCError:
+		s += ", LRC error"
+	case d.LRC == nil:
+		s += ", no LRC"
+	default:
+		s += ", LRC ok"
+	}
+	return s
+}
+
+// PlotOptions controls text plots
+type PlotOptions struct {
+	// Width is the number of bits per line; 0 means 64
+	Width int
+	// Color adds ANSI colours to the bits
+	Color bool
+}
+
+// WritePlotText renders the plots as text: for each line of bits, the bits, a
+// line of class glyphs (_ idle, d data, p parity, ! parity error, S sentinel,
+// L LRC, E LRC error, ? noise) and the characters under their first bit
+func WritePlotText(w io.Writer, plots []*TrackPlot, opts PlotOptions) error {
+	width := opts.Width
+	if width <= 0 {
+		width = 64
+	}
+
+	bw := bufio.NewWriter(w)
+	for n, p := range plots {
+		if n > 0 {
+			bw.WriteByte('\n')
+		}
+		fmt.Fprintln(bw, p.Summary())
+
+		labels := make([]byte, len(p.Bits))
+		for i := range labels {
+			labels[i] = ' '
+		}
+		for _, m := range p.Marks {
+			for i := 0; i < len(m.Label) && m.Offset+i < len(labels); i++ {
+				labels[m.Offset+i] = m.Label[i]
+			}
+		}
+
+		for start := 0; start < len(p.Bits); start += width {
+			end := start + width
+			if end > len(p.Bits) {
+				end = len(p.Bits)
+			}
+
+			fmt.Fprintf(bw, "%5d ", start)
+			for i := start; i < end; i++ {
+				if opts.Color {
+					bw.WriteString(bitClassANSI[p.Classes[i]])
+				}
+				bw.WriteByte('0' + p.Bits[i])
+			}
+			if opts.Color {
+				bw.WriteString("\x1b[0m")
+			}
+			bw.WriteString("\n      ")
+			for i := start; i < end; i++ {
+				bw.WriteByte(bitClassGlyphs[p.Classes[i]])
+			}
+			bw.WriteByte('\n')
+			bw.WriteString(strings.TrimRight("      "+string(labels[start:end]), " "))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// Plot geometry shared by the SVG and PNG renderings: each track is a row with
+// a band of class colours behind the F2F flux waveform of its bits
+const (
+	plotMargin    = 10
+	plotLabelRow  = 16
+	plotWaveRow   = 24
+	plotTrackGap  = 14
+	plotSVGCell   = 8
+	plotPNGCell   = 4
+	plotWaveInset = 4
+)
+
+// plotSize returns the image size of plots drawn with cells of the given width
+func plotSize(plots []*TrackPlot, cell int) (int, int) {
+	longest := 0
+	for _, p := range plots {
+		if len(p.Bits) > longest {
+			longest = len(p.Bits)
+		}
+	}
+	w := 2*plotMargin + longest*cell
+	h := 2*plotMargin + len(plots)*(plotLabelRow+plotWaveRow+plotTrackGap)
+	return w, h
+}
+
+// fluxLevels returns the signal level at the start and middle of each bit cell
+// in F2F (Aiken biphase) encoding: the level flips at every cell boundary and
+// once more in the middle of a 1
+func fluxLevels(bits Bits) [][2]bool {
+	levels := make([][2]bool, len(bits))
+	level := false
+	for i, b := range bits {
+		level = !level
+		levels[i][0] = level
+		if b == 1 {
+			level = !level
+		}
+		levels[i][1] = level
+	}
+	return levels
+}
+
+// WritePlotSVG renders the plots as an SVG timeline with character labels
+func WritePlotSVG(w io.Writer, plots []*TrackPlot) error {
+	cell := plotSVGCell
+	width, height := plotSize(plots, cell)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="10">`+"\n", width, height)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+
+	for n, p := range plots {
+		top := plotMargin + n*(plotLabelRow+plotWaveRow+plotTrackGap)
+		wave := top + plotLabelRow
+		fmt.Fprintf(bw, `<g id="track%d">`+"\n", p.Track)
+		fmt.Fprintf(bw, `<title>%s</title>`+"\n", html.EscapeString(p.Summary()))
+
+		// Runs of the same class share one background rectangle
+		for i := 0; i < len(p.Classes); {
+			j := i
+			for j < len(p.Classes) && p.Classes[j] == p.Classes[i] {
+				j++
+			}
+			c := bitClassColors[p.Classes[i]]
+			fmt.Fprintf(bw, `<rect x="%d" y="%d" width="%d" height="%d" fill="#%02x%02x%02x"/>`+"\n",
+				plotMargin+i*cell, wave, (j-i)*cell, plotWaveRow, c.R, c.G, c.B)
+			i = j
+		}
+
+		var path strings.Builder
+		hi, lo := wave+plotWaveInset, wave+plotWaveRow-plotWaveInset
+		y := func(level bool) int {
+			if level {
+				return hi
+			}
+			return lo
+		}
+		for i, l := range fluxLevels(p.Bits) {
+			x := plotMargin + i*cell
+			if i == 0 {
+				fmt.Fprintf(&path, "M%d %d", x, y(!l[0]))
+			}
+			fmt.Fprintf(&path, " V%d H%d V%d H%d", y(l[0]), x+cell/2, y(l[1]), x+cell)
+		}
+		if path.Len() > 0 {
+			fmt.Fprintf(bw, `<path d="%s" fill="none" stroke="black" stroke-width="1"/>`+"\n", path.String())
+		}
+
+		for _, m := range p.Marks {
+			x := plotMargin + m.Offset*cell
+			fmt.Fprintf(bw, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#808080"/>`+"\n", x, wave, x, wave+plotWaveRow)
+			fmt.Fprintf(bw, `<text x="%d" y="%d">%s</text>`+"\n", x+1, wave-4, html.EscapeString(m.Label))
+		}
+		fmt.Fprintf(bw, `<text x="%d" y="%d" fill="#404040">%s</text>`+"\n",
+			plotMargin, wave+plotWaveRow+11, html.EscapeString(p.Summary()))
+		bw.WriteString("</g>\n")
+	}
+
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
+
+// WritePlotPNG renders the plots as a PNG timeline. Character labels are not
+// drawn; boundaries between characters are shown as grey ticks.
+func WritePlotPNG(w io.Writer, plots []*TrackPlot) error {
+	cell := plotPNGCell
+	width, height := plotSize(plots, cell)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill := func(x0, y0, x1, y1 int, c color.RGBA) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	fill(0, 0, width, height, color.RGBA{0xff, 0xff, 0xff, 0xff})
+
+	black := color.RGBA{0, 0, 0, 0xff}
+	grey := color.RGBA{0x80, 0x80, 0x80, 0xff}
+	for n, p := range plots {
+		wave := plotMargin + n*(plotLabelRow+plotWaveRow+plotTrackGap) + plotLabelRow
+		for i, class := range p.Classes {
+			x := plotMargin + i*cell
+			fill(x, wave, x+cell, wave+plotWaveRow, bitClassColors[class])
+		}
+		for _, m := range p.Marks {
+			x := plotMargin + m.Offset*cell
+			fill(x, wave-6, x+1, wave, grey)
+		}
+
+		hi, lo := wave+plotWaveInset, wave+plotWaveRow-plotWaveInset
+		y := func(level bool) int {
+			if level {
+				return hi
+			}
+			return lo
+		}
+		prev := false
+		for i, l := range fluxLevels(p.Bits) {
+			x := plotMargin + i*cell
+			for half, level := range l {
+				hx := x + half*cell/2
+				if level != prev || (i == 0 && half == 0) {
+					fill(hx, hi, hx+1, lo+1, black)
+				}
+				fill(hx, y(level), hx+cell/2+1, y(level)+1, black)
+				prev = level
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
@@ -0,0 +1,178 @@
+package magstripe
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+// plotBits returns ";12?" and its LRC in ABA after 5 leading zeros, followed by
+// 10 zeros of padding
+func plotBits(t *testing.T) Bits {
+	t.Helper()
+	bits, err := CharsetABA.Encode(";12?")
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return append(append(make(Bits, 5), bits...), make(Bits, 10)...)
+}
+
+func TestNewTrackPlot(t *testing.T) {
+	bits := plotBits(t)
+	d, err := CharsetABA.Decode(bits)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	p := NewTrackPlot(2, bits, d)
+
+	var glyphs []byte
+	for _, c := range p.Classes {
+		glyphs = append(glyphs, bitClassGlyphs[c])
+	}
+	want := "_____" + "SSSSS" + "ddddp" + "ddddp" + "SSSSS" + "LLLLL" + "__________"
+	if string(glyphs) != want {
+		t.Errorf("classes\n got %s\nwant %s", glyphs, want)
+	}
+
+	var labels []string
+	for _, m := range p.Marks {
+		labels = append(labels, m.Label)
+	}
+	if got := strings.Join(labels, " "); got != "; 1 2 ? LRC" {
+		t.Errorf("labels %q", got)
+	}
+	if got := p.Summary(); got != "track 2: 40 bits, aba 4+odd bits, 4 chars, LRC ok" {
+		t.Errorf("summary %q", got)
+	}
+}
+
+func TestNewTrackPlotErrors(t *testing.T) {
+	bits := plotBits(t)
+	bits[5+5+1] ^= 1 // '1' becomes '3' with bad parity
+	bits = append(bits, 1, 1)
+	d, err := CharsetABA.Decode(bits)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	p := NewTrackPlot(3, bits, d)
+
+	classes := map[BitClass]int{}
+	for _, c := range p.Classes {
+		classes[c]++
+	}
+	if classes[BitParityError] != 1 || classes[BitLRCError] != 5 || classes[BitNoise] != 2 {
+		t.Errorf("unexpected classes %v", classes)
+	}
+	if !strings.Contains(p.Summary(), "1 parity errors, LRC error") {
+		t.Errorf("summary %q", p.Summary())
+	}
+}
+
+func TestNewTrackPlotReversed(t *testing.T) {
+	bits := plotBits(t)
+	d, err := DecodeBits(bits.Reverse(), nil)
+	if err != nil {
+		t.Fatalf("DecodeBits: %v", err)
+	}
+	if !d.Reversed {
+		t.Fatal("expected a reversed decoding")
+	}
+	p := NewTrackPlot(2, bits.Reverse(), d)
+	if p.Bits.String() != bits.String() {
+		t.Errorf("bits not plotted in decoding order")
+	}
+	if p.Marks[0].Label != ";" || !strings.Contains(p.Summary(), "reversed") {
+		t.Errorf("unexpected plot: %v, %q", p.Marks, p.Summary())
+	}
+}
+
+func TestWritePlotText(t *testing.T) {
+	bits := plotBits(t)
+	d, _ := CharsetABA.Decode(bits)
+	plots := []*TrackPlot{NewTrackPlot(2, bits, d), NewTrackPlot(3, Bits{0, 1, 0}, nil)}
+
+	var buf bytes.Buffer
+	if err := WritePlotText(&buf, plots, PlotOptions{Width: 20}); err != nil {
+		t.Fatalf("WritePlotText: %v", err)
+	}
+	want := `track 2: 40 bits, aba 4+odd bits, 4 chars, LRC ok
+    0 00000110101000001000
+      _____SSSSSddddpddddp
+           ;    1    2
+   20 11111111000000000000
+      SSSSSLLLLL__________
+      ?    LRC
+
+track 3: 3 bits, not decoded
+    0 010
+      _?_
+
+`
+	if buf.String() != want {
+		t.Errorf("text plot\n got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := WritePlotText(&buf, plots[:1], PlotOptions{Color: true}); err != nil {
+		t.Fatalf("WritePlotText: %v", err)
+	}
+	if !strings.Contains(buf.String(), bitClassANSI[BitSentinel]+"1") {
+		t.Error("expected ANSI colours")
+	}
+}
+
+func TestWritePlotSVG(t *testing.T) {
+	bits := plotBits(t)
+	d, _ := CharsetABA.Decode(bits)
+	plots := []*TrackPlot{NewTrackPlot(1, Bits{}, nil), NewTrackPlot(2, bits, d)}
+
+	var buf bytes.Buffer
+	if err := WritePlotSVG(&buf, plots); err != nil {
+		t.Fatalf("WritePlotSVG: %v", err)
+	}
+	dec := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid SVG: %v\n%s", err, buf.String())
+		}
+	}
+	for _, s := range []string{`id="track2"`, ">LRC</text>", ">;</text>", "<path"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("SVG lacks %s", s)
+		}
+	}
+}
+
+func TestWritePlotPNG(t *testing.T) {
+	bits := plotBits(t)
+	d, _ := CharsetABA.Decode(bits)
+
+	var buf bytes.Buffer
+	if err := WritePlotPNG(&buf, []*TrackPlot{NewTrackPlot(2, bits, d)}); err != nil {
+		t.Fatalf("WritePlotPNG: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	w, h := plotSize([]*TrackPlot{{Bits: bits}}, plotPNGCell)
+	if b := img.Bounds(); b.Dx() != w || b.Dy() != h {
+		t.Errorf("image size %v, expected %dx%d", b, w, h)
+	}
+}
+
+func TestFluxLevels(t *testing.T) {
+	levels := fluxLevels(Bits{0, 1, 1, 0})
+	want := [][2]bool{{true, true}, {false, true}, {false, true}, {false, false}}
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Errorf("levels %v, expected %v", levels, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package magstripe
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+)
+
+// ErrUnsupported is returned when the device profile does not support an operation
+var ErrUnsupported = errors.New("operation not supported by device")
+
+// Commands holds the command letters a device understands (sent after EscapeCode)
+type Commands struct {
+	Reset    string
+	ReadISO  string
+	WriteISO string
+	ReadRaw  string
+	WriteRaw string
+	Erase    string
+	HiCo     string
+	LoCo     string
+	SetBPI   string
+	SetBPC   string
+	// GetCoercivity queries the coercivity; empty if the device has no such command
+	GetCoercivity string
+	// Version queries the firmware version; empty if the device has no such command
+	Version string
+}
+
+// Profile describes the command set and capabilities of a device model
+type Profile struct {
+	// Name is the model identifier used by LookupProfile
+	Name string
+	// Description is a human readable summary of the model
+	Description string
+	// ReadOnly is set for readers that cannot write or erase cards
+	ReadOnly bool
+	// Coercivity is set when the device can switch between HiCo and LoCo
+	Coercivity bool
+	// Raw is set when the device supports raw reads and writes
+	Raw bool
+	// HID is set for devices that exchange USB HID reports instead of using a serial line
+	HID bool
+	// Commands is the command set of the device
+	Commands Commands
+	// StatusOK is the status byte reporting success
+	StatusOK byte
+	// StatusText describes the failure status bytes the device reports
+	StatusText map[byte]string
+	// Framing parses the responses of the device; nil fields use the MSR605 framing
+	Framing Framing
+}
+
+// Framing holds the functions that parse the responses of a device
+type Framing struct {
+	// Complete reports whether a response read so far is a full answer
+	Complete func(response []byte) bool
+	// Split parses a response into its status, result and data
+	Split func(response []byte) (status byte, result, data []byte, err error)
+	// ISOStrips locates the three strips of an ISO format data block,
+	// redacting errors with the given Redact
+	ISOStrips func(data []byte, p Redact) ([3]Span, error)
+	// RawStrips locates the three strips of a raw format data block
+	RawStrips func(data []byte, p Redact) ([3]Span, error)
+}
+
+// StatusError is returned when the device answers a command with a failure status
+type StatusError struct {
+	Op     string
+	Status byte
+	Text   string
+}
+
+func (e *StatusError) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("%s error: %c (%s)", e.Op, e.Status, e.Text)
+	}
+	return fmt.Sprintf("%s error: %c", e.Op, e.Status)
+}
+
+// checkStatus converts a status byte into an error for the named operation
+func (p *Profile) checkStatus(op string, status byte) error {
+	if status == p.StatusOK {
+		return nil
+	}
+	return &StatusError{Op: op, Status: status, Text: p.StatusText[status]}
+}
+
+// require returns an error when the profile lacks a capability needed by op
+func (p *Profile) require(op string, supported bool) error {
+	if !supported {
+		return fmt.Errorf("%s on %s: %w", op, p.Name, ErrUnsupported)
+	}
+	return nil
+}
+
+// msr605Commands is the command set shared by the MSR605 family
+var msr605Commands = Commands{
+	Reset:    "a",
+	ReadISO:  "r",
+	WriteISO: "w",
+	ReadRaw:  "m",
+	WriteRaw: "n",
+	Erase:    "c",
+	HiCo:     "x",
+	LoCo:     "y",
+	SetBPI:   "b",
+	SetBPC:   "o",
+	Version:  "v",
+
+	GetCoercivity: "d",
+}
+
+// msr605Status describes the status bytes of the MSR605 family
+var msr605Status = map[byte]string{
+	'1': "read/write error",
+	'2': "command format error",
+	'4': "invalid command",
+	'9': "invalid card swipe in write mode",
+}
+
+// msr206Status describes the status bytes of the MSR206. Its manual lists the
+// same codes as the MSR605; the table is kept apart so either can change alone.
+var msr206Status = map[byte]string{
+	'1': "read/write error",
+	'2': "command format error",
+	'4': "invalid command",
+	'9': "invalid card swipe in write mode",
+}
+
+// msr605Framing parses the <ESC>-framed responses of the MSR605 family
+var msr605Framing = Framing{
+	Complete:  responseComplete,
+	Split:     splitResponse,
+	ISOStrips: isoDataBlockStrips,
+	RawStrips: rawDataBlockStrips,
+}
+
+// Device profiles. Options and LookupProfile copy them, so changing one
+// affects neither the devices already open nor the built-in table.
+var (
+	// ProfileMSR605 is the MSR605 serial reader/writer (the default)
+	ProfileMSR605 = Profile{
+		Name:        "msr605",
+		Description: "MSR605 serial reader/writer",
+		Coercivity:  true,
+		Raw:         true,
+		Commands:    msr605Commands,
+		StatusOK:    '0',
+		StatusText:  msr605Status,
+		Framing:     msr605Framing,
+	}
+
+	// ProfileMSR605X is the MSR605X, which speaks the MSR605 protocol over USB HID
+	ProfileMSR605X = Profile{
+		Name:        "msr605x",
+		Description: "MSR605X USB HID reader/writer",
+		Coercivity:  true,
+		Raw:         true,
+		HID:         true,
+		Commands:    msr605Commands,
+		StatusOK:    '0',
+		StatusText:  msr605Status,
+		Framing:     msr605Framing,
+	}
+
+	// ProfileMSR206 is the MSR206 serial reader/writer. No protocol differences
+	// from the MSR605 are known.
+	ProfileMSR206 = Profile{
+		Name:        "msr206",
+		Description: "MSR206 serial reader/writer",
+		Coercivity:  true,
+		Raw:         true,
+		Commands:    msr605Commands,
+		StatusOK:    '0',
+		StatusText:  msr206Status,
+		Framing:     msr605Framing,
+	}
+
+	// ProfileReadOnly is a reader that understands the MSR605 read commands but
+	// has no write head
+	ProfileReadOnly = Profile{
+		Name:        "readonly",
+		Description: "MSR605-compatible read-only reader",
+		Raw:         true,
+		ReadOnly:    true,
+		Commands:    msr605Commands,
+		StatusOK:    '0',
+		StatusText:  msr605Status,
+		Framing:     msr605Framing,
+	}
+)
+
+// profiles holds copies of the built-in profiles by name
+var profiles = map[string]Profile{}
+
+// profileAliases names models that speak the protocol of a built-in profile
+// with no differences the library knows of
+var profileAliases = map[string]string{
+	"msrx6": "msr605",
+}
+
+func init() {
+	for _, p := range []Profile{ProfileMSR605, ProfileMSR605X, ProfileMSR206, ProfileReadOnly} {
+		profiles[p.Name] = *p.clone()
+	}
+}
+
+// clone returns a copy of p that shares no state with it, with the framing
+// functions p leaves nil set to the MSR605 ones
+func (p Profile) clone() *Profile {
+	p.StatusText = maps.Clone(p.StatusText)
+	if p.Framing.Complete == nil {
+		p.Framing.Complete = msr605Framing.Complete
+	}
+	if p.Framing.Split == nil {
+		p.Framing.Split = msr605Framing.Split
+	}
+	if p.Framing.ISOStrips == nil {
+		p.Framing.ISOStrips = msr605Framing.ISOStrips
+	}
+	if p.Framing.RawStrips == nil {
+		p.Framing.RawStrips = msr605Framing.RawStrips
+	}
+	return &p
+}
+
+// LookupProfile returns a copy of the built-in profile with the given name.
+// The MSRX6 ("msrx6") is an alias that gets the MSR605 profile.
+func LookupProfile(name string) (Profile, bool) {
+	if alias, ok := profileAliases[name]; ok {
+		name = alias
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, false
+	}
+	return *p.clone(), true
+}
+
+// ProfileNames returns the names of the built-in profiles and their aliases
+// in sorted order
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles)+len(profileAliases))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	for name := range profileAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
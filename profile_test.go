@@ -0,0 +1,192 @@
+package magstripe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedPort is a Port that answers each write with the next canned response
+type scriptedPort struct {
+	responses []string
+	written   []string
+	pending   []byte
+	closed    bool
+}
+
+func (p *scriptedPort) Write(b []byte) (int, error) {
+	p.written = append(p.written, string(b))
+	if len(p.responses) > 0 {
+		p.pending = append(p.pending, p.responses[0]...)
+		p.responses = p.responses[1:]
+	}
+	return len(b), nil
+}
+
+func (p *scriptedPort) Read(b []byte) (int, error) {
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *scriptedPort) SetReadTimeout(time.Duration) error { return nil }
+
+func (p *scriptedPort) Close() error {
+	p.closed = true
+	return nil
+}
+
+func newScriptedMSR(t *testing.T, profile Profile, responses ...string) (*MSR, *scriptedPort) {
+	t.Helper()
+	port := &scriptedPort{responses: responses}
+	m, err := NewMSRPort(port, WithProfile(profile), WithoutInitialReset(),
+		WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+	return m, port
+}
+
+func TestLookupProfile(t *testing.T) {
+	for _, name := range []string{"msr206", "msr605", "msr605x", "readonly"} {
+		p, ok := LookupProfile(name)
+		if !ok {
+			t.Errorf("profile %q not found", name)
+			continue
+		}
+		if p.Name != name {
+			t.Errorf("profile %q has name %q", name, p.Name)
+		}
+	}
+	if _, ok := LookupProfile("msr9000"); ok {
+		t.Error("unknown profile should not be found")
+	}
+	if p, ok := LookupProfile("msrx6"); !ok || p.Name != "msr605" {
+		t.Errorf("msrx6 should get the msr605 profile, got %q", p.Name)
+	}
+	if names := ProfileNames(); len(names) != 5 || names[0] != "msr206" || names[3] != "msrx6" {
+		t.Errorf("unexpected profile names: %v", names)
+	}
+}
+
+func TestProfileFraming(t *testing.T) {
+	var split, strips int
+	p := ProfileMSR206
+	p.Framing = Framing{
+		Split: func(response []byte) (byte, []byte, []byte, error) {
+			split++
+			return splitResponse(response)
+		},
+		ISOStrips: func(data []byte, r Redact) ([3]Span, error) {
+			strips++
+			return isoDataBlockStrips(data, r)
+		},
+	}
+	block := encodeISODataBlock("%B123?", ";123?", "")
+	m, _ := newScriptedMSR(t, p, block+EscapeCode+"0")
+
+	if _, err := m.ReadTracks(); err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if split != 1 || strips != 1 {
+		t.Errorf("profile framing called %d/%d times, want 1/1", split, strips)
+	}
+	if f := m.Profile().Framing; f.Complete == nil || f.RawStrips == nil {
+		t.Error("framing functions left nil should default to the MSR605 ones")
+	}
+}
+
+func TestProfileCopies(t *testing.T) {
+	p, _ := LookupProfile("msr605")
+	p.ReadOnly = true
+	p.StatusText['1'] = "changed"
+	m, _ := newScriptedMSR(t, p)
+	p.Raw = false
+
+	if again, _ := LookupProfile("msr605"); again.ReadOnly || again.StatusText['1'] != "read/write error" {
+		t.Errorf("changing a looked up profile changed the built-in one: %+v", again)
+	}
+	if got := m.Profile(); !got.ReadOnly || !got.Raw {
+		t.Errorf("changing a profile after WithProfile changed the device: %+v", got)
+	}
+	got := m.Profile()
+	got.ReadOnly = false
+	if !m.Profile().ReadOnly {
+		t.Error("changing the result of Profile changed the device")
+	}
+}
+
+func TestDefaultProfile(t *testing.T) {
+	m, err := NewMSRPort(&scriptedPort{}, WithoutInitialReset())
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+	if m.Profile().Name != "msr605" {
+		t.Errorf("default profile should be msr605, got %s", m.Profile().Name)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	m, port := newScriptedMSR(t, ProfileMSR605, EscapeCode+"1")
+	err := m.WriteTracks("A", "1", "")
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected StatusError, got %v", err)
+	}
+	if statusErr.Status != '1' || statusErr.Op != "write" {
+		t.Errorf("unexpected status error: %+v", statusErr)
+	}
+	if !strings.Contains(err.Error(), "read/write error") {
+		t.Errorf("error should describe the status: %v", err)
+	}
+	if !strings.HasPrefix(port.written[0], EscapeCode+"w") {
+		t.Errorf("unexpected command sent: %q", port.written[0])
+	}
+}
+
+func TestReadOnlyProfile(t *testing.T) {
+	m, port := newScriptedMSR(t, ProfileReadOnly)
+
+	checks := map[string]error{
+		"write":      m.WriteTracks("A", "1", "2"),
+		"raw write":  m.WriteRawTracks("A", "1", "2"),
+		"erase":      m.EraseTracks(true, true, true),
+		"coercivity": m.SetCoercivity(HiCo),
+	}
+	for name, err := range checks {
+		if !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: expected ErrUnsupported, got %v", name, err)
+		}
+	}
+	if len(port.written) != 0 {
+		t.Errorf("unsupported operations should not reach the device: %q", port.written)
+	}
+}
+
+func TestReadWithProfile(t *testing.T) {
+	block := encodeISODataBlock("%B123?", ";123?", "")
+	m, _ := newScriptedMSR(t, ProfileMSR605X, block+EscapeCode+"0")
+
+	tracks, err := m.ReadTracks()
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if tracks.Track1 != "%B123?" || tracks.Track2 != ";123?" || tracks.Track3 != "" {
+		t.Errorf("unexpected tracks: %+v", tracks)
+	}
+}
+
+func TestHIDPayload(t *testing.T) {
+	report := make([]byte, hidReportSize)
+	report[0] = hidFirstPacket | hidLastPacket | 3
+	copy(report[1:], "\x1b0X")
+
+	if got := string(hidPayload(report)); got != "\x1b0X" {
+		t.Errorf("unexpected payload %q", got)
+	}
+	if got := hidPayload(nil); got != nil {
+		t.Errorf("empty report should have no payload, got %q", got)
+	}
+}
@@ -0,0 +1,59 @@
+package magstripe
+
+import "context"
+
+// CardReader is implemented by devices that can read cards
+type CardReader interface {
+	// ReadTracks waits for a swipe and returns the tracks in ISO format
+	ReadTracks() (*TrackData, error)
+	// ReadRawTracks waits for a swipe and returns the raw track data
+	ReadRawTracks() (string, string, string, error)
+	// Watch delivers swipes until ctx is cancelled
+	Watch(ctx context.Context) <-chan Swipe
+	// Close releases the device
+	Close() error
+}
+
+// CardWriter is implemented by devices that can encode and configure cards
+type CardWriter interface {
+	// WriteTracks writes the tracks in ISO format
+	WriteTracks(t1, t2, t3 string) error
+	// WriteRawTracks writes raw track data
+	WriteRawTracks(t1, t2, t3 string) error
+	// EraseTracks erases the selected tracks
+	EraseTracks(t1, t2, t3 bool) error
+	// SetCoercivity selects high or low coercivity.
+	//
+	// Deprecated: use Configurer.
+	SetCoercivity(hico bool) error
+	// SetBPC sets the bits per character of each track.
+	//
+	// Deprecated: use Configurer.
+	SetBPC(bpc1, bpc2, bpc3 int) error
+	// SetBPI sets the bits per inch of the tracks that are not nil.
+	//
+	// Deprecated: use Configurer.
+	SetBPI(bpi1, bpi2, bpi3 *bool) error
+}
+
+// Configurer is implemented by writers whose settings can be applied and
+// queried together. It is kept apart from CardWriter so that existing
+// CardWriter implementations keep satisfying it.
+type Configurer interface {
+	// Configure applies the coercivity, BPI and BPC set, leaving the others unchanged
+	Configure(s Settings) error
+	// Settings returns the settings of the device, unset where not known
+	Settings() (Settings, error)
+}
+
+// CardReadWriter is implemented by devices that can both read and write cards
+type CardReadWriter interface {
+	CardReader
+	CardWriter
+}
+
+var (
+	_ CardReadWriter = (*MSR)(nil)
+	_ Configurer     = (*MSR)(nil)
+	_ CardReader     = (*KeyboardReader)(nil)
+)
@@ -0,0 +1,151 @@
+package magstripe
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDisconnected is returned by the command that finds the device unplugged,
+// and by the following ones until it can be reopened. It is only returned by
+// an MSR set up with WithReconnect.
+var ErrDisconnected = errors.New("device disconnected")
+
+// ConnState is the state of the connection to the device
+type ConnState int
+
+const (
+	StateConnected ConnState = iota
+	StateDisconnected
+)
+
+func (s ConnState) String() string {
+	if s == StateConnected {
+		return "connected"
+	}
+	return "disconnected"
+}
+
+// StateEvent reports a change of the connection state
+type StateEvent struct {
+	State ConnState
+	// Err is the port error the device was lost on
+	Err  error
+	Time time.Time
+}
+
+// ReconnectPolicy sets how an MSR recovers from its device being unplugged
+type ReconnectPolicy struct {
+	// Open reopens the port. NewMSR reopens the device path when nil; with
+	// NewMSRPort, disconnections are reported but the port is not reopened
+	// unless Open is set.
+	Open func() (Port, error)
+	// Interval is the delay between attempts to reopen the port (default 1s)
+	Interval time.Duration
+	// Wait is how long a command waits for the device to come back before
+	// failing with ErrDisconnected. When zero, each command makes one attempt.
+	Wait time.Duration
+	// Supervised keeps Watch running through disconnections: the loss of the
+	// device is delivered once as a Swipe with ErrDisconnected, and watching
+	// resumes when it comes back. Without it, the watch ends.
+	Supervised bool
+	// OnStateChange, when set, is called when the device is lost or reopened
+	OnStateChange func(StateEvent)
+}
+
+// WithReconnect makes the MSR detect that its device was unplugged, from the
+// errors of the port, and reopen it when it comes back, applying again the
+// coercivity, BPI and BPC set through the MSR. The command that finds the
+// device gone fails with ErrDisconnected; the following ones reopen the port.
+func WithReconnect(p ReconnectPolicy) Option {
+	return func(c *config) {
+		if p.Interval <= 0 {
+			p.Interval = time.Second
+		}
+		c.reconnect = &p
+	}
+}
+
+// State returns the state of the connection to the device. It is always
+// StateConnected without WithReconnect.
+func (m *MSR) State() ConnState {
+	return m.state
+}
+
+// setState records a change of the connection state and reports it
+func (m *MSR) setState(state ConnState, err error) {
+	m.state = state
+	if state == StateDisconnected {
+		m.logger.Warn("device disconnected", "error", err)
+	} else {
+		m.logger.Info("device reconnected")
+	}
+	if m.reconnect.OnStateChange != nil {
+		m.reconnect.OnStateChange(StateEvent{State: state, Err: err, Time: time.Now()})
+	}
+}
+
+// portError handles an error from the port. With a reconnect policy the device
+// is taken as unplugged: the port is closed and ErrDisconnected returned.
+func (m *MSR) portError(err error) error {
+	if m.reconnect == nil {
+		return err
+	}
+	m.port.Close()
+	m.setState(StateDisconnected, err)
+	return fmt.Errorf("%w: %v", ErrDisconnected, err)
+}
+
+// connect reopens the port of a disconnected device, trying every Interval
+// for up to the policy's Wait
+func (m *MSR) connect() error {
+	if m.reconnect == nil || m.state == StateConnected {
+		return nil
+	}
+	p := m.reconnect
+	if p.Open == nil {
+		return fmt.Errorf("%w: the port cannot be reopened", ErrDisconnected)
+	}
+
+	deadline := time.Now().Add(p.Wait)
+	for {
+		err := m.reopen()
+		switch {
+		case err == nil:
+			return nil
+		case m.state == StateConnected:
+			return fmt.Errorf("failed to restore settings after reconnecting: %w", err)
+		case !time.Now().Add(p.Interval).Before(deadline):
+			if errors.Is(err, ErrDisconnected) {
+				// lost again while restoring the settings
+				return err
+			}
+			return fmt.Errorf("%w: %v", ErrDisconnected, err)
+		}
+		time.Sleep(p.Interval)
+	}
+}
+
+// reopen opens the port again and restores the settings made through the MSR
+func (m *MSR) reopen() error {
+	port, err := m.reconnect.Open()
+	if err != nil {
+		return err
+	}
+	if m.recording != nil {
+		port = NewRecorder(port, m.recording)
+	}
+	if err := port.SetReadTimeout(m.readTimeout); err != nil {
+		port.Close()
+		return fmt.Errorf("failed to set read timeout: %w", err)
+	}
+	m.port = port
+	m.setState(StateConnected, nil)
+	return m.restoreSettings()
+}
+
+// restoreSettings applies again the coercivity, BPI and BPC last set through
+// the MSR, lost when the device was unplugged
+func (m *MSR) restoreSettings() error {
+	return m.Configure(m.settings)
+}
@@ -0,0 +1,137 @@
+package magstripe
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newReconnectMSR(t *testing.T, sim *Simulator, p ReconnectPolicy) *MSR {
+	t.Helper()
+	m, err := NewMSRPort(sim, WithoutInitialReset(), WithCommandTimeout(200*time.Millisecond), WithReconnect(p))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+	return m
+}
+
+func TestReconnect(t *testing.T) {
+	var events []StateEvent
+	sim := NewSimulator()
+	sim.SetCard(TrackData{Track2: testBankTrack2})
+	m := newReconnectMSR(t, sim, ReconnectPolicy{
+		Open:          sim.Open,
+		Interval:      10 * time.Millisecond,
+		OnStateChange: func(ev StateEvent) { events = append(events, ev) },
+	})
+	if err := m.SetCoercivity(LoCo); err != nil {
+		t.Fatalf("SetCoercivity: %v", err)
+	}
+	if err := m.SetBPC(7, 5, 7); err != nil {
+		t.Fatalf("SetBPC: %v", err)
+	}
+
+	sim.Unplug()
+	if _, err := m.ReadTracks(); !errors.Is(err, ErrDisconnected) || m.State() != StateDisconnected {
+		t.Fatalf("expected ErrDisconnected, got %v in state %v", err, m.State())
+	}
+	if _, err := m.ReadTracks(); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("read while unplugged: %v", err)
+	}
+	if len(events) != 1 || events[0].State != StateDisconnected || events[0].Err == nil {
+		t.Errorf("unexpected events %+v", events)
+	}
+
+	sim.Plug()
+	before := len(sim.Commands())
+	tracks, err := m.ReadTracks()
+	if err != nil || tracks.Track2 != testBankTrack2 {
+		t.Fatalf("ReadTracks after reconnecting = %v, %v", tracks, err)
+	}
+	if m.State() != StateConnected || len(events) != 2 || events[1].State != StateConnected {
+		t.Errorf("unexpected state %v, events %+v", m.State(), events)
+	}
+	if got := strings.Join(sim.Commands()[before:], ""); got != "yor" {
+		t.Errorf("commands after reconnecting %q, expected the settings applied again", got)
+	}
+	if sim.Coercivity() != LoCo {
+		t.Error("coercivity not restored")
+	}
+}
+
+func TestReconnectWait(t *testing.T) {
+	sim := NewSimulator()
+	m := newReconnectMSR(t, sim, ReconnectPolicy{
+		Open:     sim.Open,
+		Interval: 10 * time.Millisecond,
+		Wait:     2 * time.Second,
+	})
+	sim.Unplug()
+	if _, err := m.Firmware(); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("expected ErrDisconnected, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		sim.Plug()
+	}()
+	if fw, err := m.Firmware(); err != nil || fw != SimulatorFirmware {
+		t.Errorf("Firmware while waiting for the device = %q, %v", fw, err)
+	}
+
+	// without Open the loss is reported but the port is not reopened
+	sim = NewSimulator()
+	m = newReconnectMSR(t, sim, ReconnectPolicy{})
+	sim.Unplug()
+	m.Firmware()
+	sim.Plug()
+	if _, err := m.Firmware(); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("expected ErrDisconnected without Open, got %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestWatchSupervised(t *testing.T) {
+	for _, supervised := range []bool{false, true} {
+		sim := NewSimulator()
+		sim.RemoveCard()
+		m := newReconnectMSR(t, sim, ReconnectPolicy{
+			Open:       sim.Open,
+			Interval:   10 * time.Millisecond,
+			Supervised: supervised,
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		swipes := m.Watch(ctx)
+
+		sim.Unplug()
+		if s := <-swipes; !errors.Is(s.Err, ErrDisconnected) {
+			t.Fatalf("supervised %v: expected ErrDisconnected, got %+v", supervised, s)
+		}
+		if !supervised {
+			if _, ok := <-swipes; ok {
+				t.Error("watch continued after losing the device")
+			}
+			cancel()
+			continue
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		sim.Plug()
+		sim.SetCard(TrackData{Track2: testBankTrack2})
+		select {
+		case s := <-swipes:
+			if s.Err != nil || s.Tracks.Track2 != testBankTrack2 {
+				t.Errorf("expected the swipe after reconnecting, got %+v", s)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no swipe after reconnecting")
+		}
+		cancel()
+		for range swipes {
+		}
+	}
+}
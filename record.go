@@ -0,0 +1,220 @@
+package magstripe
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrReplayMismatch is returned when a replayed session receives a command
+// other than the one that was recorded
+var ErrReplayMismatch = errors.New("command does not match recorded session")
+
+// sessionRecord is one line of a session file
+type sessionRecord struct {
+	// At is the time since the session started
+	At   time.Duration `json:"at"`
+	Dir  string        `json:"dir"`
+	Data string        `json:"data"`
+}
+
+// Recorder is a Port that passes traffic through to another Port and writes
+// every exchanged byte to a session file as JSON lines
+type Recorder struct {
+	port  Port
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+	err   error
+}
+
+// NewRecorder records the traffic of port to w
+func NewRecorder(port Port, w io.Writer) *Recorder {
+	return &Recorder{port: port, enc: json.NewEncoder(w), start: time.Now()}
+}
+
+// Write sends p to the device and records it
+func (r *Recorder) Write(p []byte) (int, error) {
+	n, err := r.port.Write(p)
+	r.record(Sent, p[:n])
+	return n, err
+}
+
+// Read receives from the device and records what was read
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.port.Read(p)
+	if n > 0 {
+		r.record(Received, p[:n])
+	}
+	return n, err
+}
+
+// SetReadTimeout sets the read timeout of the device port
+func (r *Recorder) SetReadTimeout(t time.Duration) error {
+	return r.port.SetReadTimeout(t)
+}
+
+// Close closes the device port and reports the first error writing the session
+func (r *Recorder) Close() error {
+	err := r.port.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return fmt.Errorf("failed to record session: %w", r.err)
+	}
+	return err
+}
+
+func (r *Recorder) record(dir Direction, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = r.enc.Encode(sessionRecord{
+		At:   time.Since(r.start),
+		Dir:  dir.String(),
+		Data: hex.EncodeToString(data),
+	})
+}
+
+// Replayer is a Port that plays back a recorded session. Each command written
+// must match the recorded one, after which the bytes the device sent in
+// response are served to Read.
+type Replayer struct {
+	mu      sync.Mutex
+	records []sessionRecord
+	pending []byte
+	timeout time.Duration
+}
+
+// NewReplayer loads a session recorded by a Recorder
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	var records []sessionRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec sessionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("invalid session line %d: %w", line, err)
+		}
+		if rec.Dir != Sent.String() && rec.Dir != Received.String() {
+			return nil, fmt.Errorf("invalid session line %d: unknown direction %q", line, rec.Dir)
+		}
+		if _, err := hex.DecodeString(rec.Data); err != nil {
+			return nil, fmt.Errorf("invalid session line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	rp := &Replayer{records: records}
+	rp.queueResponses()
+	return rp, nil
+}
+
+// OpenReplay loads a session file, decrypting it with the first of ids that
+// can when it was recorded through Encrypt
+func OpenReplay(path string, ids ...Identity) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := Decrypt(f, ids...)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(r)
+}
+
+// Write checks p against the next recorded command and queues its response
+func (rp *Replayer) Write(p []byte) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if len(rp.records) == 0 {
+		return 0, fmt.Errorf("%w: session ended, got %s", ErrReplayMismatch, commandSummary(p))
+	}
+	want, _ := hex.DecodeString(rp.records[0].Data)
+	if string(want) != string(p) {
+		return 0, fmt.Errorf("%w: expected %s, got %s", ErrReplayMismatch, commandSummary(want), commandSummary(p))
+	}
+	rp.records = rp.records[1:]
+	rp.queueResponses()
+	return len(p), nil
+}
+
+// commandSummary describes a command by its command byte and length, leaving
+// out the track data it may carry
+func commandSummary(p []byte) string {
+	if len(p) < 2 || p[0] != EscapeCode[0] {
+		return fmt.Sprintf("%d bytes", len(p))
+	}
+	return fmt.Sprintf("command %q (%d bytes)", p[1], len(p))
+}
+
+// queueResponses moves received records up to the next command into pending
+func (rp *Replayer) queueResponses() {
+	for len(rp.records) > 0 && rp.records[0].Dir == Received.String() {
+		data, _ := hex.DecodeString(rp.records[0].Data)
+		rp.pending = append(rp.pending, data...)
+		rp.records = rp.records[1:]
+	}
+}
+
+// Read returns recorded response bytes, or 0 after the read timeout
+func (rp *Replayer) Read(p []byte) (int, error) {
+	rp.mu.Lock()
+	if len(rp.pending) > 0 {
+		n := copy(p, rp.pending)
+		rp.pending = rp.pending[n:]
+		rp.mu.Unlock()
+		return n, nil
+	}
+	timeout := rp.timeout
+	rp.mu.Unlock()
+
+	if timeout <= 0 || timeout > 10*time.Millisecond {
+		timeout = 10 * time.Millisecond
+	}
+	time.Sleep(timeout)
+	return 0, nil
+}
+
+// SetReadTimeout sets how long Read waits when no data is queued
+func (rp *Replayer) SetReadTimeout(t time.Duration) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.timeout = t
+	return nil
+}
+
+// Close does nothing
+func (rp *Replayer) Close() error {
+	return nil
+}
+
+// Remaining returns the number of recorded commands not yet replayed
+func (rp *Replayer) Remaining() int {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	n := 0
+	for _, rec := range rp.records {
+		if rec.Dir == Sent.String() {
+			n++
+		}
+	}
+	return n
+}
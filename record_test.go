@@ -0,0 +1,158 @@
+package magstripe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var session bytes.Buffer
+	sim := NewSimulator()
+	sim.SetCard(TrackData{Track1: "%B123^DOE/JOHN^99?", Track2: ";123=99?"})
+
+	m, err := NewMSRPort(NewRecorder(sim, &session), WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+	recorded, err := m.ReadTracks()
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if err := m.SetCoercivity(LoCo); err != nil {
+		t.Fatalf("SetCoercivity: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	replayer, err := NewReplayer(&session)
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+	m, err = NewMSRPort(replayer, WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+	replayed, err := m.ReadTracks()
+	if err != nil {
+		t.Fatalf("replayed ReadTracks: %v", err)
+	}
+	if *replayed != *recorded {
+		t.Errorf("replay mismatch: recorded %+v, replayed %+v", recorded, replayed)
+	}
+	if err := m.SetCoercivity(LoCo); err != nil {
+		t.Errorf("replayed SetCoercivity: %v", err)
+	}
+	if replayer.Remaining() != 0 {
+		t.Errorf("expected session to be fully replayed, %d commands left", replayer.Remaining())
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	session := `{"at":0,"dir":"tx","data":"1b72"}` + "\n" + `{"at":1,"dir":"rx","data":"1b30"}` + "\n"
+	replayer, err := NewReplayer(strings.NewReader(session))
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+	m, _ := NewMSRPort(replayer, WithoutInitialReset(), WithCommandTimeout(100*time.Millisecond))
+
+	if err := m.SetCoercivity(HiCo); !errors.Is(err, ErrReplayMismatch) {
+		t.Errorf("expected ErrReplayMismatch, got %v", err)
+	}
+}
+
+func TestReplayMismatchRedacted(t *testing.T) {
+	want := EscapeCode + "w" + encodeISODataBlock("", testBankTrack2, "")
+	session := fmt.Sprintf(`{"at":0,"dir":"tx","data":%q}`, hex.EncodeToString([]byte(want))) + "\n"
+	replayer, err := NewReplayer(strings.NewReader(session))
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+
+	got := strings.Replace(want, "4111", "4222", 1)
+	_, err = replayer.Write([]byte(got))
+	if !errors.Is(err, ErrReplayMismatch) {
+		t.Fatalf("expected ErrReplayMismatch, got %v", err)
+	}
+	if strings.Contains(err.Error(), "4111") || strings.Contains(err.Error(), "4222") {
+		t.Errorf("mismatch error shows the card number: %v", err)
+	}
+	if !strings.Contains(err.Error(), `command 'w'`) {
+		t.Errorf("mismatch error should name the command: %v", err)
+	}
+}
+
+func TestReplayInvalidSession(t *testing.T) {
+	for _, session := range []string{
+		"not json\n",
+		`{"at":0,"dir":"sideways","data":""}` + "\n",
+		`{"at":0,"dir":"tx","data":"zz"}` + "\n",
+	} {
+		if _, err := NewReplayer(strings.NewReader(session)); err == nil {
+			t.Errorf("expected error for session %q", session)
+		}
+	}
+}
+
+// TestReplayCorpus replays the sessions captured from devices in testdata/sessions
+func TestReplayCorpus(t *testing.T) {
+	expected := map[string][]struct {
+		tracks *TrackData
+		status byte
+	}{
+		"msr605_read_iso.jsonl": {
+			{tracks: &TrackData{
+				Track1: "%B4111111111111111^DOE/JOHN^2512101000000000000?",
+				Track2: ";4111111111111111=25121010000000000000?",
+			}},
+			{status: '1'},
+		},
+	}
+
+	files, err := filepath.Glob("testdata/sessions/*.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no recorded sessions found")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			reads, ok := expected[filepath.Base(file)]
+			if !ok {
+				t.Fatalf("no expectations for %s", file)
+			}
+			replayer, err := OpenReplay(file)
+			if err != nil {
+				t.Fatalf("OpenReplay: %v", err)
+			}
+			m, err := NewMSRPort(replayer, WithCommandTimeout(200*time.Millisecond))
+			if err != nil {
+				t.Fatalf("NewMSRPort: %v", err)
+			}
+
+			for i, want := range reads {
+				got, err := m.ReadTracks()
+				if want.status != 0 {
+					var statusErr *StatusError
+					if !errors.As(err, &statusErr) || statusErr.Status != want.status {
+						t.Errorf("read %d: expected status %c, got %v", i, want.status, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("read %d: %v", i, err)
+				}
+				if *got != *want.tracks {
+					t.Errorf("read %d: expected %+v, got %+v", i, want.tracks, got)
+				}
+			}
+		})
+	}
+}
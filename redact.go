@@ -0,0 +1,282 @@
+package magstripe
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"unsafe"
+)
+
+// Redact is a policy for hiding sensitive card data in output and logs
+type Redact int
+
+const (
+	// RedactPCI keeps the first six and last four digits of card numbers and
+	// masks discretionary data, where CVV and PVV values are found. It is the
+	// default of an MSR.
+	RedactPCI Redact = iota
+	// RedactNone shows card data in clear
+	RedactNone
+)
+
+// maskChar replaces redacted characters; masking keeps lengths and positions
+const maskChar = '*'
+
+// WithRedaction sets the policy applied to card data in trace events and error
+// messages (default RedactPCI)
+func WithRedaction(p Redact) Option {
+	return func(c *config) {
+		c.redact = p
+	}
+}
+
+// MaskPAN keeps the first six and last four digits of a card number. Numbers
+// of ten digits or less keep only their last four.
+func MaskPAN(pan string) string {
+	b := []byte(pan)
+	maskPAN(b)
+	return string(b)
+}
+
+// maskPAN masks a card number in place as MaskPAN does
+func maskPAN(pan []byte) {
+	keepStart, keepEnd := 6, 4
+	if len(pan) <= keepStart+keepEnd {
+		keepStart = 0
+	}
+	if len(pan) <= keepEnd {
+		keepEnd = 0
+	}
+	mask(pan[keepStart : len(pan)-keepEnd])
+}
+
+// mask replaces every byte of b in place
+func mask(b []byte) {
+	for i := range b {
+		b[i] = maskChar
+	}
+}
+
+// Field redacts the value of a field named as by TrackFields or Card.Fields:
+// card numbers keep their first six and last four digits, discretionary and
+// control data is masked, and in other values long runs of digits are masked
+// as in Track
+func (p Redact) Field(name, value string) string {
+	if p == RedactNone {
+		return value
+	}
+	b := []byte(value)
+	maskField(name, b)
+	return string(b)
+}
+
+// maskField redacts the value of a field in place as Field does with RedactPCI
+func maskField(name string, value []byte) {
+	switch name {
+	case "pan":
+		maskPAN(value)
+	case "discretionary", "control", "data":
+		mask(value)
+	default:
+		maskDigitRuns(value)
+	}
+}
+
+// Track redacts the data of one track. ISO 7813 tracks are redacted field by
+// field and AAMVA tracks, which hold no payment data, are kept. In other
+// tracks runs of 12 to 19 digits are taken for card numbers, and longer runs
+// are masked.
+func (p Redact) Track(data string) string {
+	if p == RedactNone {
+		return data
+	}
+	b := []byte(data)
+	maskTrack(b)
+	return string(b)
+}
+
+// maskTrack redacts the data of one track in place as Track does with
+// RedactPCI. TrackFields only slices the string it is given, so the track is
+// parsed through a string viewing data, which is dropped before data is
+// masked, rather than through a copy that could not be wiped.
+func maskTrack(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	view := unsafe.String(&data[0], len(data))
+	for _, track := range []int{1, 2} {
+		layout, fields := TrackFields(track, view)
+		switch layout {
+		case LayoutAAMVA:
+			return
+		case LayoutISO7813:
+			for _, f := range fields {
+				maskField(f.Name, data[f.Start:f.End])
+			}
+			return
+		}
+	}
+	maskDigitRuns(data)
+}
+
+// maskDigitRuns masks card numbers and long numeric fields in free text in
+// place
+func maskDigitRuns(b []byte) {
+	for i := 0; i < len(b); {
+		j := i
+		for j < len(b) && b[j] >= '0' && b[j] <= '9' {
+			j++
+		}
+		switch n := j - i; {
+		case n == 0:
+			j++
+		case n > 19:
+			mask(b[i:j])
+		case n >= 12:
+			maskPAN(b[i:j])
+		}
+		i = j
+	}
+}
+
+// Text redacts the tracks found in text that may also hold control bytes, such
+// as an ISO datablock: each run of printable characters is redacted as a track
+func (p Redact) Text(s string) string {
+	if p == RedactNone {
+		return s
+	}
+	b := []byte(s)
+	maskText(b)
+	return string(b)
+}
+
+// maskText redacts text in place as Text does with RedactPCI
+func maskText(b []byte) {
+	for i := 0; i < len(b); {
+		j := i
+		for j < len(b) && b[j] >= 0x20 && b[j] < 0x7f {
+			j++
+		}
+		if j == i {
+			i++
+			continue
+		}
+		maskTrack(b[i:j])
+		i = j
+	}
+}
+
+// wire returns a redacted copy of the bytes of a command or response. The
+// copy is masked in place, so no clear copy of data is left behind. The tracks
+// of a raw datablock cannot be parsed, so all their bytes are masked.
+func (p Redact) wire(data []byte, raw bool) []byte {
+	data = append([]byte(nil), data...)
+	if p == RedactNone {
+		return data
+	}
+	if !raw {
+		maskText(data)
+		return data
+	}
+
+	start := bytes.Index(data, []byte(EscapeCode+"s"))
+	if start == -1 {
+		return data
+	}
+	pos := start + 2
+	for i := 0; i < 3; i++ {
+		if pos+3 > len(data) || data[pos] != EscapeCode[0] {
+			// malformed: mask everything left
+			mask(data[pos:])
+			break
+		}
+		end := pos + 3 + int(data[pos+2])
+		if end > len(data) {
+			end = len(data)
+		}
+		mask(data[pos+3 : end])
+		pos = end
+	}
+	return data
+}
+
+// block quotes a datablock for an error message. Raw datablocks are
+// reduced to their length unless p is RedactNone.
+func (p Redact) block(data string, raw bool) string {
+	if p == RedactNone {
+		return fmt.Sprintf("%q", data)
+	}
+	if raw {
+		return fmt.Sprintf("%d bytes", len(data))
+	}
+	return fmt.Sprintf("%q", p.Text(data))
+}
+
+// Redact returns a copy of the tracks redacted with policy p
+func (t TrackData) Redact(p Redact) TrackData {
+	return TrackData{Track1: p.Track(t.Track1), Track2: p.Track(t.Track2), Track3: p.Track(t.Track3)}
+}
+
+// String formats the tracks redacted with RedactPCI, so that printing a
+// TrackData never shows a full card number
+func (t TrackData) String() string {
+	r := t.Redact(RedactPCI)
+	return fmt.Sprintf("{Track1:%q Track2:%q Track3:%q}", r.Track1, r.Track2, r.Track3)
+}
+
+// LogValue logs the tracks redacted with RedactPCI
+func (t TrackData) LogValue() slog.Value {
+	r := t.Redact(RedactPCI)
+	return slog.GroupValue(
+		slog.String("track1", r.Track1),
+		slog.String("track2", r.Track2),
+		slog.String("track3", r.Track3),
+	)
+}
+
+// Redact returns a copy of the track with Data redacted with policy p. Raw
+// bytes encode the same data and are dropped unless p is RedactNone.
+func (t Track) Redact(p Redact) Track {
+	t.Data = p.Track(t.Data)
+	if p != RedactNone {
+		t.Raw = nil
+	}
+	return t
+}
+
+// String formats the track redacted with RedactPCI, with the length of its raw
+// data only
+func (t Track) String() string {
+	return fmt.Sprintf("{Number:%d Status:%s Data:%q Raw:%d bytes BPC:%d Parity errors:%d LRC:%s}",
+		t.Number, t.Status, RedactPCI.Track(t.Data), len(t.Raw), t.BPC, len(t.ParityErrors), t.LRC)
+}
+
+// LogValue logs the track redacted with RedactPCI
+func (t Track) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("number", t.Number),
+		slog.String("status", t.Status.String()),
+		slog.String("data", RedactPCI.Track(t.Data)),
+		slog.Int("raw_bytes", len(t.Raw)),
+		slog.Int("parity_errors", len(t.ParityErrors)),
+		slog.String("lrc", t.LRC.String()),
+	)
+}
+
+// Redact returns a copy of the diff with the tracks and field values redacted
+// with policy p
+func (d *CardDiff) Redact(p Redact) *CardDiff {
+	r := *d
+	for i := range r.Tracks {
+		t := &r.Tracks[i]
+		t.A, t.B = p.Track(t.A), p.Track(t.B)
+		if t.Fields != nil {
+			fields := make([]FieldDiff, len(t.Fields))
+			for j, f := range t.Fields {
+				fields[j] = FieldDiff{Name: f.Name, A: p.Field(f.Name, f.A), B: p.Field(f.Name, f.B)}
+			}
+			t.Fields = fields
+		}
+	}
+	return &r
+}
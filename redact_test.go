@@ -0,0 +1,210 @@
+package magstripe
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaskPAN(t *testing.T) {
+	for pan, want := range map[string]string{
+		"4111111111111111":    "411111******1111",
+		"5500000000000004":    "550000******0004",
+		"4222222222222":       "422222***2222",
+		"1234567890123456789": "123456*********6789",
+		"1234567890":          "******7890",
+		"1234":                "****",
+		"":                    "",
+	} {
+		if got := MaskPAN(pan); got != want {
+			t.Errorf("MaskPAN(%q) = %q, expected %q", pan, got, want)
+		}
+	}
+}
+
+func TestRedactTrack(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"iso track 1", testBankTrack1, "%B411111******1111^DOE/JOHN^2512101*********?"},
+		{"iso track 2", testBankTrack2, ";411111******1111=2512101*********?"},
+		{"iso track 2 without discretionary", ";4111111111111111=2512101?", ";411111******1111=2512101?"},
+		{"aamva kept", testLicenseTrack2, testLicenseTrack2},
+		{"iso 4909 parses as track 2", testISO4909Track3, ";014111********1111=8408402" + strings.Repeat("*", 36) + "?"},
+		{"unknown layout", ";4111111111111111?", ";411111******1111?"},
+		{"short numbers kept", ";12345678901?", ";12345678901?"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactPCI.Track(tt.data); got != tt.want {
+				t.Errorf("RedactPCI.Track(%q)\n got %q\nwant %q", tt.data, got, tt.want)
+			}
+			if got := RedactNone.Track(tt.data); got != tt.data {
+				t.Errorf("RedactNone.Track(%q) = %q", tt.data, got)
+			}
+		})
+	}
+}
+
+func TestRedactField(t *testing.T) {
+	tests := []struct {
+		name, value, want string
+	}{
+		{"pan", "4111111111111111", "411111******1111"},
+		{"discretionary", "123456", "******"},
+		{"control", "2000", "****"},
+		{"name", "DOE/JOHN", "DOE/JOHN"},
+		{"expiry", "2512", "2512"},
+	}
+	for _, tt := range tests {
+		if got := RedactPCI.Field(tt.name, tt.value); got != tt.want {
+			t.Errorf("Field(%q, %q) = %q, expected %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRedactTrackData(t *testing.T) {
+	tracks := TrackData{Track1: testBankTrack1, Track2: testBankTrack2}
+
+	for _, out := range []string{
+		fmt.Sprint(tracks),
+		fmt.Sprintf("%v", &tracks),
+		fmt.Sprintf("%+v", tracks),
+	} {
+		if strings.Contains(out, "4111111111111111") || !strings.Contains(out, "411111******1111") {
+			t.Errorf("tracks not redacted: %s", out)
+		}
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("swipe", "tracks", tracks)
+	if out := buf.String(); strings.Contains(out, "4111111111111111") || !strings.Contains(out, "tracks.track2=") {
+		t.Errorf("log not redacted: %s", out)
+	}
+
+	if r := tracks.Redact(RedactNone); r != tracks {
+		t.Errorf("RedactNone changed the tracks: %+v", r.Track2)
+	}
+}
+
+func TestRedactTrackResult(t *testing.T) {
+	track := Track{Number: 2, Status: TrackPresent, Data: testBankTrack2, Raw: []byte(testBankTrack2)}
+
+	results := TrackResults{1: track}
+	for _, out := range []string{fmt.Sprint(track), fmt.Sprintf("%+v", &results)} {
+		if strings.Contains(out, "4111111111111111") || !strings.Contains(out, "411111******1111") {
+			t.Errorf("track not redacted: %s", out)
+		}
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("swipe", "track", track)
+	if out := buf.String(); strings.Contains(out, "4111111111111111") || !strings.Contains(out, "track.status=present") {
+		t.Errorf("log not redacted: %s", out)
+	}
+
+	if r := track.Redact(RedactPCI); r.Raw != nil {
+		t.Errorf("raw data kept: %q", r.Raw)
+	}
+	if r := track.Redact(RedactNone); r.Data != track.Data || len(r.Raw) != len(track.Raw) {
+		t.Errorf("RedactNone changed the track: %v", r)
+	}
+}
+
+func TestRedactTrace(t *testing.T) {
+	card := TrackData{Track1: testBankTrack1, Track2: testBankTrack2}
+	for _, tt := range []struct {
+		policy Redact
+		clear  bool
+	}{
+		{RedactPCI, false},
+		{RedactNone, true},
+	} {
+		var events []TraceEvent
+		sim := NewSimulator()
+		sim.SetCard(card)
+		m, err := NewMSRPort(sim, WithoutInitialReset(), WithCommandTimeout(200*time.Millisecond),
+			WithRedaction(tt.policy),
+			WithTracer(TracerFunc(func(ev TraceEvent) {
+				events = append(events, ev)
+			})))
+		if err != nil {
+			t.Fatalf("NewMSRPort: %v", err)
+		}
+		if _, err := m.ReadTracks(); err != nil {
+			t.Fatalf("ReadTracks: %v", err)
+		}
+		if err := m.WriteTracks(card.Track1, card.Track2, ""); err != nil {
+			t.Fatalf("WriteTracks: %v", err)
+		}
+		if err := m.WriteRawTracks("", "\x0b\x01\x02\x1f", ""); err != nil {
+			t.Fatalf("WriteRawTracks: %v", err)
+		}
+		if _, _, _, err := m.ReadRawTracks(); err != nil {
+			t.Fatalf("ReadRawTracks: %v", err)
+		}
+
+		var all []byte
+		for _, ev := range events {
+			all = append(all, ev.Data...)
+		}
+		if got := bytes.Contains(all, []byte("4111111111111111")); got != tt.clear {
+			t.Errorf("policy %d: clear PAN in trace %v", tt.policy, got)
+		}
+		if got := bytes.Contains(all, []byte("\x0b\x01\x02\x1f")); got != tt.clear {
+			t.Errorf("policy %d: clear raw track in trace %v", tt.policy, got)
+		}
+		if !tt.clear && !bytes.Contains(all, []byte("\x1bs\x1b\x01%B411111******1111^")) {
+			t.Errorf("policy %d: redacted datablock not found in %q", tt.policy, all)
+		}
+		if sim.Card() != card {
+			t.Errorf("redaction altered the data written")
+		}
+	}
+}
+
+func TestRedactErrors(t *testing.T) {
+	block := "\x1bs\x1b\x01" + testBankTrack1 + "\x1b\x02" + testBankTrack2
+	_, _, _, err := decodeISODataBlock(block, RedactPCI)
+	if err == nil || strings.Contains(err.Error(), "4111111111111111") || !strings.Contains(err.Error(), "411111******1111") {
+		t.Errorf("datablock error not redacted: %v", err)
+	}
+	_, _, _, err = decodeISODataBlock(block, RedactNone)
+	if err == nil || !strings.Contains(err.Error(), "4111111111111111") {
+		t.Errorf("datablock error redacted with RedactNone: %v", err)
+	}
+
+	_, _, _, err = decodeRawDataBlock("\x1bs\x1b\x01\x02ab\x1b\x02\x00\x1b\x03\x00xx", RedactPCI)
+	if err == nil || strings.Contains(err.Error(), "xx") {
+		t.Errorf("raw datablock error not redacted: %v", err)
+	}
+
+	err = &SwipeError{Data: testBankTrack2}
+	if strings.Contains(err.Error(), "4111111111111111") {
+		t.Errorf("swipe error not redacted: %v", err)
+	}
+}
+
+func TestRedactCardDiff(t *testing.T) {
+	a := TrackData{Track2: testBankTrack2}
+	b := TrackData{Track2: ";4111111111111111=2612101000000000?"}
+	orig := Diff(&a, &b)
+	d := orig.Redact(RedactPCI)
+
+	var buf bytes.Buffer
+	if err := d.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	if out := buf.String(); strings.Contains(out, "4111111111111111") || !strings.Contains(out, `expiry: "2512" != "2612"`) {
+		t.Errorf("unexpected redacted diff:\n%s", out)
+	}
+	if orig.Tracks[1].A != testBankTrack2 || orig.Tracks[1].Fields[0].A != "2512" {
+		t.Error("Redact modified the original diff")
+	}
+}
@@ -0,0 +1,243 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abrahan/magstripe-go"
+)
+
+// Client talks to a Server and implements magstripe.CardReadWriter and
+// magstripe.Configurer, so code written against a local device works
+// unchanged with a remote one
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+var (
+	_ magstripe.CardReadWriter = (*Client)(nil)
+	_ magstripe.Configurer     = (*Client)(nil)
+)
+
+// NewClient creates a client for the server at baseURL (e.g. "http://host:8605").
+// A nil httpClient uses http.DefaultClient.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), http: httpClient}
+}
+
+// Close releases idle connections
+func (c *Client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// ReadTracks waits for a swipe on the remote device
+func (c *Client) ReadTracks() (*magstripe.TrackData, error) {
+	var msg tracksMessage
+	if err := c.call(pathRead, nil, &msg); err != nil {
+		return nil, err
+	}
+	return &magstripe.TrackData{Track1: msg.Track1, Track2: msg.Track2, Track3: msg.Track3}, nil
+}
+
+// ReadRawTracks waits for a swipe on the remote device and returns the raw data
+func (c *Client) ReadRawTracks() (string, string, string, error) {
+	var msg rawTracksMessage
+	if err := c.call(pathReadRaw, nil, &msg); err != nil {
+		return "", "", "", err
+	}
+	return string(msg.Track1), string(msg.Track2), string(msg.Track3), nil
+}
+
+// WriteTracks writes ISO tracks on the remote device
+func (c *Client) WriteTracks(t1, t2, t3 string) error {
+	return c.call(pathWrite, tracksMessage{Track1: t1, Track2: t2, Track3: t3}, nil)
+}
+
+// WriteRawTracks writes raw tracks on the remote device
+func (c *Client) WriteRawTracks(t1, t2, t3 string) error {
+	return c.call(pathWriteRaw, rawTracksMessage{Track1: []byte(t1), Track2: []byte(t2), Track3: []byte(t3)}, nil)
+}
+
+// EraseTracks erases tracks on the remote device
+func (c *Client) EraseTracks(t1, t2, t3 bool) error {
+	return c.call(pathErase, eraseMessage{Track1: t1, Track2: t2, Track3: t3}, nil)
+}
+
+// Configure applies settings on the remote device
+func (c *Client) Configure(s magstripe.Settings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	return c.call(pathSettings, newSettingsMessage(s), nil)
+}
+
+// Settings returns the settings of the remote device
+func (c *Client) Settings() (magstripe.Settings, error) {
+	var msg settingsMessage
+	if err := c.get(pathSettings, &msg); err != nil {
+		return magstripe.Settings{}, err
+	}
+	return msg.settings()
+}
+
+// SetCoercivity sets the coercivity of the remote device
+//
+// Deprecated: use Configure with CoercivityHigh or CoercivityLow.
+func (c *Client) SetCoercivity(hico bool) error {
+	coercivity := magstripe.CoercivityLow
+	if hico {
+		coercivity = magstripe.CoercivityHigh
+	}
+	return c.Configure(magstripe.Settings{Coercivity: coercivity})
+}
+
+// SetBPC sets the bits per character of the remote device
+//
+// Deprecated: use Configure, which validates the BPC.
+func (c *Client) SetBPC(bpc1, bpc2, bpc3 int) error {
+	var s magstripe.Settings
+	for i, bpc := range []int{bpc1, bpc2, bpc3} {
+		if bpc == 0 {
+			return fmt.Errorf("%w: track %d BPC 0 is not between 5 and 8", magstripe.ErrInvalidSetting, i+1)
+		}
+		s.Tracks[i].BPC = magstripe.BPC(bpc)
+	}
+	return c.Configure(s)
+}
+
+// SetBPI sets the bits per inch of the remote device
+//
+// Deprecated: use Configure with BPI210 or BPI75.
+func (c *Client) SetBPI(bpi1, bpi2, bpi3 *bool) error {
+	var s magstripe.Settings
+	for i, hi := range []*bool{bpi1, bpi2, bpi3} {
+		switch {
+		case hi == nil:
+		case *hi:
+			s.Tracks[i].BPI = magstripe.BPI210
+		default:
+			s.Tracks[i].BPI = magstripe.BPI75
+		}
+	}
+	return c.Configure(s)
+}
+
+// Watch streams swipes from the remote device until ctx is cancelled or the
+// connection drops
+func (c *Client) Watch(ctx context.Context) <-chan magstripe.Swipe {
+	swipes := make(chan magstripe.Swipe)
+	go func() {
+		defer close(swipes)
+		send := func(s magstripe.Swipe) bool {
+			select {
+			case swipes <- s:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+pathSwipes, nil)
+		if err != nil {
+			send(magstripe.Swipe{Err: err, Time: time.Now()})
+			return
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				send(magstripe.Swipe{Err: err, Time: time.Now()})
+			}
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			send(magstripe.Swipe{Err: responseError(resp), Time: time.Now()})
+			return
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var msg swipeMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				send(magstripe.Swipe{Err: fmt.Errorf("invalid swipe message: %w", err), Time: time.Now()})
+				return
+			}
+			swipe := magstripe.Swipe{}
+			swipe.Time, _ = time.Parse(time.RFC3339Nano, msg.Time)
+			if msg.Error != nil {
+				swipe.Err = decodeError(msg.Error)
+			} else if msg.Tracks != nil {
+				swipe.Tracks = &magstripe.TrackData{Track1: msg.Tracks.Track1, Track2: msg.Tracks.Track2, Track3: msg.Tracks.Track3}
+			}
+			if !send(swipe) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			send(magstripe.Swipe{Err: err, Time: time.Now()})
+		}
+	}()
+	return swipes
+}
+
+// call posts req as JSON and decodes the response into resp if it is not nil
+func (c *Client) call(path string, req, resp any) error {
+	var body bytes.Buffer
+	if req != nil {
+		if err := json.NewEncoder(&body).Encode(req); err != nil {
+			return err
+		}
+	}
+
+	httpResp, err := c.http.Post(c.baseURL+path, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(httpResp, resp)
+}
+
+// get fetches path and decodes the response into resp
+func (c *Client) get(path string, resp any) error {
+	httpResp, err := c.http.Get(c.baseURL + path)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(httpResp, resp)
+}
+
+// decodeResponse decodes a response into resp if it is not nil, or the error
+// it carries
+func decodeResponse(httpResp *http.Response, resp any) error {
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode >= 300 {
+		return responseError(httpResp)
+	}
+	if resp != nil {
+		if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	return nil
+}
+
+// responseError decodes the error carried by a failed response
+func responseError(resp *http.Response) error {
+	var msg errorMessage
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil || msg.Code == "" {
+		return errors.New("remote: " + resp.Status)
+	}
+	return decodeError(&msg)
+}
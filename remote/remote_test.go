@@ -0,0 +1,298 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abrahan/magstripe-go"
+)
+
+func newTestServer(t *testing.T) (*Client, *magstripe.Simulator) {
+	t.Helper()
+	sim := magstripe.NewSimulator()
+	dev, err := magstripe.NewMSRPort(sim, magstripe.WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+
+	srv := NewServer(dev, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+	return NewClient(ts.URL, nil), sim
+}
+
+func TestClientReadWrite(t *testing.T) {
+	client, sim := newTestServer(t)
+
+	if err := client.WriteTracks("%B123?", ";123?", ""); err != nil {
+		t.Fatalf("WriteTracks: %v", err)
+	}
+	if got := sim.Card(); got.Track1 != "%B123?" {
+		t.Errorf("card not written: %+v", got)
+	}
+
+	tracks, err := client.ReadTracks()
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if tracks.Track2 != ";123?" {
+		t.Errorf("unexpected tracks: %+v", tracks)
+	}
+
+	if err := client.EraseTracks(true, true, true); err != nil {
+		t.Fatalf("EraseTracks: %v", err)
+	}
+	if got := sim.Card(); got != (magstripe.TrackData{}) {
+		t.Errorf("card not erased: %+v", got)
+	}
+}
+
+func TestClientRaw(t *testing.T) {
+	client, _ := newTestServer(t)
+
+	if err := client.WriteRawTracks("\x00\xff", "", ""); err != nil {
+		t.Fatalf("WriteRawTracks: %v", err)
+	}
+	data, _, _, err := client.ReadRawTracks()
+	if err != nil {
+		t.Fatalf("ReadRawTracks: %v", err)
+	}
+	if len(data) == 0 {
+		t.Error("raw data should not be empty")
+	}
+}
+
+func TestClientConfigure(t *testing.T) {
+	client, sim := newTestServer(t)
+
+	if err := client.SetCoercivity(magstripe.LoCo); err != nil {
+		t.Fatalf("SetCoercivity: %v", err)
+	}
+	if sim.Coercivity() != magstripe.LoCo {
+		t.Error("coercivity not applied")
+	}
+	if err := client.SetBPC(8, 8, 8); err != nil {
+		t.Fatalf("SetBPC: %v", err)
+	}
+	hi := magstripe.HiBPI
+	if err := client.SetBPI(&hi, nil, &hi); err != nil {
+		t.Fatalf("SetBPI: %v", err)
+	}
+	if err := client.SetBPC(12, 8, 8); !errors.Is(err, magstripe.ErrInvalidSetting) {
+		t.Errorf("expected ErrInvalidSetting, got %v", err)
+	}
+
+	err := client.Configure(magstripe.Settings{
+		Coercivity: magstripe.CoercivityHigh,
+		Tracks:     [3]magstripe.TrackSettings{{BPI: magstripe.BPI75, BPC: 7}},
+	})
+	if err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	want := magstripe.Settings{
+		Coercivity: magstripe.CoercivityHigh,
+		Tracks:     [3]magstripe.TrackSettings{{BPI: magstripe.BPI75, BPC: 7}, {BPC: 8}, {BPI: magstripe.BPI210, BPC: 8}},
+	}
+	if s, err := client.Settings(); err != nil || s != want {
+		t.Errorf("Settings = %+v, %v, expected %+v", s, err, want)
+	}
+
+	// the server checks settings from clients that do not
+	resp, err := http.Post(client.baseURL+pathSettings, "application/json", strings.NewReader(`{"tracks":[{"bpc":12},{},{}]}`))
+	if err != nil {
+		t.Fatalf("POST %s: %v", pathSettings, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid settings answered %s", resp.Status)
+	}
+
+	// a device without the settings API hides them behind CardReadWriter
+	dev, _ := magstripe.NewMSRPort(magstripe.NewSimulator())
+	srv := NewServer(struct{ magstripe.CardReadWriter }{dev}, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ts := httptest.NewServer(srv)
+	defer func() {
+		ts.Close()
+		srv.Close()
+	}()
+	if _, err := NewClient(ts.URL, nil).Settings(); !errors.Is(err, magstripe.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	client, sim := newTestServer(t)
+
+	sim.FailNext('1')
+	var statusErr *magstripe.StatusError
+	if _, err := client.ReadTracks(); !errors.As(err, &statusErr) || statusErr.Status != '1' || statusErr.Op != "read" {
+		t.Errorf("expected status error, got %v", err)
+	}
+
+	sim.RemoveCard()
+	if _, err := client.ReadTracks(); !errors.Is(err, magstripe.ErrTimeout) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+func TestClientUnsupported(t *testing.T) {
+	sim := magstripe.NewSimulator()
+	dev, _ := magstripe.NewMSRPort(sim, magstripe.WithProfile(magstripe.ProfileReadOnly))
+	srv := NewServer(dev, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer srv.Close()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	err := NewClient(ts.URL, nil).WriteTracks("", ";1?", "")
+	if !errors.Is(err, magstripe.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestClientDisconnected(t *testing.T) {
+	sim := magstripe.NewSimulator()
+	dev, _ := magstripe.NewMSRPort(sim, magstripe.WithReconnect(magstripe.ReconnectPolicy{Open: sim.Open}))
+	srv := NewServer(dev, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer srv.Close()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	sim.Unplug()
+	if _, err := NewClient(ts.URL, nil).ReadTracks(); !errors.Is(err, magstripe.ErrDisconnected) {
+		t.Errorf("expected ErrDisconnected, got %v", err)
+	}
+}
+
+func TestClientQueue(t *testing.T) {
+	client, _ := newTestServer(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.ReadTracks()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("queued read failed: %v", err)
+		}
+	}
+}
+
+// blockingDevice is a device whose reads wait for release
+type blockingDevice struct {
+	magstripe.CardReadWriter
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *blockingDevice) ReadTracks() (*magstripe.TrackData, error) {
+	d.started <- struct{}{}
+	<-d.release
+	return &magstripe.TrackData{}, nil
+}
+
+func TestServerQueue(t *testing.T) {
+	dev := &blockingDevice{started: make(chan struct{}, 8), release: make(chan struct{})}
+	srv := NewServer(dev, 1, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer srv.Close()
+	read := func(ctx context.Context) error {
+		return srv.do(ctx, func() error {
+			_, err := dev.ReadTracks()
+			return err
+		})
+	}
+
+	running := make(chan error, 1)
+	go func() { running <- read(context.Background()) }()
+	<-dev.started
+	for len(srv.slots) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a client that goes away stops waiting, even while its request runs
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan error, 1)
+	go func() { waiting <- read(ctx) }()
+	for len(srv.slots) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// the one waiting request fills the queue
+	if err := read(context.Background()); !errors.Is(err, errBusy) {
+		t.Errorf("expected errBusy beyond the queue size, got %v", err)
+	}
+	cancel()
+	if err := <-waiting; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled request to stop waiting, got %v", err)
+	}
+
+	close(dev.release)
+	if err := <-running; err != nil {
+		t.Errorf("running request: %v", err)
+	}
+}
+
+func TestServerQueueUnbounded(t *testing.T) {
+	dev := &blockingDevice{started: make(chan struct{}, 32), release: make(chan struct{})}
+	srv := NewServer(dev, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer srv.Close()
+
+	errs := make(chan error, 32)
+	for i := 0; i < 32; i++ {
+		go func() {
+			errs <- srv.do(context.Background(), func() error {
+				_, err := dev.ReadTracks()
+				return err
+			})
+		}()
+	}
+	<-dev.started
+	close(dev.release)
+	for i := 0; i < 32; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("queued request failed: %v", err)
+		}
+	}
+}
+
+func TestClientWatch(t *testing.T) {
+	client, sim := newTestServer(t)
+	sim.SetCard(magstripe.TrackData{Track2: ";42?"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	swipes := client.Watch(ctx)
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-swipes:
+			if s.Err != nil || s.Tracks.Track2 != ";42?" {
+				t.Errorf("unexpected swipe: %+v", s)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for swipe")
+		}
+	}
+
+	cancel()
+	for range swipes {
+	}
+}
@@ -0,0 +1,365 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/abrahan/magstripe-go"
+)
+
+// Server serves a device over HTTP/JSON. Requests are queued and executed one
+// at a time in arrival order, since the device handles a single command at once.
+type Server struct {
+	dev  magstripe.CardReadWriter
+	jobs chan job
+	// slots holds a token for each request waiting for the device, nil when
+	// their number is not limited
+	slots  chan struct{}
+	mux    *http.ServeMux
+	logger *slog.Logger
+	done   chan struct{}
+}
+
+// job is a queued device operation
+type job struct {
+	ctx    context.Context
+	run    func() error
+	result chan error
+}
+
+// NewServer creates a server for dev. Requests wait for the device for as long
+// as their clients do; a queueSize above zero limits the number of waiting
+// requests, and requests beyond it fail as busy. Call Close to stop the queue
+// worker.
+func NewServer(dev magstripe.CardReadWriter, queueSize int, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	s := &Server{
+		dev:    dev,
+		jobs:   make(chan job),
+		mux:    http.NewServeMux(),
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+	if queueSize > 0 {
+		s.slots = make(chan struct{}, queueSize)
+	}
+
+	s.mux.HandleFunc(pathRead, s.handleRead)
+	s.mux.HandleFunc(pathReadRaw, s.handleReadRaw)
+	s.mux.HandleFunc(pathWrite, s.handleWrite)
+	s.mux.HandleFunc(pathWriteRaw, s.handleWriteRaw)
+	s.mux.HandleFunc(pathErase, s.handleErase)
+	s.mux.HandleFunc(pathCoercivity, s.handleCoercivity)
+	s.mux.HandleFunc(pathBPC, s.handleBPC)
+	s.mux.HandleFunc(pathBPI, s.handleBPI)
+	s.mux.HandleFunc(pathSettings, s.handleSettings)
+	s.mux.HandleFunc(pathSwipes, s.handleSwipes)
+
+	go s.work()
+	return s
+}
+
+// Close stops the queue worker; queued requests fail
+func (s *Server) Close() error {
+	close(s.done)
+	return nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// work executes queued jobs one at a time
+func (s *Server) work() {
+	for {
+		select {
+		case j := <-s.jobs:
+			if err := j.ctx.Err(); err != nil {
+				j.result <- err
+				continue
+			}
+			j.result <- j.run()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// do queues run and waits for it to complete, or for ctx to be done. A run
+// that has started when ctx is done completes without its caller.
+func (s *Server) do(ctx context.Context, run func() error) error {
+	if s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			return errBusy
+		}
+	}
+
+	j := job{ctx: ctx, run: run, result: make(chan error, 1)}
+	var err error
+	select {
+	case s.jobs <- j:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-s.done:
+		err = errClosed
+	}
+	if s.slots != nil {
+		<-s.slots
+	}
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-j.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.done:
+		return errClosed
+	}
+}
+
+func (s *Server) handleRead(w http.ResponseWriter, r *http.Request) {
+	if !s.post(w, r, nil) {
+		return
+	}
+	var result *tracksMessage
+	err := s.do(r.Context(), func() error {
+		tracks, err := s.dev.ReadTracks()
+		if err != nil {
+			return err
+		}
+		result = newTracksMessage(tracks)
+		return nil
+	})
+	s.reply(w, r, err, result)
+}
+
+func (s *Server) handleReadRaw(w http.ResponseWriter, r *http.Request) {
+	if !s.post(w, r, nil) {
+		return
+	}
+	var result *rawTracksMessage
+	err := s.do(r.Context(), func() error {
+		t1, t2, t3, err := s.dev.ReadRawTracks()
+		if err != nil {
+			return err
+		}
+		result = &rawTracksMessage{Track1: []byte(t1), Track2: []byte(t2), Track3: []byte(t3)}
+		return nil
+	})
+	s.reply(w, r, err, result)
+}
+
+func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
+	var msg tracksMessage
+	if !s.post(w, r, &msg) {
+		return
+	}
+	err := s.do(r.Context(), func() error {
+		return s.dev.WriteTracks(msg.Track1, msg.Track2, msg.Track3)
+	})
+	s.reply(w, r, err, nil)
+}
+
+func (s *Server) handleWriteRaw(w http.ResponseWriter, r *http.Request) {
+	var msg rawTracksMessage
+	if !s.post(w, r, &msg) {
+		return
+	}
+	err := s.do(r.Context(), func() error {
+		return s.dev.WriteRawTracks(string(msg.Track1), string(msg.Track2), string(msg.Track3))
+	})
+	s.reply(w, r, err, nil)
+}
+
+func (s *Server) handleErase(w http.ResponseWriter, r *http.Request) {
+	var msg eraseMessage
+	if !s.post(w, r, &msg) {
+		return
+	}
+	err := s.do(r.Context(), func() error {
+		return s.dev.EraseTracks(msg.Track1, msg.Track2, msg.Track3)
+	})
+	s.reply(w, r, err, nil)
+}
+
+// handleCoercivity, handleBPC and handleBPI serve clients from before
+// pathSettings
+func (s *Server) handleCoercivity(w http.ResponseWriter, r *http.Request) {
+	var msg coercivityMessage
+	if !s.post(w, r, &msg) {
+		return
+	}
+	err := s.do(r.Context(), func() error {
+		return s.dev.SetCoercivity(msg.HiCo)
+	})
+	s.reply(w, r, err, nil)
+}
+
+func (s *Server) handleBPC(w http.ResponseWriter, r *http.Request) {
+	var msg bpcMessage
+	if !s.post(w, r, &msg) {
+		return
+	}
+	err := s.do(r.Context(), func() error {
+		return s.dev.SetBPC(msg.BPC1, msg.BPC2, msg.BPC3)
+	})
+	s.reply(w, r, err, nil)
+}
+
+func (s *Server) handleBPI(w http.ResponseWriter, r *http.Request) {
+	var msg bpiMessage
+	if !s.post(w, r, &msg) {
+		return
+	}
+	err := s.do(r.Context(), func() error {
+		return s.dev.SetBPI(msg.BPI1, msg.BPI2, msg.BPI3)
+	})
+	s.reply(w, r, err, nil)
+}
+
+// handleSettings returns the settings of the device on GET and applies those
+// posted on POST
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	dev, ok := s.dev.(magstripe.Configurer)
+	if !ok {
+		s.reply(w, r, fmt.Errorf("settings: %w", magstripe.ErrUnsupported), nil)
+		return
+	}
+	if r.Method == http.MethodGet {
+		var result *settingsMessage
+		err := s.do(r.Context(), func() error {
+			settings, err := dev.Settings()
+			if err != nil {
+				return err
+			}
+			result = newSettingsMessage(settings)
+			return nil
+		})
+		s.reply(w, r, err, result)
+		return
+	}
+
+	var msg settingsMessage
+	if !s.post(w, r, &msg) {
+		return
+	}
+	settings, err := msg.settings()
+	if err == nil {
+		err = s.do(r.Context(), func() error {
+			return dev.Configure(settings)
+		})
+	}
+	s.reply(w, r, err, nil)
+}
+
+// handleSwipes streams swipes as newline delimited JSON until the client goes
+// away. Each wait for a swipe is queued separately so other requests can
+// use the device in between. A lost device is reported once until it is back.
+func (s *Server) handleSwipes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.fail(w, r, http.StatusMethodNotAllowed, codeBadRequest, "method not allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.fail(w, r, http.StatusInternalServerError, codeInternal, "streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	ctx := r.Context()
+	lost := false
+	for ctx.Err() == nil {
+		var tracks *magstripe.TrackData
+		err := s.do(ctx, func() (err error) {
+			tracks, err = s.dev.ReadTracks()
+			return err
+		})
+		if errors.Is(err, magstripe.ErrTimeout) || errors.Is(err, context.Canceled) {
+			continue
+		}
+		if errors.Is(err, errBusy) {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if errors.Is(err, magstripe.ErrDisconnected) {
+			if lost {
+				time.Sleep(time.Second)
+				continue
+			}
+			lost = true
+		} else {
+			lost = false
+		}
+
+		msg := swipeMessage{Time: time.Now().UTC().Format(time.RFC3339Nano)}
+		if err != nil {
+			msg.Error, _ = encodeError(err)
+		} else {
+			msg.Tracks = newTracksMessage(tracks)
+		}
+		if err := enc.Encode(msg); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// post checks the request method and decodes the body into v if it is not nil
+func (s *Server) post(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		s.fail(w, r, http.StatusMethodNotAllowed, codeBadRequest, "method not allowed")
+		return false
+	}
+	if v != nil {
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			s.fail(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("invalid request body: %v", err))
+			return false
+		}
+	}
+	return true
+}
+
+// reply writes the result of a device operation, or no content if result is nil
+func (s *Server) reply(w http.ResponseWriter, r *http.Request, err error, result any) {
+	if err != nil {
+		msg, status := encodeError(err)
+		s.logger.Warn("request failed", "path", r.URL.Path, "error", err)
+		writeJSON(w, status, msg)
+		return
+	}
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// fail writes an error that did not come from the device
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	s.logger.Warn("bad request", "path", r.URL.Path, "error", message)
+	writeJSON(w, status, &errorMessage{Message: message, Code: code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,210 @@
+// Package remote exposes a card reader/writer over HTTP/JSON and provides a
+// client that implements the same interfaces as a local device.
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/abrahan/magstripe-go"
+)
+
+// API paths
+const (
+	pathRead       = "/v1/read"
+	pathReadRaw    = "/v1/read/raw"
+	pathWrite      = "/v1/write"
+	pathWriteRaw   = "/v1/write/raw"
+	pathErase      = "/v1/erase"
+	pathCoercivity = "/v1/coercivity"
+	pathBPC        = "/v1/bpc"
+	pathBPI        = "/v1/bpi"
+	pathSettings   = "/v1/settings"
+	pathSwipes     = "/v1/swipes"
+)
+
+// Error codes carried in error responses
+const (
+	codeTimeout      = "timeout"
+	codeUnsupported  = "unsupported"
+	codeStatus       = "status"
+	codeDisconnected = "disconnected"
+	codeBusy         = "busy"
+	codeInvalid      = "invalid_setting"
+	codeBadRequest   = "bad_request"
+	codeInternal     = "internal"
+)
+
+// tracksMessage carries ISO track data
+type tracksMessage struct {
+	Track1 string `json:"track1"`
+	Track2 string `json:"track2"`
+	Track3 string `json:"track3"`
+}
+
+func newTracksMessage(t *magstripe.TrackData) *tracksMessage {
+	return &tracksMessage{Track1: t.Track1, Track2: t.Track2, Track3: t.Track3}
+}
+
+// rawTracksMessage carries raw track data, base64 encoded on the wire
+type rawTracksMessage struct {
+	Track1 []byte `json:"track1"`
+	Track2 []byte `json:"track2"`
+	Track3 []byte `json:"track3"`
+}
+
+// eraseMessage selects the tracks to erase
+type eraseMessage struct {
+	Track1 bool `json:"track1"`
+	Track2 bool `json:"track2"`
+	Track3 bool `json:"track3"`
+}
+
+// coercivityMessage selects the coercivity mode
+type coercivityMessage struct {
+	HiCo bool `json:"hico"`
+}
+
+// bpcMessage sets the bits per character of each track
+type bpcMessage struct {
+	BPC1 int `json:"bpc1"`
+	BPC2 int `json:"bpc2"`
+	BPC3 int `json:"bpc3"`
+}
+
+// bpiMessage sets the bits per inch of the tracks that are present
+type bpiMessage struct {
+	BPI1 *bool `json:"bpi1,omitempty"`
+	BPI2 *bool `json:"bpi2,omitempty"`
+	BPI3 *bool `json:"bpi3,omitempty"`
+}
+
+// settingsMessage carries the settings of a device, unset values omitted
+type settingsMessage struct {
+	// Coercivity is "hico" or "loco"
+	Coercivity string                  `json:"coercivity,omitempty"`
+	Tracks     [3]trackSettingsMessage `json:"tracks"`
+}
+
+// trackSettingsMessage carries the BPI and BPC of a track
+type trackSettingsMessage struct {
+	BPI int `json:"bpi,omitempty"`
+	BPC int `json:"bpc,omitempty"`
+}
+
+func newSettingsMessage(s magstripe.Settings) *settingsMessage {
+	msg := &settingsMessage{}
+	if s.Coercivity != magstripe.CoercivityUnset {
+		msg.Coercivity = s.Coercivity.String()
+	}
+	for i, t := range s.Tracks {
+		msg.Tracks[i] = trackSettingsMessage{BPI: int(t.BPI), BPC: int(t.BPC)}
+	}
+	return msg
+}
+
+// settings converts the message back, failing with ErrInvalidSetting on an
+// unknown coercivity
+func (msg *settingsMessage) settings() (magstripe.Settings, error) {
+	var s magstripe.Settings
+	switch msg.Coercivity {
+	case "":
+	case "hico":
+		s.Coercivity = magstripe.CoercivityHigh
+	case "loco":
+		s.Coercivity = magstripe.CoercivityLow
+	default:
+		return s, fmt.Errorf("%w: coercivity %q", magstripe.ErrInvalidSetting, msg.Coercivity)
+	}
+	for i, t := range msg.Tracks {
+		s.Tracks[i] = magstripe.TrackSettings{BPI: magstripe.BPI(t.BPI), BPC: magstripe.BPC(t.BPC)}
+	}
+	return s, nil
+}
+
+// swipeMessage is one line of the swipe stream
+type swipeMessage struct {
+	Tracks *tracksMessage `json:"tracks,omitempty"`
+	Error  *errorMessage  `json:"error,omitempty"`
+	Time   string         `json:"time"`
+}
+
+// errorMessage describes a failed request
+type errorMessage struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+	Op      string `json:"op,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+// errBusy is returned when the request queue is full
+var errBusy = errors.New("device queue is full")
+
+// errClosed is returned for requests the server stopped serving
+var errClosed = errors.New("server closed")
+
+// encodeError converts a device error into an error message and HTTP status
+func encodeError(err error) (*errorMessage, int) {
+	msg := &errorMessage{Message: err.Error(), Code: codeInternal}
+	var statusErr *magstripe.StatusError
+	switch {
+	case errors.Is(err, magstripe.ErrTimeout):
+		msg.Code = codeTimeout
+		return msg, http.StatusGatewayTimeout
+	case errors.Is(err, magstripe.ErrUnsupported):
+		msg.Code = codeUnsupported
+		return msg, http.StatusNotImplemented
+	case errors.Is(err, errBusy):
+		msg.Code = codeBusy
+		return msg, http.StatusServiceUnavailable
+	case errors.Is(err, magstripe.ErrDisconnected):
+		msg.Code = codeDisconnected
+		return msg, http.StatusServiceUnavailable
+	case errors.Is(err, magstripe.ErrInvalidSetting):
+		msg.Code = codeInvalid
+		return msg, http.StatusBadRequest
+	case errors.As(err, &statusErr):
+		msg.Code = codeStatus
+		msg.Op = statusErr.Op
+		msg.Status = string(statusErr.Status)
+		msg.Text = statusErr.Text
+		return msg, http.StatusUnprocessableEntity
+	}
+	return msg, http.StatusInternalServerError
+}
+
+// decodeError converts an error message back into the error the device returned,
+// so callers can use errors.Is and errors.As as with a local device
+func decodeError(msg *errorMessage) error {
+	switch msg.Code {
+	case codeTimeout:
+		return magstripe.ErrTimeout
+	case codeUnsupported:
+		return &remoteError{msg: msg.Message, wrapped: magstripe.ErrUnsupported}
+	case codeBusy:
+		return &remoteError{msg: msg.Message, wrapped: errBusy}
+	case codeDisconnected:
+		return &remoteError{msg: msg.Message, wrapped: magstripe.ErrDisconnected}
+	case codeInvalid:
+		return &remoteError{msg: msg.Message, wrapped: magstripe.ErrInvalidSetting}
+	case codeStatus:
+		var status byte
+		if msg.Status != "" {
+			status = msg.Status[0]
+		}
+		return &magstripe.StatusError{Op: msg.Op, Status: status, Text: msg.Text}
+	}
+	return &remoteError{msg: msg.Message}
+}
+
+// remoteError is an error reported by the server
+type remoteError struct {
+	msg     string
+	wrapped error
+}
+
+func (e *remoteError) Error() string { return "remote: " + e.msg }
+
+func (e *remoteError) Unwrap() error { return e.wrapped }
@@ -0,0 +1,140 @@
+package magstripe
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrFraming is returned when a response from the device cannot be parsed. The
+// MSR resets the device and drains its output before returning it, so that the
+// next command starts in step.
+var ErrFraming = errors.New("bad response framing")
+
+// RetryPolicy sets how commands that fail are tried again. The zero policy
+// makes a single attempt.
+type RetryPolicy struct {
+	// Attempts is the total number of tries of a command, including the first
+	Attempts int
+	// Backoff is the delay before the first retry, doubled for each following
+	// one up to MaxBackoff when set
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Reset resets the device before each retry
+	Reset bool
+	// Statuses lists the failure status bytes to retry. When empty, every
+	// failure status described by the device profile is retried.
+	Statuses []byte
+	// Timeouts retries commands that time out, such as reads nobody swiped a
+	// card for
+	Timeouts bool
+	// OnRetry, when set, is called before each retry
+	OnRetry func(RetryEvent)
+}
+
+// RetryEvent describes a retry about to be made
+type RetryEvent struct {
+	// Command is the command letter
+	Command string
+	// Attempt is the number of the attempt about to be made, from 2
+	Attempt int
+	// Status is the failure status of the previous attempt, 0 if it failed
+	// with Err
+	Status byte
+	Err    error
+	// Delay is the backoff waited before the attempt
+	Delay time.Duration
+}
+
+// WithRetry sets the policy for retrying failed commands (default no retries).
+// Framing errors are always retried within the policy's attempts.
+func WithRetry(p RetryPolicy) Option {
+	return func(c *config) {
+		c.retry = p
+	}
+}
+
+// retryNext decides whether to try a command again after attempt ended with
+// status or err, and prepares the retry: it reports it, waits the backoff and
+// resets the device when the policy asks for it
+func (m *MSR) retryNext(command string, attempt int, status byte, err error) bool {
+	p := m.retry
+	if attempt >= p.Attempts || !m.retryable(status, err) {
+		return false
+	}
+
+	delay := p.backoff(attempt)
+	ev := RetryEvent{Command: command[:1], Attempt: attempt + 1, Err: err, Delay: delay}
+	if err == nil {
+		ev.Status = status
+	}
+	m.logger.Info("retrying command", "command", ev.Command, "attempt", ev.Attempt,
+		"status", string(rune(ev.Status)), "error", err, "delay", delay)
+	if m.metrics != nil {
+		m.metrics.Add(MetricRetries, 1, Label{"command", m.commandLabel(ev.Command)}, Label{"status", statusLabel(status, err)})
+	}
+	if p.OnRetry != nil {
+		p.OnRetry(ev)
+	}
+
+	time.Sleep(delay)
+	if p.Reset {
+		if err := m.Reset(); err != nil {
+			m.logger.Warn("reset before retry failed", "error", err)
+		}
+	}
+	return true
+}
+
+// backoff returns the delay before the retry following attempt: Backoff
+// doubled for each earlier retry, up to MaxBackoff when set. Once doubling
+// would overflow the delay stays at the last one.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && delay > 0 && delay <= math.MaxInt64/2; i++ {
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// retryable reports whether a command that ended with status or err may be
+// retried under the policy
+func (m *MSR) retryable(status byte, err error) bool {
+	switch {
+	case errors.Is(err, ErrFraming):
+		return true
+	case errors.Is(err, ErrTimeout):
+		return m.retry.Timeouts
+	case err != nil:
+		return false
+	case status == m.profile.StatusOK:
+		return false
+	case len(m.retry.Statuses) > 0:
+		return bytes.IndexByte(m.retry.Statuses, status) >= 0
+	}
+	_, known := m.profile.StatusText[status]
+	return known
+}
+
+// resync resets the device and drains what it still sends after a response
+// that could not be parsed
+func (m *MSR) resync() {
+	m.logger.Warn("bad response framing, resynchronising")
+	if err := m.Reset(); err != nil {
+		m.logger.Warn("reset failed", "error", err)
+	}
+	buf := make([]byte, 1024)
+	defer clear(buf)
+	for {
+		if n, err := m.port.Read(buf); n == 0 || err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package magstripe
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRetryMSR(t *testing.T, port Port, p RetryPolicy) *MSR {
+	t.Helper()
+	m, err := NewMSRPort(port, WithoutInitialReset(), WithCommandTimeout(200*time.Millisecond), WithRetry(p))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+	return m
+}
+
+func TestRetryStatus(t *testing.T) {
+	var events []RetryEvent
+	sim := NewSimulator()
+	sim.SetCard(TrackData{Track2: testBankTrack2})
+	m := newRetryMSR(t, sim, RetryPolicy{
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+		OnRetry:  func(ev RetryEvent) { events = append(events, ev) },
+	})
+
+	sim.FailNext('1')
+	sim.FailNext('1')
+	tracks, err := m.ReadTracks()
+	if err != nil || tracks.Track2 != testBankTrack2 {
+		t.Fatalf("ReadTracks = %v, %v", tracks, err)
+	}
+	if len(events) != 2 || events[0].Attempt != 2 || events[1].Attempt != 3 ||
+		events[0].Status != '1' || events[0].Command != "r" || events[1].Delay != 2*time.Millisecond {
+		t.Errorf("unexpected retry events %+v", events)
+	}
+	if got := strings.Join(sim.Commands(), ""); got != "rrr" {
+		t.Errorf("commands %q", got)
+	}
+
+	// the last failure is returned once the attempts are used up
+	events = nil
+	for i := 0; i < 3; i++ {
+		sim.FailNext('1')
+	}
+	var statusErr *StatusError
+	if _, err := m.ReadTracks(); !errors.As(err, &statusErr) || len(events) != 2 {
+		t.Errorf("expected a status error after 2 retries, got %v after %d", err, len(events))
+	}
+
+	// resetting the device clears the simulated failures
+	m.retry.Reset = true
+	sim.FailNext('1')
+	sim.FailNext('1')
+	if _, err := m.ReadTracks(); err != nil {
+		t.Errorf("ReadTracks with reset: %v", err)
+	}
+	if got := strings.Join(sim.Commands(), ""); !strings.HasSuffix(got, "rar") {
+		t.Errorf("commands %q, expected a reset before the retry", got)
+	}
+}
+
+func TestRetryFilters(t *testing.T) {
+	retries := 0
+	sim := NewSimulator()
+	m := newRetryMSR(t, sim, RetryPolicy{
+		Attempts: 2,
+		Statuses: []byte{'9'},
+		OnRetry:  func(RetryEvent) { retries++ },
+	})
+
+	sim.FailNext('1')
+	if _, err := m.ReadTracks(); err == nil || retries != 0 {
+		t.Errorf("status not listed: err %v, %d retries", err, retries)
+	}
+	sim.FailNext('9')
+	if err := m.WriteTracks("", ";1?", ""); err != nil || retries != 1 {
+		t.Errorf("listed status: err %v, %d retries", err, retries)
+	}
+
+	// timeouts are only retried when asked for
+	sim.RemoveCard()
+	if _, err := m.ReadTracks(); !errors.Is(err, ErrTimeout) || retries != 1 {
+		t.Errorf("timeout: err %v, %d retries", err, retries)
+	}
+	m.retry.Timeouts = true
+	if _, err := m.ReadTracks(); !errors.Is(err, ErrTimeout) || retries != 2 {
+		t.Errorf("retried timeout: err %v, %d retries", err, retries)
+	}
+}
+
+// noisyPort answers the first commands with line noise instead of passing
+// them to the simulator
+type noisyPort struct {
+	*Simulator
+	noise   int
+	pending bool
+}
+
+func (p *noisyPort) Write(b []byte) (int, error) {
+	if p.noise > 0 {
+		p.noise--
+		p.pending = true
+		return len(b), nil
+	}
+	return p.Simulator.Write(b)
+}
+
+func (p *noisyPort) Read(b []byte) (int, error) {
+	if p.pending {
+		p.pending = false
+		return copy(b, "\x00\xff\x00"), nil
+	}
+	return p.Simulator.Read(b)
+}
+
+func TestRetryFraming(t *testing.T) {
+	port := &noisyPort{Simulator: NewSimulator(), noise: 1}
+	m := newRetryMSR(t, port, RetryPolicy{})
+	if err := m.SetCoercivity(LoCo); !errors.Is(err, ErrFraming) {
+		t.Fatalf("expected ErrFraming, got %v", err)
+	}
+	// the device was reset and the next command is in step
+	if err := m.SetCoercivity(LoCo); err != nil {
+		t.Errorf("SetCoercivity after resync: %v", err)
+	}
+	if got := strings.Join(port.Commands(), ""); got != "ay" {
+		t.Errorf("commands %q, expected a reset", got)
+	}
+
+	port = &noisyPort{Simulator: NewSimulator(), noise: 1}
+	port.SetCard(TrackData{Track1: testBankTrack1})
+	m = newRetryMSR(t, port, RetryPolicy{Attempts: 2})
+	tracks, err := m.ReadTracksSecure()
+	if err != nil || string(tracks.Track1) != testBankTrack1 {
+		t.Errorf("ReadTracksSecure after framing error = %v, %v", tracks, err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	long := time.Duration(math.MaxInt64/2 + 1)
+	tests := []struct {
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{RetryPolicy{Backoff: time.Millisecond}, 1, time.Millisecond},
+		{RetryPolicy{Backoff: time.Millisecond}, 4, 8 * time.Millisecond},
+		{RetryPolicy{Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}, 4, 5 * time.Millisecond},
+		{RetryPolicy{Backoff: time.Millisecond, MaxBackoff: time.Hour}, 1000, time.Hour},
+		// doubling overflows: the delay stays at the last one instead of 0
+		{RetryPolicy{Backoff: time.Millisecond}, 1000, time.Millisecond << 43},
+		{RetryPolicy{Backoff: long}, 1000, long},
+		{RetryPolicy{}, 1000, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.backoff(tt.attempt); got != tt.want {
+			t.Errorf("%+v attempt %d: backoff %v, expected %v", tt.policy, tt.attempt, got, tt.want)
+		}
+	}
+}
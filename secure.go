@@ -0,0 +1,91 @@
+package magstripe
+
+import "fmt"
+
+// SecureTracks holds the tracks of a read in byte slices that Wipe zeroes.
+// Strings cannot be cleared and stay in memory until collected, so
+// applications handling real cardholder data can read into SecureTracks and
+// wipe them as soon as the data has been used.
+type SecureTracks struct {
+	Track1 []byte
+	Track2 []byte
+	Track3 []byte
+}
+
+// newSecureTracks copies the strips of a datablock into their own slices
+func newSecureTracks(data []byte, strips [3]Span) *SecureTracks {
+	track := func(s Span) []byte {
+		return append(make([]byte, 0, s.End-s.Start), data[s.Start:s.End]...)
+	}
+	return &SecureTracks{Track1: track(strips[0]), Track2: track(strips[1]), Track3: track(strips[2])}
+}
+
+// Wipe zeroes the tracks and drops them
+func (t *SecureTracks) Wipe() {
+	clear(t.Track1)
+	clear(t.Track2)
+	clear(t.Track3)
+	*t = SecureTracks{}
+}
+
+// String reports the lengths of the tracks only, so that printing SecureTracks
+// does not copy card data into a string
+func (t *SecureTracks) String() string {
+	return fmt.Sprintf("SecureTracks{%d, %d, %d bytes}", len(t.Track1), len(t.Track2), len(t.Track3))
+}
+
+// ReadTracksSecure reads the tracks in ISO format like ReadTracks, returning
+// them in SecureTracks. The response is parsed in place and every buffer it
+// went through is zeroed before returning, so the tracks are the only copy
+// left by the MSR. Tracers get copies that are masked in place as they are
+// redacted, and recordings copies of their own.
+func (m *MSR) ReadTracksSecure() (*SecureTracks, error) {
+	return m.readSecure(m.profile.Commands.ReadISO, m.profile.Framing.ISOStrips)
+}
+
+// ReadRawTracksSecure reads the tracks in raw format like ReadRawTracks,
+// returning them in SecureTracks with the same guarantees as ReadTracksSecure
+func (m *MSR) ReadRawTracksSecure() (*SecureTracks, error) {
+	if err := m.profile.require("raw read", m.profile.Raw); err != nil {
+		return nil, err
+	}
+	return m.readSecure(m.profile.Commands.ReadRaw, m.profile.Framing.RawStrips)
+}
+
+// readSecure runs a read command and copies the strips out of the response
+func (m *MSR) readSecure(command string, strips func([]byte, Redact) ([3]Span, error)) (*SecureTracks, error) {
+	var response, data []byte
+	var status byte
+	var err error
+	for attempt := 1; ; attempt++ {
+		response, status, _, data, err = m.exchangeStatus(command, m.commandTimeout)
+		if !m.retryNext(command, attempt, status, err) {
+			break
+		}
+		clear(response)
+	}
+	defer clear(response)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.profile.checkStatus("read", status); err != nil {
+		return nil, err
+	}
+	spans, err := strips(data, m.redact)
+	if err != nil {
+		return nil, err
+	}
+	return newSecureTracks(data, spans), nil
+}
+
+// appendWiped appends src to dst like append, but zeroes the old array of dst
+// when it has to grow so that no stale copy of its data is left behind
+func appendWiped(dst, src []byte) []byte {
+	if len(dst)+len(src) <= cap(dst) {
+		return append(dst, src...)
+	}
+	grown := make([]byte, len(dst), 2*cap(dst)+len(src))
+	copy(grown, dst)
+	clear(dst[:cap(dst)])
+	return append(grown, src...)
+}
@@ -0,0 +1,136 @@
+package magstripe
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// wipeCheckPort is a simulator that keeps the buffers it filled, to check
+// that the MSR clears them
+type wipeCheckPort struct {
+	*Simulator
+	reads [][]byte
+}
+
+func (p *wipeCheckPort) Read(b []byte) (int, error) {
+	n, err := p.Simulator.Read(b)
+	if n > 0 {
+		p.reads = append(p.reads, b)
+	}
+	return n, err
+}
+
+func TestReadTracksSecure(t *testing.T) {
+	port := &wipeCheckPort{Simulator: NewSimulator()}
+	card := TrackData{Track1: testBankTrack1, Track2: testBankTrack2}
+	port.SetCard(card)
+	m, err := NewMSRPort(port, WithoutInitialReset(), WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+
+	tracks, err := m.ReadTracksSecure()
+	if err != nil {
+		t.Fatalf("ReadTracksSecure: %v", err)
+	}
+	if string(tracks.Track1) != card.Track1 || string(tracks.Track2) != card.Track2 || len(tracks.Track3) != 0 {
+		t.Errorf("unexpected tracks %q, %q, %q", tracks.Track1, tracks.Track2, tracks.Track3)
+	}
+	if len(port.reads) == 0 {
+		t.Fatal("no reads recorded")
+	}
+	for _, b := range port.reads {
+		if bytes.Contains(b, []byte("4111")) {
+			t.Errorf("receive buffer not wiped: %q", b)
+		}
+	}
+	if got := tracks.String(); got != "SecureTracks{45, 35, 0 bytes}" {
+		t.Errorf("String() = %q", got)
+	}
+
+	t1 := tracks.Track1
+	tracks.Wipe()
+	if tracks.Track1 != nil || !bytes.Equal(t1, make([]byte, len(t1))) {
+		t.Errorf("Wipe left %q", t1)
+	}
+}
+
+func TestReadTracksSecureTraced(t *testing.T) {
+	port := &wipeCheckPort{Simulator: NewSimulator()}
+	card := TrackData{Track1: testBankTrack1, Track2: testBankTrack2}
+	port.SetCard(card)
+	var events []TraceEvent
+	m, err := NewMSRPort(port, WithoutInitialReset(), WithCommandTimeout(200*time.Millisecond),
+		WithTracer(TracerFunc(func(ev TraceEvent) {
+			events = append(events, ev)
+		})))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+
+	tracks, err := m.ReadTracksSecure()
+	if err != nil {
+		t.Fatalf("ReadTracksSecure: %v", err)
+	}
+	defer tracks.Wipe()
+
+	// the tracer only ever sees masked bytes, and the buffers are still wiped
+	received := false
+	for _, ev := range events {
+		if bytes.Contains(ev.Data, []byte("4111111111111111")) || bytes.Contains(ev.Data, []byte("2512101000000000")) {
+			t.Errorf("clear card data traced: %q", ev.Data)
+		}
+		received = received || (ev.Direction == Received && bytes.Contains(ev.Data, []byte("%B411111******1111^")))
+	}
+	if !received {
+		t.Errorf("redacted response not traced: %+v", events)
+	}
+	for _, b := range port.reads {
+		if bytes.Contains(b, []byte("4111")) {
+			t.Errorf("receive buffer not wiped: %q", b)
+		}
+	}
+}
+
+func TestReadRawTracksSecure(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+	if err := m.WriteRawTracks("\x01\x02", "", "\x1b\x1c"); err != nil {
+		t.Fatalf("WriteRawTracks: %v", err)
+	}
+	tracks, err := m.ReadRawTracksSecure()
+	if err != nil {
+		t.Fatalf("ReadRawTracksSecure: %v", err)
+	}
+	if string(tracks.Track1) != "\x01\x02" || len(tracks.Track2) != 0 || string(tracks.Track3) != "\x1b\x1c" {
+		t.Errorf("unexpected tracks %q, %q, %q", tracks.Track1, tracks.Track2, tracks.Track3)
+	}
+
+	sim.RemoveCard()
+	if _, err := m.ReadTracksSecure(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout without a card, got %v", err)
+	}
+}
+
+func TestAppendWiped(t *testing.T) {
+	var buf []byte
+	var old [][]byte
+	for i := 0; i < 10; i++ {
+		if cap(buf) > 0 && len(buf)+3 > cap(buf) {
+			old = append(old, buf[:cap(buf)])
+		}
+		buf = appendWiped(buf, []byte("abc"))
+	}
+	if string(buf) != "abcabcabcabcabcabcabcabcabcabc" {
+		t.Errorf("appendWiped built %q", buf)
+	}
+	if len(old) == 0 {
+		t.Fatal("buffer never grew")
+	}
+	for _, b := range old {
+		if !bytes.Equal(b, make([]byte, len(b))) {
+			t.Errorf("old array not wiped: %q", b)
+		}
+	}
+}
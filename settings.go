@@ -0,0 +1,195 @@
+package magstripe
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidSetting is returned for a coercivity, BPI or BPC the device does
+// not support
+var ErrInvalidSetting = errors.New("invalid setting")
+
+// Coercivity is the magnetic coercivity of the card stock a device writes
+type Coercivity int
+
+const (
+	// CoercivityUnset leaves the coercivity unchanged, and is reported when
+	// it is not known
+	CoercivityUnset Coercivity = iota
+	// CoercivityLow writes low coercivity (LoCo) cards, usually brown stripes
+	CoercivityLow
+	// CoercivityHigh writes high coercivity (HiCo) cards, usually black stripes
+	CoercivityHigh
+)
+
+func (c Coercivity) String() string {
+	switch c {
+	case CoercivityUnset:
+		return "unset"
+	case CoercivityLow:
+		return "loco"
+	case CoercivityHigh:
+		return "hico"
+	}
+	return fmt.Sprintf("Coercivity(%d)", int(c))
+}
+
+// hico returns the coercivity as recorded in dumps, nil when unset
+func (c Coercivity) hico() *bool {
+	if c == CoercivityUnset {
+		return nil
+	}
+	hico := c == CoercivityHigh
+	return &hico
+}
+
+// BPI is the recording density of a track in bits per inch
+type BPI int
+
+// Densities supported by the MSR605 family
+const (
+	BPI75  BPI = 75
+	BPI210 BPI = 210
+)
+
+// BPC is the number of bits per character of a track, parity included, from
+// 5 to 8
+type BPC int
+
+// trackBPC returns bpc for track i (0 to 2), or when unset the ISO width of
+// the track's charset, which the device starts with (7, 5 and 5)
+func trackBPC(i int, bpc BPC) int {
+	if bpc == 0 {
+		return trackCharsets[i].CharBits()
+	}
+	return int(bpc)
+}
+
+// TrackSettings is the recording density and character width of a track.
+// Zero values leave the setting unchanged, and are reported when it is not
+// known.
+type TrackSettings struct {
+	BPI BPI
+	BPC BPC
+}
+
+// Settings is the configuration of a device
+type Settings struct {
+	Coercivity Coercivity
+	Tracks     [3]TrackSettings
+}
+
+// Validate checks that the settings made are supported by the MSR605 family
+func (s Settings) Validate() error {
+	if s.Coercivity < CoercivityUnset || s.Coercivity > CoercivityHigh {
+		return fmt.Errorf("%w: coercivity %d", ErrInvalidSetting, int(s.Coercivity))
+	}
+	for i, t := range s.Tracks {
+		if t.BPI != 0 && t.BPI != BPI75 && t.BPI != BPI210 {
+			return fmt.Errorf("%w: track %d BPI %d is not 75 or 210", ErrInvalidSetting, i+1, t.BPI)
+		}
+		if t.BPC != 0 && (t.BPC < 5 || t.BPC > 8) {
+			return fmt.Errorf("%w: track %d BPC %d is not between 5 and 8", ErrInvalidSetting, i+1, t.BPC)
+		}
+	}
+	return nil
+}
+
+// bpiModes holds the argument of the SetBPI command selecting each density
+// of each track
+var bpiModes = [3]map[BPI]string{
+	{BPI210: "\xA1", BPI75: "\xA0"},
+	{BPI210: "\xD2", BPI75: "\x4B"},
+	{BPI210: "\xC1", BPI75: "\xC0"},
+}
+
+// Configure applies the settings made in s, leaving the others unchanged. The
+// BPC of the three tracks are sent together: a track whose BPC is not set
+// keeps the one last set through the MSR, or else gets the ISO width the
+// device starts with (7, 5 and 5).
+func (m *MSR) Configure(s Settings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	if s.Coercivity != CoercivityUnset {
+		if err := m.profile.require("set_coercivity", m.profile.Coercivity && !m.profile.ReadOnly); err != nil {
+			return err
+		}
+		command := m.profile.Commands.LoCo
+		if s.Coercivity == CoercivityHigh {
+			command = m.profile.Commands.HiCo
+		}
+		if err := m.configure("set_coercivity", command); err != nil {
+			return err
+		}
+		m.settings.Coercivity = s.Coercivity
+	}
+
+	for i, t := range s.Tracks {
+		if t.BPI == 0 {
+			continue
+		}
+		mode := bpiModes[i][t.BPI]
+		if err := m.configure("set_bpi", m.profile.Commands.SetBPI+mode); err != nil {
+			return fmt.Errorf("%w for %x", err, mode)
+		}
+		m.settings.Tracks[i].BPI = t.BPI
+	}
+
+	var bpc [3]BPC
+	set := false
+	for i, t := range s.Tracks {
+		if t.BPC != 0 {
+			bpc[i], set = t.BPC, true
+		} else {
+			bpc[i] = BPC(trackBPC(i, m.settings.Tracks[i].BPC))
+		}
+	}
+	if !set {
+		return nil
+	}
+	if err := m.configure("set_bpc", m.profile.Commands.SetBPC+string([]byte{byte(bpc[0]), byte(bpc[1]), byte(bpc[2])})); err != nil {
+		return err
+	}
+	for i := range bpc {
+		m.settings.Tracks[i].BPC = bpc[i]
+	}
+	return nil
+}
+
+// configure runs a settings command for op
+func (m *MSR) configure(op, command string) error {
+	status, _, _, err := m.executeWaitResult(command, m.commandTimeout)
+	if err != nil {
+		return err
+	}
+	return m.profile.checkStatus(op, status)
+}
+
+// Settings returns the settings of the device. The coercivity is asked of
+// devices that can report it; the BPI and BPC, which the device cannot
+// report, are those last applied through the MSR. Settings that are not known
+// are unset.
+func (m *MSR) Settings() (Settings, error) {
+	command := m.profile.Commands.GetCoercivity
+	if command == "" || !m.profile.Coercivity {
+		return m.settings, nil
+	}
+
+	status, _, _, err := m.executeWaitResult(command, m.commandTimeout)
+	if err != nil {
+		return m.settings, err
+	}
+	switch status {
+	case 'H':
+		m.settings.Coercivity = CoercivityHigh
+	case 'L':
+		m.settings.Coercivity = CoercivityLow
+	default:
+		if err := m.profile.checkStatus("get_coercivity", status); err != nil {
+			return m.settings, err
+		}
+	}
+	return m.settings, nil
+}
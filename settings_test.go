@@ -0,0 +1,82 @@
+package magstripe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		wantErr  bool
+	}{
+		{"unset", Settings{}, false},
+		{"all set", Settings{Coercivity: CoercivityHigh, Tracks: [3]TrackSettings{{BPI210, 7}, {BPI75, 5}, {BPI210, 8}}}, false},
+		{"bad coercivity", Settings{Coercivity: 3}, true},
+		{"bad BPI", Settings{Tracks: [3]TrackSettings{{BPI: 100}}}, true},
+		{"BPC too wide", Settings{Tracks: [3]TrackSettings{{}, {}, {BPC: 12}}}, true},
+		{"BPC too narrow", Settings{Tracks: [3]TrackSettings{{BPC: 4}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSetting) {
+				t.Errorf("expected ErrInvalidSetting, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+
+	s, err := m.Settings()
+	if err != nil || s.Coercivity != CoercivityHigh || s.Tracks != [3]TrackSettings{} {
+		t.Fatalf("Settings at power on = %+v, %v", s, err)
+	}
+
+	before := len(sim.Commands())
+	err = m.Configure(Settings{
+		Coercivity: CoercivityLow,
+		Tracks:     [3]TrackSettings{{BPI: BPI75}, {BPC: 8}},
+	})
+	if err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if got := strings.Join(sim.Commands()[before:], ""); got != "ybo" {
+		t.Errorf("commands %q", got)
+	}
+	if sim.Coercivity() != LoCo {
+		t.Error("coercivity not applied")
+	}
+
+	// the tracks whose BPC was not set get the ISO widths
+	want := Settings{
+		Coercivity: CoercivityLow,
+		Tracks:     [3]TrackSettings{{BPI75, 7}, {0, 8}, {0, 5}},
+	}
+	if s, err := m.Settings(); err != nil || s != want {
+		t.Errorf("Settings = %+v, %v, expected %+v", s, err, want)
+	}
+
+	before = len(sim.Commands())
+	if err := m.SetBPC(12, 5, 5); !errors.Is(err, ErrInvalidSetting) {
+		t.Errorf("SetBPC(12, 5, 5) = %v, expected ErrInvalidSetting", err)
+	}
+	if len(sim.Commands()) != before {
+		t.Error("an invalid BPC reached the device")
+	}
+
+	ro, _ := NewMSRPort(NewSimulator(), WithoutInitialReset(), WithProfile(ProfileReadOnly))
+	if err := ro.Configure(Settings{Coercivity: CoercivityLow}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+	if s, err := ro.Settings(); err != nil || s.Coercivity != CoercivityUnset {
+		t.Errorf("read-only Settings = %+v, %v", s, err)
+	}
+}
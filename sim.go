@@ -0,0 +1,310 @@
+package magstripe
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SimulatorFirmware is the firmware version reported by a Simulator
+const SimulatorFirmware = "REVS1.00"
+
+// Errors of the simulated port
+var (
+	errSimulatorClosed    = errors.New("simulator closed")
+	errSimulatorUnplugged = errors.New("simulator unplugged")
+)
+
+// Simulator is an in-memory Port that emulates an MSR605 with a card in its
+// slot. Use it with NewMSRPort to exercise code without hardware.
+type Simulator struct {
+	mu         sync.Mutex
+	card       TrackData
+	raw        [3]string
+	inserted   bool
+	hico       bool
+	bpc        [3]byte
+	failNext   []byte
+	failTracks Tracks
+	pending    []byte
+	timeout    time.Duration
+	closed     bool
+	unplugged  bool
+	commandLog []string
+}
+
+// NewSimulator creates a simulator with a blank card inserted
+func NewSimulator() *Simulator {
+	return &Simulator{inserted: true, hico: true, bpc: [3]byte{7, 5, 5}}
+}
+
+// SetCard inserts a card holding the given ISO tracks
+func (s *Simulator) SetCard(tracks TrackData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.card = tracks
+	s.raw = [3]string{}
+	s.inserted = true
+}
+
+// RemoveCard empties the slot so reads and writes wait for a swipe until they time out
+func (s *Simulator) RemoveCard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inserted = false
+}
+
+// Card returns the ISO tracks currently on the card
+func (s *Simulator) Card() TrackData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.card
+}
+
+// Coercivity reports whether the simulator is in high coercivity mode
+func (s *Simulator) Coercivity() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hico
+}
+
+// FailNext makes the next command that reports a status fail with the given status byte
+func (s *Simulator) FailNext(status byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext = append(s.failNext, status)
+}
+
+// FailTracks makes reads return the selected tracks empty with a read error
+// status, as when the device cannot decode them, until FailTracks(0)
+func (s *Simulator) FailTracks(tracks Tracks) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failTracks = tracks
+}
+
+// Unplug makes the port fail as when the device is removed, until Plug
+func (s *Simulator) Unplug() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unplugged = true
+	s.pending = nil
+}
+
+// Plug brings an unplugged device back with its power-on settings: high
+// coercivity and 7, 5 and 5 bits per character. The port must be reopened
+// with Open.
+func (s *Simulator) Plug() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unplugged = false
+	s.hico = true
+	s.bpc = [3]byte{7, 5, 5}
+	s.failNext = nil
+}
+
+// Open reopens a closed simulator, failing while it is unplugged. It can be
+// used as ReconnectPolicy.Open.
+func (s *Simulator) Open() (Port, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unplugged {
+		return nil, errSimulatorUnplugged
+	}
+	s.closed = false
+	return s, nil
+}
+
+// Commands returns the command letters received so far
+func (s *Simulator) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commandLog...)
+}
+
+// Write handles one command
+func (s *Simulator) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.portErr(); err != nil {
+		return 0, err
+	}
+
+	cmd := string(p)
+	if !strings.HasPrefix(cmd, EscapeCode) || len(cmd) < 2 {
+		s.respond("", '2')
+		return len(p), nil
+	}
+	letter, args := cmd[1:2], cmd[2:]
+	s.commandLog = append(s.commandLog, letter)
+
+	switch letter {
+	case "a":
+		s.failNext = nil
+	case "r":
+		if s.inserted {
+			t := s.readTracks([3]string{s.card.Track1, s.card.Track2, s.card.Track3})
+			s.respond(encodeISODataBlock(t[0], t[1], t[2]), s.readStatus())
+		}
+	case "w":
+		if s.inserted {
+			if t, ok := s.writeTracks(args, false); ok {
+				s.card = TrackData{Track1: t[0], Track2: t[1], Track3: t[2]}
+			}
+		}
+	case "m":
+		if s.inserted {
+			t := s.readTracks(s.raw)
+			s.respond(encodeRawDataBlock(t[0], t[1], t[2]), s.readStatus())
+		}
+	case "n":
+		if s.inserted {
+			if t, ok := s.writeTracks(args, true); ok {
+				s.raw = t
+			}
+		}
+	case "c":
+		if len(args) != 1 {
+			s.respond("", '2')
+			break
+		}
+		if s.inserted && s.respond("", '0') {
+			mask := args[0]
+			if mask&1 != 0 {
+				s.card.Track1, s.raw[0] = "", ""
+			}
+			if mask&2 != 0 {
+				s.card.Track2, s.raw[1] = "", ""
+			}
+			if mask&4 != 0 {
+				s.card.Track3, s.raw[2] = "", ""
+			}
+		}
+	case "x", "y":
+		if s.respond("", '0') {
+			s.hico = letter == "x"
+		}
+	case "b":
+		s.respond("", '0')
+	case "d":
+		if s.hico {
+			s.pending = append(s.pending, EscapeCode+"H"...)
+		} else {
+			s.pending = append(s.pending, EscapeCode+"L"...)
+		}
+	case "v":
+		s.pending = append(s.pending, EscapeCode+SimulatorFirmware...)
+	case "o":
+		if len(args) != 3 {
+			s.respond("", '2')
+			break
+		}
+		if s.respond("", '0') {
+			copy(s.bpc[:], args)
+		}
+	default:
+		s.respond("", '4')
+	}
+	return len(p), nil
+}
+
+// readTracks blanks the tracks set to fail in a read
+func (s *Simulator) readTracks(tracks [3]string) [3]string {
+	for i := range tracks {
+		if s.failTracks.Has(i + 1) {
+			tracks[i] = ""
+		}
+	}
+	return tracks
+}
+
+// readStatus is the status of a read, failing when tracks are set to fail
+func (s *Simulator) readStatus() byte {
+	if s.failTracks != 0 {
+		return '1'
+	}
+	return '0'
+}
+
+// writeTracks applies the strips of a write datablock to the current tracks,
+// leaving the tracks it does not hold untouched, and responds to the write
+func (s *Simulator) writeTracks(block string, raw bool) ([3]string, bool) {
+	tracks := s.raw
+	if !raw {
+		tracks = [3]string{s.card.Track1, s.card.Track2, s.card.Track3}
+	}
+	strips, sel, err := selectedDataBlockStrips([]byte(block), raw, RedactPCI)
+	if err != nil {
+		s.respond("", '2')
+		return tracks, false
+	}
+	for i, strip := range strips {
+		if sel.Has(i + 1) {
+			tracks[i] = block[strip.Start:strip.End]
+		}
+	}
+	return tracks, s.respond("", '0')
+}
+
+// respond queues data followed by a status, or an injected failure. It reports
+// whether the command succeeded.
+func (s *Simulator) respond(data string, status byte) bool {
+	if len(s.failNext) > 0 {
+		status = s.failNext[0]
+		s.failNext = s.failNext[1:]
+		data = ""
+	}
+	s.pending = append(s.pending, data+EscapeCode+string(status)...)
+	return status == '0'
+}
+
+// Read returns queued response bytes, or 0 after the read timeout
+func (s *Simulator) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	if err := s.portErr(); err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	if len(s.pending) > 0 {
+		n := copy(p, s.pending)
+		s.pending = s.pending[n:]
+		s.mu.Unlock()
+		return n, nil
+	}
+	timeout := s.timeout
+	s.mu.Unlock()
+
+	if timeout <= 0 || timeout > 10*time.Millisecond {
+		timeout = 10 * time.Millisecond
+	}
+	time.Sleep(timeout)
+	return 0, nil
+}
+
+// portErr returns the error of a closed or unplugged simulator
+func (s *Simulator) portErr() error {
+	if s.unplugged {
+		return errSimulatorUnplugged
+	}
+	if s.closed {
+		return errSimulatorClosed
+	}
+	return nil
+}
+
+// SetReadTimeout sets how long Read waits when no data is queued
+func (s *Simulator) SetReadTimeout(t time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeout = t
+	return nil
+}
+
+// Close marks the simulator closed
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,110 @@
+package magstripe
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newSimulatedMSR(t *testing.T) (*MSR, *Simulator) {
+	t.Helper()
+	sim := NewSimulator()
+	m, err := NewMSRPort(sim, WithCommandTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+	return m, sim
+}
+
+func TestSimulatorWriteRead(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+
+	if err := m.WriteTracks("%B123^DOE/JOHN^99?", ";123=99?", ";011?"); err != nil {
+		t.Fatalf("WriteTracks: %v", err)
+	}
+	if got := sim.Card(); got.Track2 != ";123=99?" {
+		t.Errorf("card not written: %+v", got)
+	}
+
+	tracks, err := m.ReadTracks()
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if tracks.Track1 != "%B123^DOE/JOHN^99?" || tracks.Track3 != ";011?" {
+		t.Errorf("unexpected tracks: %+v", tracks)
+	}
+}
+
+func TestSimulatorErase(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+	sim.SetCard(TrackData{Track1: "%A?", Track2: ";1?", Track3: ";2?"})
+
+	if err := m.EraseTracks(true, false, true); err != nil {
+		t.Fatalf("EraseTracks: %v", err)
+	}
+	if got := sim.Card(); got != (TrackData{Track2: ";1?"}) {
+		t.Errorf("unexpected card after erase: %+v", got)
+	}
+}
+
+func TestSimulatorConfigure(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+
+	if err := m.SetCoercivity(LoCo); err != nil {
+		t.Fatalf("SetCoercivity: %v", err)
+	}
+	if sim.Coercivity() != LoCo {
+		t.Error("coercivity not applied")
+	}
+	if err := m.SetBPC(8, 8, 8); err != nil {
+		t.Fatalf("SetBPC: %v", err)
+	}
+	hi := HiBPI
+	if err := m.SetBPI(&hi, nil, nil); err != nil {
+		t.Fatalf("SetBPI: %v", err)
+	}
+}
+
+func TestSimulatorRawRoundTrip(t *testing.T) {
+	m, _ := newSimulatedMSR(t)
+
+	if err := m.WriteRawTracks("\x01\x02", "", "\x03"); err != nil {
+		t.Fatalf("WriteRawTracks: %v", err)
+	}
+	t1, t2, t3, err := m.ReadRawTracks()
+	if err != nil {
+		t.Fatalf("ReadRawTracks: %v", err)
+	}
+	if t1 != "\x01\x02" || t2 != "" || t3 != "\x03" {
+		t.Errorf("unexpected raw tracks %q, %q, %q", t1, t2, t3)
+	}
+}
+
+func TestSimulatorFailures(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+
+	sim.FailNext('1')
+	var statusErr *StatusError
+	if _, err := m.ReadTracks(); !errors.As(err, &statusErr) || statusErr.Status != '1' {
+		t.Errorf("expected status 1, got %v", err)
+	}
+
+	sim.RemoveCard()
+	if _, err := m.ReadTracks(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected timeout without a card, got %v", err)
+	}
+
+	m.Close()
+	if _, err := m.ReadTracks(); err == nil {
+		t.Error("expected error after close")
+	}
+}
+
+func TestInterfaces(t *testing.T) {
+	m, _ := newSimulatedMSR(t)
+
+	var rw CardReadWriter = m
+	if _, err := rw.ReadTracks(); err != nil {
+		t.Errorf("ReadTracks through CardReadWriter: %v", err)
+	}
+}
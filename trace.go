@@ -0,0 +1,228 @@
+package magstripe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Direction tells whether traced bytes were sent to or received from the device
+type Direction int
+
+const (
+	Sent Direction = iota
+	Received
+)
+
+func (d Direction) String() string {
+	if d == Sent {
+		return "tx"
+	}
+	return "rx"
+}
+
+// TraceEvent is one exchange of bytes with the device
+type TraceEvent struct {
+	Time      time.Time
+	Direction Direction
+	// Data holds the bytes exchanged, with card data redacted by the policy
+	// set with WithRedaction
+	Data []byte
+	// Meaning is a decoded description of the escape sequences and status byte
+	Meaning string
+}
+
+// Tracer receives every command sent to and response received from the device
+type Tracer interface {
+	Trace(ev TraceEvent)
+}
+
+// TracerFunc adapts a function to the Tracer interface
+type TracerFunc func(ev TraceEvent)
+
+// Trace calls f(ev)
+func (f TracerFunc) Trace(ev TraceEvent) {
+	f(ev)
+}
+
+// WithTracer installs a tracer that sees the wire traffic of the MSR
+func WithTracer(t Tracer) Option {
+	return func(c *config) {
+		c.tracer = t
+	}
+}
+
+// slogTracer logs trace events at debug level
+type slogTracer struct {
+	logger *slog.Logger
+}
+
+// NewSlogTracer returns a Tracer that logs each event at debug level with its
+// direction, a hex and ASCII dump and the decoded meaning
+func NewSlogTracer(logger *slog.Logger) Tracer {
+	return &slogTracer{logger: logger}
+}
+
+func (t *slogTracer) Trace(ev TraceEvent) {
+	t.logger.LogAttrs(context.Background(), slog.LevelDebug, "msr "+ev.Direction.String(),
+		slog.Time("at", ev.Time),
+		slog.Int("len", len(ev.Data)),
+		slog.String("hex", hexDump(ev.Data)),
+		slog.String("ascii", asciiDump(ev.Data)),
+		slog.String("meaning", ev.Meaning),
+	)
+}
+
+// trace reports data to the tracer, if any, with card data redacted by the
+// policy of the MSR
+func (m *MSR) trace(dir Direction, data []byte) {
+	if m.tracer == nil {
+		return
+	}
+	var meaning string
+	if dir == Sent {
+		meaning = m.describeCommand(data)
+		c := m.profile.Commands
+		m.traceRaw = len(data) > 1 && (string(data[1]) == c.ReadRaw || string(data[1]) == c.WriteRaw)
+	} else {
+		meaning = m.describeResponse(data)
+	}
+	m.tracer.Trace(TraceEvent{
+		Time:      time.Now(),
+		Direction: dir,
+		Data:      m.redact.wire(data, m.traceRaw),
+		Meaning:   meaning,
+	})
+}
+
+// describeCommand names the command in data
+func (m *MSR) describeCommand(data []byte) string {
+	if len(data) < 2 || data[0] != EscapeCode[0] {
+		return "unframed data"
+	}
+	c := m.profile.Commands
+	names := map[string]string{
+		c.Reset:    "reset",
+		c.ReadISO:  "read ISO",
+		c.WriteISO: "write ISO",
+		c.ReadRaw:  "read raw",
+		c.WriteRaw: "write raw",
+		c.Erase:    "erase",
+		c.HiCo:     "set high coercivity",
+		c.LoCo:     "set low coercivity",
+		c.SetBPI:   "set BPI",
+		c.SetBPC:   "set BPC",
+		c.Version:  "get firmware version",
+
+		c.GetCoercivity: "get coercivity",
+	}
+	letter := string(data[1])
+	name, ok := names[letter]
+	if !ok {
+		return fmt.Sprintf("<ESC>%s unknown command", letter)
+	}
+
+	desc := fmt.Sprintf("<ESC>%s %s", letter, name)
+	args := data[2:]
+	switch letter {
+	case c.WriteISO, c.WriteRaw:
+		desc += ", " + describeDataBlock(args)
+	case c.Erase:
+		if len(args) == 1 {
+			desc += fmt.Sprintf(", tracks %s", describeMask(args[0]))
+		}
+	case c.SetBPC:
+		if len(args) == 3 {
+			desc += fmt.Sprintf(", %d/%d/%d bits", args[0], args[1], args[2])
+		}
+	case c.SetBPI:
+		if len(args) == 1 {
+			desc += fmt.Sprintf(", mode %#02x", args[0])
+		}
+	}
+	return desc
+}
+
+// describeResponse decodes the data block and status byte of a response
+func (m *MSR) describeResponse(data []byte) string {
+	pos := bytes.LastIndexByte(data, EscapeCode[0])
+	if pos == -1 || pos+1 >= len(data) {
+		return "incomplete response"
+	}
+
+	if bytes.HasPrefix(data[pos+1:], []byte("REV")) {
+		return "firmware version " + string(data[pos+1:])
+	}
+	status := data[pos+1]
+	desc := fmt.Sprintf("status <ESC>%c", status)
+	if status == m.profile.StatusOK {
+		desc += " ok"
+	} else if text, ok := m.profile.StatusText[status]; ok {
+		desc += " " + text
+	} else {
+		desc += " unknown status"
+	}
+	if pos > 0 {
+		desc = describeDataBlock(data[:pos]) + ", " + desc
+	}
+	return desc
+}
+
+// describeDataBlock summarises a track data block
+func describeDataBlock(block []byte) string {
+	if !bytes.HasPrefix(block, []byte(EscapeCode+"s")) {
+		return fmt.Sprintf("%d bytes of data", len(block))
+	}
+	desc := fmt.Sprintf("datablock of %d bytes", len(block))
+	if !bytes.HasSuffix(block, []byte("?"+EndCode)) {
+		desc += " without ?<FS> terminator"
+	}
+	return desc
+}
+
+// describeMask lists the tracks selected by an erase mask
+func describeMask(mask byte) string {
+	var tracks []string
+	for i := 0; i < 3; i++ {
+		if mask&(1<<i) != 0 {
+			tracks = append(tracks, fmt.Sprint(i+1))
+		}
+	}
+	if len(tracks) == 0 {
+		return "none"
+	}
+	return strings.Join(tracks, ",")
+}
+
+// hexDump renders data as space separated hex bytes
+func hexDump(data []byte) string {
+	var b strings.Builder
+	for i, c := range data {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%02x", c)
+	}
+	return b.String()
+}
+
+// asciiDump renders data with control bytes shown as <ESC>, <FS> or '.'
+func asciiDump(data []byte) string {
+	var b strings.Builder
+	for _, c := range data {
+		switch {
+		case c == EscapeCode[0]:
+			b.WriteString("<ESC>")
+		case c == EndCode[0]:
+			b.WriteString("<FS>")
+		case c < 0x20 || c >= 0x7f:
+			b.WriteByte('.')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
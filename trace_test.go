@@ -0,0 +1,69 @@
+package magstripe
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTracer(t *testing.T) {
+	var events []TraceEvent
+	sim := NewSimulator()
+	sim.SetCard(TrackData{Track2: ";123?"})
+	m, err := NewMSRPort(sim, WithoutInitialReset(), WithCommandTimeout(200*time.Millisecond),
+		WithTracer(TracerFunc(func(ev TraceEvent) {
+			events = append(events, ev)
+		})))
+	if err != nil {
+		t.Fatalf("NewMSRPort: %v", err)
+	}
+
+	if _, err := m.ReadTracks(); err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	sim.FailNext('4')
+	m.EraseTracks(true, false, true)
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d: %+v", len(events), events)
+	}
+	expect := []struct {
+		dir     Direction
+		meaning string
+	}{
+		{Sent, "<ESC>r read ISO"},
+		{Received, "datablock of 15 bytes, status <ESC>0 ok"},
+		{Sent, "<ESC>c erase, tracks 1,3"},
+		{Received, "status <ESC>4 invalid command"},
+	}
+	for i, want := range expect {
+		if events[i].Direction != want.dir || events[i].Meaning != want.meaning {
+			t.Errorf("event %d: expected %v %q, got %v %q", i, want.dir, want.meaning, events[i].Direction, events[i].Meaning)
+		}
+	}
+}
+
+func TestSlogTracer(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	NewSlogTracer(logger).Trace(TraceEvent{Time: time.Now(), Direction: Sent, Data: []byte("\x1br"), Meaning: "<ESC>r read ISO"})
+
+	out := buf.String()
+	for _, want := range []string{"msr tx", "hex=\"1b 72\"", "ascii=<ESC>r", "meaning=\"<ESC>r read ISO\""} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output missing %q: %s", want, out)
+		}
+	}
+}
+
+func TestDumps(t *testing.T) {
+	data := []byte("\x1bs\x1b\x01A?\x1c\xff")
+	if got := hexDump(data); got != "1b 73 1b 01 41 3f 1c ff" {
+		t.Errorf("unexpected hex dump %q", got)
+	}
+	if got := asciiDump(data); got != "<ESC>s<ESC>.A?<FS>." {
+		t.Errorf("unexpected ASCII dump %q", got)
+	}
+}
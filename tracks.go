@@ -0,0 +1,377 @@
+package magstripe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Tracks selects card tracks as a bit mask, in the same layout as the erase
+// command: bit 0 for track 1, bit 1 for track 2 and bit 2 for track 3
+type Tracks uint8
+
+const (
+	Track1 Tracks = 1 << iota
+	Track2
+	Track3
+
+	AllTracks = Track1 | Track2 | Track3
+)
+
+// ParseTracks parses a selection written as track numbers, such as "12" or "3"
+func ParseTracks(s string) (Tracks, error) {
+	var t Tracks
+	for _, c := range s {
+		if c < '1' || c > '3' || t.Has(int(c-'0')) {
+			return 0, fmt.Errorf("invalid tracks specification %q", s)
+		}
+		t |= 1 << (c - '1')
+	}
+	if t == 0 {
+		return 0, errors.New("no tracks selected")
+	}
+	return t, nil
+}
+
+// Has reports whether track n (1 to 3) is selected
+func (t Tracks) Has(n int) bool {
+	return n >= 1 && n <= 3 && t&(1<<(n-1)) != 0
+}
+
+// String lists the selected track numbers, as parsed by ParseTracks
+func (t Tracks) String() string {
+	var b strings.Builder
+	for n := 1; n <= 3; n++ {
+		if t.Has(n) {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	return b.String()
+}
+
+// TrackStatus is the outcome of reading one track
+type TrackStatus int
+
+const (
+	// TrackNotRead is the status of tracks that were not selected
+	TrackNotRead TrackStatus = iota
+	// TrackPresent is set when the track returned data
+	TrackPresent
+	// TrackEmpty is set when the device read the card without error and the
+	// track was blank
+	TrackEmpty
+	// TrackError is set when the device reported a read error and the track
+	// returned no data
+	TrackError
+)
+
+func (s TrackStatus) String() string {
+	switch s {
+	case TrackNotRead:
+		return "not read"
+	case TrackPresent:
+		return "present"
+	case TrackEmpty:
+		return "empty"
+	case TrackError:
+		return "read error"
+	}
+	return fmt.Sprintf("TrackStatus(%d)", int(s))
+}
+
+// LRCStatus is the outcome of checking the longitudinal redundancy check
+// character of a track
+type LRCStatus int
+
+const (
+	// LRCUnchecked is set for ISO reads, where the device checks the LRC and
+	// drops it from the data
+	LRCUnchecked LRCStatus = iota
+	// LRCOK is set when the LRC matches the data
+	LRCOK
+	// LRCMismatch is set when the LRC does not match the data
+	LRCMismatch
+	// LRCMissing is set when no LRC follows the data
+	LRCMissing
+)
+
+func (s LRCStatus) String() string {
+	switch s {
+	case LRCUnchecked:
+		return "unchecked"
+	case LRCOK:
+		return "ok"
+	case LRCMismatch:
+		return "mismatch"
+	case LRCMissing:
+		return "missing"
+	}
+	return fmt.Sprintf("LRCStatus(%d)", int(s))
+}
+
+// Track is one track of a read, with its data and what is known of the
+// quality of the read
+type Track struct {
+	// Number is the track number, 1 to 3
+	Number int
+	Status TrackStatus
+	// Err is the error the device reported when Status is TrackError, or why
+	// the raw data of a track present could not be decoded
+	Err error
+	// Data is the decoded track with its sentinels, as returned by ISO reads
+	Data string
+	// Raw is the track as returned by raw reads, nil for ISO reads
+	Raw []byte
+	// BPC is the number of bits per character of the encoding, parity
+	// included: the ISO encoding of the track for ISO reads, or the setting
+	// the raw data was unpacked with
+	BPC int
+	// BPI is the density set with Configure, 0 when unknown
+	BPI int
+	// Charset names the charset Data was decoded with
+	Charset string
+	// ParityErrors lists the positions in Data of characters with a parity
+	// error. The device rejects those in ISO reads.
+	ParityErrors []int
+	LRC          LRCStatus
+	// StartSentinel and EndSentinel are set when Data starts and ends with
+	// the sentinels of its charset
+	StartSentinel bool
+	EndSentinel   bool
+}
+
+// Valid reports whether the track returned data framed by both sentinels,
+// without parity or LRC errors
+func (t *Track) Valid() bool {
+	return t.Status == TrackPresent && t.Err == nil && t.StartSentinel && t.EndSentinel &&
+		len(t.ParityErrors) == 0 && t.LRC != LRCMismatch && t.LRC != LRCMissing
+}
+
+// isoTrack fills in a track read in ISO format
+func isoTrack(t *Track, cs *Charset) {
+	t.BPC = cs.CharBits()
+	t.Charset = cs.Name
+	t.StartSentinel = t.Data != "" && t.Data[0] == cs.Start
+	t.EndSentinel = len(t.Data) > 1 && t.Data[len(t.Data)-1] == cs.End
+}
+
+// rawTrack fills in a track read in raw format by unpacking it at bpc bits
+// per byte with cs
+func rawTrack(t *Track, raw string, cs *Charset, bpc int) {
+	t.Raw = []byte(raw)
+	t.BPC = bpc
+	d, err := cs.Unpack(raw, bpc)
+	if err != nil {
+		t.Err = err
+		return
+	}
+	t.Data = d.Text()
+	t.Charset = d.Charset
+	t.ParityErrors = parityErrorPositions(d)
+	switch {
+	case d.LRC == nil:
+		t.LRC = LRCMissing
+	case d.LRCError:
+		t.LRC = LRCMismatch
+	default:
+		t.LRC = LRCOK
+	}
+	t.StartSentinel = len(d.Chars) > 0 && d.Chars[0].Char == cs.Start
+	t.EndSentinel = d.EndSentinel
+}
+
+// parityErrorPositions lists the characters of d with a parity error
+func parityErrorPositions(d *BitDecoding) []int {
+	var positions []int
+	for pos, c := range d.Chars {
+		if c.ParityError {
+			positions = append(positions, pos)
+		}
+	}
+	return positions
+}
+
+// TrackResults holds the results of tracks 1 to 3
+type TrackResults [3]Track
+
+// TrackData returns the data of the tracks, empty for those not present
+func (r *TrackResults) TrackData() *TrackData {
+	return &TrackData{Track1: r[0].Data, Track2: r[1].Data, Track3: r[2].Data}
+}
+
+// Err returns the error of the first track that failed to read, or nil
+func (r *TrackResults) Err() error {
+	for i, t := range r {
+		if t.Status == TrackError {
+			return fmt.Errorf("track %d: %w", i+1, t.Err)
+		}
+	}
+	return nil
+}
+
+// ReadSelectedTracks reads the selected tracks in ISO format and reports the
+// status of each. Unlike ReadTracks, a read error on the device does not fail
+// the read as long as every selected track returned data, and a blank track is
+// reported as TrackEmpty while a track the device failed to read is reported as
+// TrackError. Use Err on the results to check that all selected tracks were read.
+func (m *MSR) ReadSelectedTracks(sel Tracks) (*TrackResults, error) {
+	results, err := m.readSelected(m.profile.Commands.ReadISO, sel, m.profile.Framing.ISOStrips)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if results[i].Status == TrackPresent {
+			isoTrack(&results[i], trackCharsets[i])
+		}
+	}
+	return results, nil
+}
+
+// ReadSelectedRawTracks reads the selected tracks in raw format, reporting the
+// status of each like ReadSelectedTracks. Each track present is unpacked with
+// its ISO charset at the BPC last set with Configure (the ISO width when
+// unknown), filling in its parity, LRC and sentinel checks.
+func (m *MSR) ReadSelectedRawTracks(sel Tracks) (*TrackResults, error) {
+	if err := m.profile.require("raw read", m.profile.Raw); err != nil {
+		return nil, err
+	}
+	results, err := m.readSelected(m.profile.Commands.ReadRaw, sel, m.profile.Framing.RawStrips)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if results[i].Status != TrackPresent {
+			continue
+		}
+		raw := results[i].Data
+		results[i].Data = ""
+		rawTrack(&results[i], raw, trackCharsets[i], trackBPC(i, m.settings.Tracks[i].BPC))
+	}
+	return results, nil
+}
+
+// readSelected runs a read command and sorts the selected strips by status. The
+// failure status of the device applies to the selected tracks that came back
+// empty; without a datablock it fails the whole read. The data of tracks
+// present is left in Data.
+func (m *MSR) readSelected(command string, sel Tracks, strips func([]byte, Redact) ([3]Span, error)) (*TrackResults, error) {
+	status, _, data, err := m.executeWaitResult(command, m.commandTimeout)
+	if err != nil {
+		return nil, err
+	}
+	statusErr := m.profile.checkStatus("read", status)
+
+	spans, err := strips([]byte(data), m.redact)
+	if err != nil {
+		if statusErr != nil {
+			return nil, statusErr
+		}
+		return nil, err
+	}
+
+	var results TrackResults
+	for i, s := range spans {
+		t := &results[i]
+		t.Number = i + 1
+		if !sel.Has(i + 1) {
+			continue
+		}
+		t.BPI = int(m.settings.Tracks[i].BPI)
+		switch {
+		case s.End > s.Start:
+			t.Status, t.Data = TrackPresent, data[s.Start:s.End]
+		case statusErr != nil:
+			t.Status, t.Err = TrackError, statusErr
+		default:
+			t.Status = TrackEmpty
+		}
+	}
+	return &results, nil
+}
+
+// WriteSelectedTracks writes the selected tracks in ISO format. Unselected
+// tracks are left out of the datablock so the device leaves them untouched,
+// where WriteTracks overwrites them with empty strips.
+func (m *MSR) WriteSelectedTracks(sel Tracks, t1, t2, t3 string) error {
+	if err := m.profile.require("write", !m.profile.ReadOnly); err != nil {
+		return err
+	}
+	return m.writeSelected(m.profile.Commands.WriteISO, encodeSelectedDataBlock(sel, [3]string{t1, t2, t3}, false), sel)
+}
+
+// WriteSelectedRawTracks writes the selected tracks in raw format, leaving the
+// others untouched like WriteSelectedTracks
+func (m *MSR) WriteSelectedRawTracks(sel Tracks, t1, t2, t3 string) error {
+	if err := m.profile.require("raw write", m.profile.Raw && !m.profile.ReadOnly); err != nil {
+		return err
+	}
+	return m.writeSelected(m.profile.Commands.WriteRaw, encodeSelectedDataBlock(sel, [3]string{t1, t2, t3}, true), sel)
+}
+
+// writeSelected sends a write command with its datablock holding the tracks
+// of sel
+func (m *MSR) writeSelected(command, data string, sel Tracks) error {
+	status, _, _, err := m.executeWaitResult(command+data, m.commandTimeout)
+	m.measureWrites(sel, status, err)
+	if err != nil {
+		return err
+	}
+	return m.profile.checkStatus("write", status)
+}
+
+// encodeSelectedDataBlock encodes the selected strips of a write datablock,
+// length prefixed when raw
+func encodeSelectedDataBlock(sel Tracks, strips [3]string, raw bool) string {
+	block := EscapeCode + "s"
+	for i, s := range strips {
+		if !sel.Has(i + 1) {
+			continue
+		}
+		block += EscapeCode + string(byte(i+1))
+		if raw {
+			block += string(byte(len(s)))
+		}
+		block += s
+	}
+	return block + "?" + EndCode
+}
+
+// selectedDataBlockStrips locates the strips of a write datablock in which
+// tracks may be left out, returning which ones it holds
+func selectedDataBlockStrips(data []byte, raw bool, p Redact) ([3]Span, Tracks, error) {
+	var strips [3]Span
+	var sel Tracks
+	if len(data) < 4 || string(data[:2]) != EscapeCode+"s" || string(data[len(data)-2:]) != "?"+EndCode {
+		return strips, 0, fmt.Errorf("bad datablock: %s", p.block(string(data), raw))
+	}
+
+	end := len(data) - 2
+	pos := 2
+	for pos < end {
+		if pos+2 > end || data[pos] != EscapeCode[0] {
+			return strips, 0, fmt.Errorf("bad datablock: missing <ESC> at position %d", pos)
+		}
+		n := int(data[pos+1])
+		if n < 1 || n > 3 || sel&^(1<<(n-1)-1) != 0 {
+			return strips, 0, fmt.Errorf("bad datablock: unexpected track %d at position %d", n, pos)
+		}
+		sel |= 1 << (n - 1)
+
+		start := pos + 2
+		var stop int
+		if raw {
+			if start >= end || start+1+int(data[start]) > end {
+				return strips, 0, fmt.Errorf("bad datablock: strip %d length exceeds block", n)
+			}
+			start, stop = start+1, start+1+int(data[start])
+		} else if stop = bytes.IndexByte(data[start:end], EscapeCode[0]); stop == -1 {
+			stop = end
+		} else {
+			stop += start
+		}
+		strips[n-1] = Span{Start: start, End: stop}
+		pos = stop
+	}
+	return strips, sel, nil
+}
@@ -0,0 +1,207 @@
+package magstripe
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseTracks(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Tracks
+	}{
+		{"1", Track1},
+		{"23", Track2 | Track3},
+		{"31", Track1 | Track3},
+		{"123", AllTracks},
+	}
+	for _, tt := range tests {
+		got, err := ParseTracks(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTracks(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "4", "11", "1a"} {
+		if _, err := ParseTracks(bad); err == nil {
+			t.Errorf("ParseTracks(%q) succeeded", bad)
+		}
+	}
+	if s := (Track3 | Track1).String(); s != "13" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestReadSelectedTracks(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+	sim.SetCard(TrackData{Track1: testBankTrack1, Track2: testBankTrack2})
+
+	results, err := m.ReadSelectedTracks(Track2 | Track3)
+	if err != nil {
+		t.Fatalf("ReadSelectedTracks: %v", err)
+	}
+	want := [3]TrackStatus{TrackNotRead, TrackPresent, TrackEmpty}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("track %d: status %v, want %v", i+1, r.Status, want[i])
+		}
+	}
+	if results[0].Data != "" || results[1].Data != testBankTrack2 || results.Err() != nil {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	// a failure on a track that was not selected does not fail the read
+	sim.FailTracks(Track1)
+	results, err = m.ReadSelectedTracks(Track2)
+	if err != nil || results.Err() != nil || results[1].Data != testBankTrack2 {
+		t.Errorf("unselected failure: %+v, %v", results, err)
+	}
+
+	// a selected track that failed is told apart from a blank one
+	sim.FailTracks(Track2)
+	results, err = m.ReadSelectedTracks(AllTracks)
+	if err != nil {
+		t.Fatalf("ReadSelectedTracks: %v", err)
+	}
+	want = [3]TrackStatus{TrackPresent, TrackError, TrackError}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("failed read, track %d: status %v, want %v", i+1, r.Status, want[i])
+		}
+	}
+	var statusErr *StatusError
+	if err := results.Err(); !errors.As(err, &statusErr) || statusErr.Status != '1' {
+		t.Errorf("Err() = %v", err)
+	}
+
+	sim.FailTracks(0)
+	sim.FailNext('1')
+	if _, err := m.ReadSelectedTracks(AllTracks); !errors.As(err, &statusErr) {
+		t.Errorf("failure without datablock: got %v", err)
+	}
+}
+
+func TestWriteSelectedTracks(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+	sim.SetCard(TrackData{Track1: "%A?", Track2: ";1?", Track3: ";2?"})
+
+	if err := m.WriteSelectedTracks(Track2, "ignored", ";3?", ""); err != nil {
+		t.Fatalf("WriteSelectedTracks: %v", err)
+	}
+	if got := sim.Card(); got != (TrackData{Track1: "%A?", Track2: ";3?", Track3: ";2?"}) {
+		t.Errorf("card after selective write: %+v", got)
+	}
+
+	if err := m.WriteSelectedTracks(Track1|Track3, "%B?", "", ""); err != nil {
+		t.Fatalf("WriteSelectedTracks: %v", err)
+	}
+	if got := sim.Card(); got != (TrackData{Track1: "%B?", Track2: ";3?", Track3: ""}) {
+		t.Errorf("card after second write: %+v", got)
+	}
+}
+
+func TestSelectedRawTracks(t *testing.T) {
+	m, _ := newSimulatedMSR(t)
+	if err := m.WriteRawTracks("\x01", "\x02", "\x03"); err != nil {
+		t.Fatalf("WriteRawTracks: %v", err)
+	}
+	if err := m.WriteSelectedRawTracks(Track3, "", "", "\x1b\x1c"); err != nil {
+		t.Fatalf("WriteSelectedRawTracks: %v", err)
+	}
+	results, err := m.ReadSelectedRawTracks(Track1 | Track3)
+	if err != nil {
+		t.Fatalf("ReadSelectedRawTracks: %v", err)
+	}
+	if string(results[0].Raw) != "\x01" || results[1].Status != TrackNotRead || string(results[2].Raw) != "\x1b\x1c" {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestReadTrackQuality(t *testing.T) {
+	m, sim := newSimulatedMSR(t)
+	sim.SetCard(TrackData{Track1: testBankTrack1, Track2: "4111"})
+
+	results, err := m.ReadSelectedTracks(AllTracks)
+	if err != nil {
+		t.Fatalf("ReadSelectedTracks: %v", err)
+	}
+	t1, t2 := results[0], results[1]
+	if t1.Number != 1 || t1.BPC != 7 || t1.Charset != "iata" || t1.LRC != LRCUnchecked || !t1.Valid() {
+		t.Errorf("ISO track 1: %+v", t1)
+	}
+	if t2.StartSentinel || t2.EndSentinel || t2.Valid() {
+		t.Errorf("ISO track 2 without sentinels: %+v", t2)
+	}
+
+	good, err := CharsetABA.Pack(";123=45?", 8, 0)
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	bad := []byte(good)
+	bad[1] ^= 0x10 // flips a data bit of the third character
+	if err := m.WriteRawTracks("", good, string(bad)); err != nil {
+		t.Fatalf("WriteRawTracks: %v", err)
+	}
+	if err := m.SetBPC(8, 8, 8); err != nil {
+		t.Fatalf("SetBPC: %v", err)
+	}
+	results, err = m.ReadSelectedRawTracks(AllTracks)
+	if err != nil {
+		t.Fatalf("ReadSelectedRawTracks: %v", err)
+	}
+	if results[0].Status != TrackEmpty {
+		t.Errorf("raw track 1: %+v", results[0])
+	}
+	t2 = results[1]
+	if t2.Data != ";123=45?" || t2.BPC != 8 || t2.LRC != LRCOK || !t2.StartSentinel || !t2.EndSentinel || !t2.Valid() {
+		t.Errorf("raw track 2: %+v", t2)
+	}
+	t3 := results[2]
+	if len(t3.ParityErrors) != 1 || t3.ParityErrors[0] != 2 || t3.LRC != LRCMismatch || t3.Valid() {
+		t.Errorf("raw track 3: %+v", t3)
+	}
+}
+
+func TestReadSelectedRawTracksISOWidth(t *testing.T) {
+	m, _ := newSimulatedMSR(t)
+
+	// without Configure the tracks are unpacked at the widths the device
+	// starts with: 7, 5 and 5 bits
+	var raw [3]string
+	for i, text := range []string{"%A1?", ";123=45?", ";9?"} {
+		var err error
+		if raw[i], err = trackCharsets[i].Pack(text, trackCharsets[i].CharBits(), 0); err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+	}
+	if err := m.WriteRawTracks(raw[0], raw[1], raw[2]); err != nil {
+		t.Fatalf("WriteRawTracks: %v", err)
+	}
+	results, err := m.ReadSelectedRawTracks(AllTracks)
+	if err != nil {
+		t.Fatalf("ReadSelectedRawTracks: %v", err)
+	}
+	for i, want := range []int{7, 5, 5} {
+		if r := results[i]; !r.Valid() || r.BPC != want {
+			t.Errorf("track %d: %+v", i+1, r)
+		}
+	}
+}
+
+func TestSelectedDataBlockStrips(t *testing.T) {
+	for _, raw := range []bool{false, true} {
+		block := encodeSelectedDataBlock(Track1|Track3, [3]string{"A", "skipped", "C"}, raw)
+		strips, sel, err := selectedDataBlockStrips([]byte(block), raw, RedactPCI)
+		if err != nil {
+			t.Fatalf("raw %v: %v", raw, err)
+		}
+		if sel != Track1|Track3 || block[strips[0].Start:strips[0].End] != "A" || block[strips[2].Start:strips[2].End] != "C" {
+			t.Errorf("raw %v: strips %v, tracks %v", raw, strips, sel)
+		}
+	}
+
+	for _, bad := range []string{"", "\x1bs?\x1c\x00", "\x1bs\x1b\x04A?\x1c", "\x1bs\x1b\x02A\x1b\x01B?\x1c", "\x1bsA?\x1c"} {
+		if _, _, err := selectedDataBlockStrips([]byte(bad), false, RedactPCI); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
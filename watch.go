@@ -0,0 +1,70 @@
+package magstripe
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Swipe is a card read delivered by Watch
+type Swipe struct {
+	Tracks *TrackData
+	Err    error
+	Time   time.Time
+}
+
+// Watch waits for swipes in a loop and delivers them until ctx is cancelled or
+// the connection fails. Swipes the device rejects are delivered with Err set and
+// watching continues. Cancellation takes effect when the pending read returns.
+// With a supervised reconnect policy, a lost device is delivered once with
+// ErrDisconnected and watching continues when it comes back.
+func (m *MSR) Watch(ctx context.Context) <-chan Swipe {
+	supervised := m.reconnect != nil && m.reconnect.Supervised
+	lost := false
+	return watch(ctx, func() (*TrackData, error) {
+		tracks, err := m.ReadTracks()
+		switch {
+		case errors.Is(err, ErrTimeout):
+			return nil, nil
+		case supervised && errors.Is(err, ErrDisconnected):
+			if lost {
+				select {
+				case <-time.After(m.reconnect.Interval):
+				case <-ctx.Done():
+				}
+				return nil, nil
+			}
+			lost = true
+		default:
+			lost = false
+		}
+		return tracks, err
+	}, func(err error) bool {
+		var statusErr *StatusError
+		return errors.As(err, &statusErr) || (supervised && errors.Is(err, ErrDisconnected))
+	})
+}
+
+// watch calls read until ctx is done. A nil result with no error means nothing
+// was swiped; errors for which recoverable returns false end the watch.
+func watch(ctx context.Context, read func() (*TrackData, error), recoverable func(error) bool) <-chan Swipe {
+	swipes := make(chan Swipe)
+	go func() {
+		defer close(swipes)
+		for ctx.Err() == nil {
+			tracks, err := read()
+			if tracks == nil && err == nil {
+				continue
+			}
+			select {
+			case swipes <- Swipe{Tracks: tracks, Err: err, Time: time.Now()}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil && !recoverable(err) {
+				return
+			}
+		}
+	}()
+	return swipes
+}
@@ -0,0 +1,297 @@
+package magstripe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// KeyFormat selects how a KeyboardReader decodes its input stream
+type KeyFormat int
+
+const (
+	// KeyFormatEvdev is a stream of Linux input_event structs as read from /dev/input/event*
+	KeyFormatEvdev KeyFormat = iota
+	// KeyFormatHID is a stream of 8 byte boot keyboard reports as read from /dev/hidraw*
+	KeyFormatHID
+)
+
+// inputEvent mirrors struct input_event of linux/input.h: a struct timeval,
+// whose size depends on the platform, followed by type, code and value
+type inputEvent struct {
+	Time  timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+var evdevEventSize = int(unsafe.Sizeof(inputEvent{}))
+
+const (
+	evKey        = 1
+	hidReportLen = 8
+	keyEnter     = '\n'
+	// hidErrorRollOver fills the key slots of a report with too many keys down
+	hidErrorRollOver = 0x01
+)
+
+// KeyboardReader reads swipes from a read-only reader that presents itself as a
+// USB keyboard and types the tracks as "%B...?;...?" followed by Enter
+type KeyboardReader struct {
+	r      io.Reader
+	closer io.Closer
+	format KeyFormat
+	shift  bool
+	keys   []byte
+	line   bytes.Buffer
+	// held are the keys down in the last HID report
+	held [hidReportLen - 2]byte
+}
+
+// NewKeyboardReader creates a KeyboardReader decoding keystrokes from r
+func NewKeyboardReader(r io.Reader, format KeyFormat) *KeyboardReader {
+	k := &KeyboardReader{r: r, format: format}
+	if c, ok := r.(io.Closer); ok {
+		k.closer = c
+	}
+	return k
+}
+
+// OpenKeyboardReader opens an evdev (/dev/input/event*) or hidraw (/dev/hidraw*)
+// node. Evdev devices are grabbed so swipes are not also typed into the console.
+func OpenKeyboardReader(path string) (*KeyboardReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keyboard device: %w", err)
+	}
+
+	format := KeyFormatEvdev
+	if strings.Contains(path, "hidraw") {
+		format = KeyFormatHID
+	} else if err := grabDevice(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to grab keyboard device: %w", err)
+	}
+	return NewKeyboardReader(f, format), nil
+}
+
+// Close closes the underlying device, unblocking any pending read
+func (k *KeyboardReader) Close() error {
+	if k.closer == nil {
+		return nil
+	}
+	return k.closer.Close()
+}
+
+// ReadTracks waits for the next complete swipe
+func (k *KeyboardReader) ReadTracks() (*TrackData, error) {
+	for {
+		ch, err := k.nextChar()
+		if err != nil {
+			if errors.Is(err, io.EOF) && k.line.Len() > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if ch != keyEnter {
+			k.line.WriteByte(ch)
+			continue
+		}
+		if k.line.Len() == 0 {
+			continue
+		}
+		line := k.line.String()
+		k.line.Reset()
+		return parseWedgeSwipe(line)
+	}
+}
+
+// ReadRawTracks is not supported since keyboard wedges only type decoded characters
+func (k *KeyboardReader) ReadRawTracks() (string, string, string, error) {
+	return "", "", "", fmt.Errorf("raw read on keyboard reader: %w", ErrUnsupported)
+}
+
+// Watch delivers swipes until ctx is cancelled or the input fails. Since reads
+// cannot be interrupted, cancelling only takes effect after the next keystroke
+// unless the reader is closed.
+func (k *KeyboardReader) Watch(ctx context.Context) <-chan Swipe {
+	return watch(ctx, k.ReadTracks, func(err error) bool {
+		var swipeErr *SwipeError
+		return errors.As(err, &swipeErr)
+	})
+}
+
+// nextChar returns the next typed character
+func (k *KeyboardReader) nextChar() (byte, error) {
+	for {
+		if len(k.keys) > 0 {
+			ch := k.keys[0]
+			k.keys = k.keys[1:]
+			return ch, nil
+		}
+
+		var err error
+		switch k.format {
+		case KeyFormatHID:
+			err = k.readHIDReport()
+		default:
+			err = k.readEvdevEvent()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readEvdevEvent decodes one input_event, queueing the character of a key press
+func (k *KeyboardReader) readEvdevEvent() error {
+	event := make([]byte, evdevEventSize)
+	if _, err := io.ReadFull(k.r, event); err != nil {
+		return err
+	}
+
+	tail := event[evdevEventSize-8:]
+	typ := binary.LittleEndian.Uint16(tail[0:])
+	code := binary.LittleEndian.Uint16(tail[2:])
+	value := int32(binary.LittleEndian.Uint32(tail[4:]))
+	if typ != evKey {
+		return nil
+	}
+
+	if code == evdevLeftShift || code == evdevRightShift {
+		k.shift = value != 0
+		return nil
+	}
+	// value 1 is a press, 2 an autorepeat and 0 a release
+	if value == 0 {
+		return nil
+	}
+	if ch := keyChar(evdevKeys, int(code), k.shift); ch != 0 {
+		k.keys = append(k.keys, ch)
+	}
+	return nil
+}
+
+// readHIDReport decodes one boot keyboard report, queueing newly pressed keys
+func (k *KeyboardReader) readHIDReport() error {
+	report := make([]byte, hidReportLen)
+	if _, err := io.ReadFull(k.r, report); err != nil {
+		return err
+	}
+
+	// too many keys down: the report lists none, and the held keys are unknown
+	if report[2] == hidErrorRollOver {
+		return nil
+	}
+	shift := report[0]&(hidLeftShift|hidRightShift) != 0
+	for _, usage := range report[2:] {
+		// keys held since the last report are not pressed again
+		if usage == 0 || bytes.IndexByte(k.held[:], usage) != -1 {
+			continue
+		}
+		if ch := keyChar(hidKeys, int(usage), shift); ch != 0 {
+			k.keys = append(k.keys, ch)
+		}
+	}
+	copy(k.held[:], report[2:])
+	return nil
+}
+
+// keyChar maps a key code to its US layout character, or 0 if it types nothing
+func keyChar(table map[int][2]byte, code int, shift bool) byte {
+	pair, ok := table[code]
+	if !ok {
+		return 0
+	}
+	if shift {
+		return pair[1]
+	}
+	return pair[0]
+}
+
+// SwipeError reports a swipe that could not be decoded
+type SwipeError struct {
+	Data string
+}
+
+func (e *SwipeError) Error() string {
+	return fmt.Sprintf("bad swipe: %q", RedactPCI.Text(e.Data))
+}
+
+// parseWedgeSwipe splits a typed swipe into its tracks. Track 1 starts with '%',
+// track 2 with ';' and track 3 with '+' or a second ';'. Readers type "E" in
+// place of a track they failed to decode, which is returned as an empty track.
+func parseWedgeSwipe(line string) (*TrackData, error) {
+	tracks := &TrackData{}
+	seenTrack2 := false
+	for rest := line; rest != ""; {
+		end := strings.IndexByte(rest, '?')
+		if end < 1 {
+			return nil, &SwipeError{Data: line}
+		}
+		field := rest[:end+1]
+		rest = rest[end+1:]
+
+		sentinel := field[0]
+		if field[1:] == "E?" {
+			field = ""
+		}
+		switch {
+		case sentinel == '%':
+			tracks.Track1 = field
+		case sentinel == ';' && !seenTrack2:
+			tracks.Track2 = field
+			seenTrack2 = true
+		case sentinel == ';' || sentinel == '+':
+			tracks.Track3 = field
+		default:
+			return nil, &SwipeError{Data: line}
+		}
+	}
+	return tracks, nil
+}
+
+// Modifier keys
+const (
+	evdevLeftShift  = 42
+	evdevRightShift = 54
+	hidLeftShift    = 0x02
+	hidRightShift   = 0x20
+)
+
+// evdevKeys maps Linux KEY_* codes to unshifted and shifted US layout characters
+var evdevKeys = map[int][2]byte{
+	2: {'1', '!'}, 3: {'2', '@'}, 4: {'3', '#'}, 5: {'4', '$'}, 6: {'5', '%'},
+	7: {'6', '^'}, 8: {'7', '&'}, 9: {'8', '*'}, 10: {'9', '('}, 11: {'0', ')'},
+	12: {'-', '_'}, 13: {'=', '+'},
+	16: {'q', 'Q'}, 17: {'w', 'W'}, 18: {'e', 'E'}, 19: {'r', 'R'}, 20: {'t', 'T'},
+	21: {'y', 'Y'}, 22: {'u', 'U'}, 23: {'i', 'I'}, 24: {'o', 'O'}, 25: {'p', 'P'},
+	26: {'[', '{'}, 27: {']', '}'}, 28: {keyEnter, keyEnter},
+	30: {'a', 'A'}, 31: {'s', 'S'}, 32: {'d', 'D'}, 33: {'f', 'F'}, 34: {'g', 'G'},
+	35: {'h', 'H'}, 36: {'j', 'J'}, 37: {'k', 'K'}, 38: {'l', 'L'},
+	39: {';', ':'}, 40: {'\'', '"'}, 41: {'`', '~'}, 43: {'\\', '|'},
+	44: {'z', 'Z'}, 45: {'x', 'X'}, 46: {'c', 'C'}, 47: {'v', 'V'}, 48: {'b', 'B'},
+	49: {'n', 'N'}, 50: {'m', 'M'}, 51: {',', '<'}, 52: {'.', '>'}, 53: {'/', '?'},
+	57: {' ', ' '}, 96: {keyEnter, keyEnter},
+}
+
+// hidKeys maps HID keyboard usage IDs to unshifted and shifted US layout characters
+var hidKeys = map[int][2]byte{
+	4: {'a', 'A'}, 5: {'b', 'B'}, 6: {'c', 'C'}, 7: {'d', 'D'}, 8: {'e', 'E'},
+	9: {'f', 'F'}, 10: {'g', 'G'}, 11: {'h', 'H'}, 12: {'i', 'I'}, 13: {'j', 'J'},
+	14: {'k', 'K'}, 15: {'l', 'L'}, 16: {'m', 'M'}, 17: {'n', 'N'}, 18: {'o', 'O'},
+	19: {'p', 'P'}, 20: {'q', 'Q'}, 21: {'r', 'R'}, 22: {'s', 'S'}, 23: {'t', 'T'},
+	24: {'u', 'U'}, 25: {'v', 'V'}, 26: {'w', 'W'}, 27: {'x', 'X'}, 28: {'y', 'Y'},
+	29: {'z', 'Z'},
+	30: {'1', '!'}, 31: {'2', '@'}, 32: {'3', '#'}, 33: {'4', '$'}, 34: {'5', '%'},
+	35: {'6', '^'}, 36: {'7', '&'}, 37: {'8', '*'}, 38: {'9', '('}, 39: {'0', ')'},
+	40: {keyEnter, keyEnter}, 44: {' ', ' '}, 45: {'-', '_'}, 46: {'=', '+'},
+	47: {'[', '{'}, 48: {']', '}'}, 49: {'\\', '|'}, 51: {';', ':'}, 52: {'\'', '"'},
+	53: {'`', '~'}, 54: {',', '<'}, 55: {'.', '>'}, 56: {'/', '?'}, 88: {keyEnter, keyEnter},
+}
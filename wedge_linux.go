@@ -0,0 +1,21 @@
+package magstripe
+
+import (
+	"os"
+	"syscall"
+)
+
+// timeval is the struct timeval of input events
+type timeval = syscall.Timeval
+
+// EVIOCGRAB ioctl request number
+const eviocgrab = 0x40044590
+
+// grabDevice takes exclusive access to an evdev device
+func grabDevice(f *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), eviocgrab, 1)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package magstripe
+
+import "os"
+
+// timeval is the struct timeval of input events from a Linux system with the
+// same word size, as evdev streams only come from Linux
+type timeval struct {
+	Sec  int
+	Usec int
+}
+
+// grabDevice is a no-op where evdev is not available
+func grabDevice(f *os.File) error {
+	return nil
+}
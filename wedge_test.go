@@ -0,0 +1,225 @@
+package magstripe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// typeEvdev records the input_events a keyboard wedge emits when typing s
+func typeEvdev(s string) []byte {
+	var buf bytes.Buffer
+	event := func(code uint16, value int32) {
+		ev := make([]byte, evdevEventSize)
+		tail := ev[evdevEventSize-8:]
+		binary.LittleEndian.PutUint16(tail[0:], evKey)
+		binary.LittleEndian.PutUint16(tail[2:], code)
+		binary.LittleEndian.PutUint32(tail[4:], uint32(value))
+		buf.Write(ev)
+		// EV_SYN separators are ignored by the reader
+		buf.Write(make([]byte, evdevEventSize))
+	}
+
+	for i := 0; i < len(s); i++ {
+		code, shift := findKey(evdevKeys, s[i])
+		if shift {
+			event(evdevLeftShift, 1)
+		}
+		event(uint16(code), 1)
+		event(uint16(code), 0)
+		if shift {
+			event(evdevLeftShift, 0)
+		}
+	}
+	return buf.Bytes()
+}
+
+// typeHID records the boot keyboard reports a keyboard wedge emits when typing s
+func typeHID(s string) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		code, shift := findKey(hidKeys, s[i])
+		var mod byte
+		if shift {
+			mod = hidRightShift
+		}
+		buf.Write([]byte{mod, 0, byte(code), 0, 0, 0, 0, 0})
+		buf.Write(make([]byte, hidReportLen))
+	}
+	return buf.Bytes()
+}
+
+func findKey(table map[int][2]byte, ch byte) (int, bool) {
+	for code, pair := range table {
+		if pair[0] == ch {
+			return code, false
+		}
+	}
+	for code, pair := range table {
+		if pair[1] == ch {
+			return code, true
+		}
+	}
+	panic("no key for " + string(ch))
+}
+
+const wedgeSwipe = "%B4111111111111111^DOE/JOHN^2512101?;4111111111111111=2512101?\n"
+
+func TestKeyboardReaderEvdev(t *testing.T) {
+	k := NewKeyboardReader(bytes.NewReader(typeEvdev(wedgeSwipe)), KeyFormatEvdev)
+
+	tracks, err := k.ReadTracks()
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if tracks.Track1 != "%B4111111111111111^DOE/JOHN^2512101?" {
+		t.Errorf("Track1 mismatch: %q", tracks.Track1)
+	}
+	if tracks.Track2 != ";4111111111111111=2512101?" {
+		t.Errorf("Track2 mismatch: %q", tracks.Track2)
+	}
+	if tracks.Track3 != "" {
+		t.Errorf("Track3 should be empty: %q", tracks.Track3)
+	}
+
+	if _, err := k.ReadTracks(); err != io.EOF {
+		t.Errorf("expected EOF after last swipe, got %v", err)
+	}
+}
+
+func TestKeyboardReaderHID(t *testing.T) {
+	input := typeHID(";123=456?;789?\n")
+	k := NewKeyboardReader(bytes.NewReader(input), KeyFormatHID)
+
+	tracks, err := k.ReadTracks()
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if tracks.Track2 != ";123=456?" || tracks.Track3 != ";789?" {
+		t.Errorf("unexpected tracks: %+v", tracks)
+	}
+}
+
+func TestKeyboardReaderHIDRollover(t *testing.T) {
+	key := func(ch byte) byte {
+		code, _ := findKey(hidKeys, ch)
+		return byte(code)
+	}
+	rollover := bytes.Repeat([]byte{hidErrorRollOver}, hidReportLen-2)
+	var input bytes.Buffer
+	for _, r := range [][]byte{
+		append([]byte{0, 0}, key(';'), 0, 0, 0, 0, 0),
+		// the next key goes down before the previous one is released
+		append([]byte{0, 0}, key(';'), key('1'), 0, 0, 0, 0),
+		append([]byte{0, 0}, key('1'), 0, 0, 0, 0, 0),
+		append([]byte{0, 0}, key('1'), key('2'), 0, 0, 0, 0),
+		append([]byte{0, 0}, rollover...),
+		append([]byte{0, 0}, key('2'), key('1'), 0, 0, 0, 0),
+		append([]byte{0, 0}, 0, 0, 0, 0, 0, 0),
+		// released and pressed again
+		append([]byte{0, 0}, key('1'), 0, 0, 0, 0, 0),
+		append([]byte{hidRightShift, 0}, key('1'), key('/'), 0, 0, 0, 0),
+		append([]byte{0, 0}, key('\n'), 0, 0, 0, 0, 0),
+	} {
+		input.Write(r)
+	}
+
+	k := NewKeyboardReader(&input, KeyFormatHID)
+	tracks, err := k.ReadTracks()
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if tracks.Track2 != ";121?" {
+		t.Errorf("expected each key once per press, got %+v", tracks)
+	}
+}
+
+func TestKeyboardReaderPartialSwipe(t *testing.T) {
+	k := NewKeyboardReader(bytes.NewReader(typeEvdev("%B123")), KeyFormatEvdev)
+	if _, err := k.ReadTracks(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestParseWedgeSwipe(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    TrackData
+		wantErr bool
+	}{
+		{line: "%A?;1?+2?", want: TrackData{Track1: "%A?", Track2: ";1?", Track3: "+2?"}},
+		{line: "%E?;1?", want: TrackData{Track2: ";1?"}},
+		{line: "%E?;E?;3?", want: TrackData{Track3: ";3?"}},
+		{line: "%ABC", wantErr: true},
+		{line: "garbage?", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseWedgeSwipe(tt.line)
+		if tt.wantErr {
+			var swipeErr *SwipeError
+			if !errors.As(err, &swipeErr) {
+				t.Errorf("%q: expected SwipeError, got %v", tt.line, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.line, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("%q: expected %+v, got %+v", tt.line, tt.want, *got)
+		}
+	}
+}
+
+func TestKeyboardReaderWatch(t *testing.T) {
+	input := typeEvdev("%A?\nbad?\n;1?\n")
+	k := NewKeyboardReader(bytes.NewReader(input), KeyFormatEvdev)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var swipes []Swipe
+	for s := range k.Watch(ctx) {
+		swipes = append(swipes, s)
+	}
+
+	// Two swipes, one rejected swipe and the EOF that ends the watch
+	if len(swipes) != 4 {
+		t.Fatalf("expected 4 swipes, got %d: %+v", len(swipes), swipes)
+	}
+	if swipes[0].Tracks.Track1 != "%A?" || swipes[2].Tracks.Track2 != ";1?" {
+		t.Errorf("unexpected swipes: %+v", swipes)
+	}
+	if swipes[1].Err == nil || swipes[3].Err != io.EOF {
+		t.Errorf("unexpected errors: %v, %v", swipes[1].Err, swipes[3].Err)
+	}
+}
+
+func TestMSRWatch(t *testing.T) {
+	block := encodeISODataBlock("%A?", ";1?", "")
+	m, _ := newScriptedMSR(t, ProfileMSR605, block+EscapeCode+"0", EscapeCode+"1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	swipes := m.Watch(ctx)
+
+	first := <-swipes
+	if first.Err != nil || first.Tracks.Track1 != "%A?" {
+		t.Errorf("unexpected first swipe: %+v", first)
+	}
+	second := <-swipes
+	var statusErr *StatusError
+	if !errors.As(second.Err, &statusErr) {
+		t.Errorf("expected status error, got %v", second.Err)
+	}
+
+	cancel()
+	for range swipes {
+	}
+}